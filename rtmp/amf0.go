@@ -51,6 +51,22 @@ const AMF0_OriginStrictArray = 0x20
 // User defined
 const AMF0_Invalid = 0x3F
 
+/**
+* the default max properties of a decoded object or ecma array,
+* to avoid the malicious object with millions of properties
+* to exhaust the memory, decode failed when exceed it.
+* @see Amf0Codec.SetMaxProperties
+*/
+const AMF0_DEFAULT_MAX_PROPERTIES = 4096
+
+/**
+* the default max depth of the nested objects or ecma arrays, to avoid the
+* malicious object nested thousands of levels to overflow the stack,
+* decode failed when exceed it, the top level object is depth 1.
+* @see Amf0Codec.SetMaxDepth
+*/
+const AMF0_DEFAULT_MAX_DEPTH = 32
+
 /**
 * to ensure in inserted order.
 * for the FMLE will crash when AMF0Object is not ordered by inserted,
@@ -106,6 +122,11 @@ func (r *Amf0UnSortedHashtable) Set(k string, v *Amf0Any) (err error) {
 	r.properties[k] = v
 	return
 }
+// get the property of any type, for example, the "type" of connect object.
+func (r *Amf0UnSortedHashtable) Get(k string) (v *Amf0Any, ok bool) {
+	v, ok = r.properties[k]
+	return
+}
 func (r *Amf0UnSortedHashtable) GetPropertyString(k string) (v string, ok bool) {
 	var prop *Amf0Any
 	if prop, ok = r.properties[k]; !ok {
@@ -138,12 +159,10 @@ func NewAmf0Object() (*Amf0Object) {
 	return r
 }
 
+// the empty object still write the marker and EOF.
 func (r *Amf0Object) Size() (n int) {
-	if n = r.properties.Size(); n <= 0 {
-		return 0
-	}
-
-	n += 1
+	n = 1
+	n += r.properties.Size()
 	n += Amf0SizeObjectEOF()
 	return
 }
@@ -159,7 +178,18 @@ func (r *Amf0Object) Read(codec *Amf0Codec) (err error) {
 		return
 	}
 
-	for !codec.stream.Empty() {
+	if err = codec.enter_object(); err != nil {
+		return
+	}
+	defer codec.leave_object()
+
+	for {
+		// AMF0 Object EOF, the 0x00 0x00 0x09 is required.
+		var eof bool
+		if eof, err = codec.read_object_eof(); err != nil || eof {
+			return
+		}
+
 		// property-name: utf8 string
 		var property_name string
 		if property_name, err = codec.ReadUtf8(); err != nil {
@@ -172,17 +202,15 @@ func (r *Amf0Object) Read(codec *Amf0Codec) (err error) {
 			return
 		}
 
-		// AMF0 Object EOF.
-		if len(property_name) <= 0 || property_value.IsNil() || property_value.IsObjectEof() {
-			break
-		}
-
 		// add property
+		if r.properties.Count() >= codec.max_properties {
+			err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 object properties exceed max %v", codec.max_properties)}
+			return
+		}
 		if err = r.Set(property_name, &property_value); err != nil {
 			return
 		}
 	}
-	return
 }
 func (r *Amf0Object) Write(codec *Amf0Codec) (err error) {
 	// marker
@@ -203,6 +231,9 @@ func (r *Amf0Object) Write(codec *Amf0Codec) (err error) {
 func (r *Amf0Object) Set(k string, v *Amf0Any) (err error) {
 	return r.properties.Set(k, v)
 }
+func (r *Amf0Object) Get(k string) (v *Amf0Any, ok bool) {
+	return r.properties.Get(k)
+}
 func (r *Amf0Object) GetPropertyString(k string) (v string, ok bool) {
 	return r.properties.GetPropertyString(k)
 }
@@ -229,13 +260,11 @@ func NewAmf0EcmaArray() (*Amf0EcmaArray) {
 	return r
 }
 
+// the empty ecma array still write the marker, count and EOF.
 func (r *Amf0EcmaArray) Size() (n int) {
-	if n = r.properties.Size(); n <= 0 {
-		return 0
-	}
-
-	n += 1
+	n = 1
 	n += 4
+	n += r.properties.Size()
 	n += Amf0SizeObjectEOF()
 	return
 }
@@ -252,6 +281,11 @@ func (r *Amf0EcmaArray) Read(codec *Amf0Codec) (err error) {
 		return
 	}
 
+	if err = codec.enter_object(); err != nil {
+		return
+	}
+	defer codec.leave_object()
+
 	// count
 	if !codec.stream.Requires(4) {
 		err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 read ecma_array count failed"}
@@ -259,7 +293,13 @@ func (r *Amf0EcmaArray) Read(codec *Amf0Codec) (err error) {
 	}
 	r.count = codec.stream.ReadUInt32()
 
-	for !codec.stream.Empty() {
+	for {
+		// AMF0 Object EOF, the 0x00 0x00 0x09 is required.
+		var eof bool
+		if eof, err = codec.read_object_eof(); err != nil || eof {
+			return
+		}
+
 		// property-name: utf8 string
 		var property_name string
 		if property_name, err = codec.ReadUtf8(); err != nil {
@@ -272,17 +312,15 @@ func (r *Amf0EcmaArray) Read(codec *Amf0Codec) (err error) {
 			return
 		}
 
-		// AMF0 Object EOF.
-		if len(property_name) <= 0 || property_value.IsNil() || property_value.IsObjectEof() {
-			break
-		}
-
 		// add property
+		if r.properties.Count() >= codec.max_properties {
+			err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 EcmaArray properties exceed max %v", codec.max_properties)}
+			return
+		}
 		if err = r.Set(property_name, &property_value); err != nil {
 			return
 		}
 	}
-	return
 }
 // srs_amf0_write_ecma_array
 func (r *Amf0EcmaArray) Write(codec *Amf0Codec) (err error) {
@@ -313,6 +351,9 @@ func (r *Amf0EcmaArray) Set(k string, v *Amf0Any) (err error) {
 	r.count = uint32(r.properties.Count())
 	return
 }
+func (r *Amf0EcmaArray) Get(k string) (v *Amf0Any, ok bool) {
+	return r.properties.Get(k)
+}
 func (r *Amf0EcmaArray) GetPropertyString(k string) (v string, ok bool) {
 	return r.properties.GetPropertyString(k)
 }
@@ -348,6 +389,8 @@ func NewAmf0(v interface {}) (*Amf0Any) {
 		return &Amf0Any{ Marker:AMF0_Object, Value:t }
 	case *Amf0EcmaArray:
 		return &Amf0Any{ Marker:AMF0_EcmaArray, Value:t }
+	case []*Amf0Any:
+		return &Amf0Any{ Marker:AMF0_StrictArray, Value:t }
 	}
 	return nil
 }
@@ -376,6 +419,9 @@ func (r *Amf0Any) Size() (int) {
 	case r.Marker == AMF0_EcmaArray:
 		v, _ := r.EcmaArray()
 		return v.Size()
+	case r.Marker == AMF0_StrictArray:
+		v, _ := r.StrictArray()
+		return Amf0SizeStrictArray(v)
 		// TODO: FIXME: implements it.
 	}
 	return 0
@@ -403,9 +449,12 @@ func (r *Amf0Any) Write(codec *Amf0Codec) (err error) {
 	case r.Marker == AMF0_EcmaArray:
 		v, _ := r.EcmaArray()
 		return v.Write(codec)
+	case r.Marker == AMF0_StrictArray:
+		v, _ := r.StrictArray()
+		return codec.WriteStrictArray(v)
 		// TODO: FIXME: implements it.
 	}
-	return
+	return Error{code:ERROR_RTMP_AMF0_ENCODE, desc:fmt.Sprintf("amf0 write marker not support. marker=%#x", r.Marker)}
 }
 func (r *Amf0Any) Read(codec *Amf0Codec) (err error) {
 	// marker
@@ -417,7 +466,9 @@ func (r *Amf0Any) Read(codec *Amf0Codec) (err error) {
 	codec.stream.Skip(-1)
 
 	switch {
-	case r.Marker == AMF0_String:
+	case r.Marker == AMF0_String || r.Marker == AMF0_LongString:
+		// the long string is the string value, encode by its length.
+		r.Marker = AMF0_String
 		r.Value, err = codec.ReadString()
 	case r.Marker == AMF0_Boolean:
 		r.Value, err = codec.ReadBoolean()
@@ -429,6 +480,24 @@ func (r *Amf0Any) Read(codec *Amf0Codec) (err error) {
 		r.Value, err = codec.ReadObject()
 	case r.Marker == AMF0_EcmaArray:
 		r.Value, err = codec.ReadEcmaArray()
+	case r.Marker == AMF0_StrictArray:
+		r.Value, err = codec.ReadStrictArray()
+	case r.Marker == AMF0_AVMplusObject:
+		// switch to AMF3, convert the AMF3 value to AMF0.
+		codec.stream.ReadByte()
+
+		var v *Amf0Any
+		if codec.amf3 == nil {
+			codec.amf3 = NewAmf3Codec(codec.stream)
+			codec.amf3.max_depth = codec.max_depth
+			codec.amf3.max_properties = codec.max_properties
+		}
+		// the nested amf3 value is in the amf0 object.
+		codec.amf3.depth = codec.depth
+		if v, err = codec.amf3.ReadAny(); err != nil {
+			return
+		}
+		r.Marker, r.Value = v.Marker, v.Value
 	// TODO: FIXME: implements it.
 	default:
 		err = Error{code:ERROR_RTMP_AMF0_INVALID, desc:fmt.Sprintf("invalid amf0 message type. marker=%#x", r.Marker)}
@@ -442,6 +511,16 @@ func (r *Amf0Any) IsNil() (v bool) {
 func (r *Amf0Any) IsObjectEof() (v bool) {
 	return r.Marker == AMF0_ObjectEnd
 }
+// whether the object or ecma array without any property.
+func (r *Amf0Any) is_empty() (v bool) {
+	if o, ok := r.Object(); ok {
+		return len(o.properties.property_index) == 0
+	}
+	if o, ok := r.EcmaArray(); ok {
+		return len(o.properties.property_index) == 0
+	}
+	return false
+}
 func (r *Amf0Any) Object() (v *Amf0Object, ok bool) {
 	if r.Marker == AMF0_Object {
 		v, ok = r.Value.(*Amf0Object), true
@@ -454,6 +533,12 @@ func (r *Amf0Any) EcmaArray() (v *Amf0EcmaArray, ok bool) {
 	}
 	return
 }
+func (r *Amf0Any) StrictArray() (v []*Amf0Any, ok bool) {
+	if r.Marker == AMF0_StrictArray {
+		v, ok = r.Value.([]*Amf0Any), true
+	}
+	return
+}
 func (r *Amf0Any) String() (v string, ok bool) {
 	if r.Marker == AMF0_String {
 		v, ok = r.Value.(string), true
@@ -475,15 +560,53 @@ func (r *Amf0Any) Boolean() (v bool, ok bool) {
 
 type Amf0Codec struct {
 	stream *Buffer
+	// the amf3 codec for AVMplusObject, the reference tables of
+	// amf3 is shared by all amf3 values of message.
+	amf3 *Amf3Codec
+	// the depth of the nested objects in decoding, @see max_depth.
+	depth int
+	// the max depth of the nested objects, @see AMF0_DEFAULT_MAX_DEPTH.
+	max_depth int
+	// the max properties of object or array, @see AMF0_DEFAULT_MAX_PROPERTIES.
+	max_properties int
 }
 func NewAmf0Codec(stream *Buffer) (*Amf0Codec) {
 	r := Amf0Codec{}
 	r.stream = stream
+	r.max_depth = AMF0_DEFAULT_MAX_DEPTH
+	r.max_properties = AMF0_DEFAULT_MAX_PROPERTIES
 	return &r
 }
 
+/**
+* set the max depth of the nested objects or ecma arrays, include the
+* nested amf3 values, for example, 100 for the deep nested metadata.
+* @remark set it before decode, it is not goroutine safe.
+*/
+func (r *Amf0Codec) SetMaxDepth(v int) {
+	r.max_depth = v
+	if r.amf3 != nil {
+		r.amf3.SetMaxDepth(v)
+	}
+}
+
+/**
+* set the max properties of the object, ecma array or strict array,
+* include the nested amf3 values, for example, 65536 for the huge metadata.
+* @remark set it before decode, it is not goroutine safe.
+*/
+func (r *Amf0Codec) SetMaxProperties(v int) {
+	r.max_properties = v
+	if r.amf3 != nil {
+		r.amf3.SetMaxProperties(v)
+	}
+}
+
 // Size
 func Amf0SizeString(v string) (int) {
+	if len(v) > 0xffff {
+		return 1 + 4 + len(v)
+	}
 	return 1 + Amf0SizeUtf8(v)
 }
 func Amf0SizeUtf8(v string) (int) {
@@ -501,6 +624,21 @@ func Amf0SizeBoolean() (int) {
 func Amf0SizeObjectEOF() (int) {
 	return 2 + 1
 }
+func Amf0SizeObject(v *Amf0Object) (int) {
+	return v.Size()
+}
+func Amf0SizeEcmaArray(v *Amf0EcmaArray) (int) {
+	return v.Size()
+}
+func Amf0SizeStrictArray(v []*Amf0Any) (n int) {
+	n = 1 + 4
+	for _, e := range v {
+		if e != nil {
+			n += e.Size()
+		}
+	}
+	return
+}
 
 // srs_amf0_read_string
 func (r *Amf0Codec) ReadString() (v string, err error) {
@@ -510,12 +648,26 @@ func (r *Amf0Codec) ReadString() (v string, err error) {
 		return
 	}
 
-	if marker := r.stream.ReadByte(); marker != AMF0_String {
+	marker := r.stream.ReadByte()
+	if marker == AMF0_String {
+		return r.ReadUtf8()
+	}
+	if marker != AMF0_LongString {
 		err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 string marker invalid"}
 		return
 	}
 
-	v, err = r.ReadUtf8()
+	// 2.14 Long String Type, the 4bytes length.
+	if !r.stream.Requires(4) {
+		err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 long string len requires 4bytes"}
+		return
+	}
+	n := r.stream.ReadUInt32()
+	if uint64(n) > uint64(r.stream.Left()) {
+		err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 long string data requires more bytes"}
+		return
+	}
+	v = string(r.stream.Read(int(n)))
 	return
 }
 // srs_amf0_write_string
@@ -525,6 +677,17 @@ func (r *Amf0Codec) WriteString(v string) (err error) {
 		err = Error{code:ERROR_RTMP_AMF0_ENCODE, desc:"amf0 write string marker failed"}
 		return
 	}
+	// 2.14 Long String Type, for the string exceed 65535 bytes.
+	if len(v) > 0xffff {
+		r.stream.WriteByte(byte(AMF0_LongString))
+		if !r.stream.Requires(4 + len(v)) {
+			err = Error{code:ERROR_RTMP_AMF0_ENCODE, desc:"amf0 write long string failed"}
+			return
+		}
+		r.stream.WriteUInt32(uint32(len(v))).Write([]byte(v))
+		return
+	}
+
 	r.stream.WriteByte(byte(AMF0_String))
 	return r.WriteUtf8(v)
 }
@@ -585,6 +748,12 @@ func (r *Amf0Codec) ReadUtf8() (v string, err error) {
 }
 // srs_amf0_write_utf8
 func (r *Amf0Codec) WriteUtf8(v string) (err error) {
+	// the property name never use long string.
+	if len(v) > 0xffff {
+		err = Error{code:ERROR_RTMP_AMF0_ENCODE, desc:fmt.Sprintf("amf0 utf8 length %v exceed 65535", len(v))}
+		return
+	}
+
 	// len
 	if !r.stream.Requires(2) {
 		err = Error{code:ERROR_RTMP_AMF0_ENCODE, desc:"amf0 write string length failed"}
@@ -706,6 +875,12 @@ func (r *Amf0Codec) ReadBoolean() (v bool, err error) {
 
 	return
 }
+// srs_amf0_read_any
+func (r *Amf0Codec) ReadAny() (v *Amf0Any, err error) {
+	// value
+	v = &Amf0Any{}
+	return v, v.Read(r)
+}
 // srs_amf0_read_object
 func (r *Amf0Codec) ReadObject() (v *Amf0Object, err error) {
 	// value
@@ -718,6 +893,13 @@ func (r *Amf0Codec) ReadEcmaArray() (v *Amf0EcmaArray, err error) {
 	v = NewAmf0EcmaArray()
 	return v, v.Read(r)
 }
+// srs_amf0_write_any
+func (r *Amf0Codec) WriteAny(v *Amf0Any) (err error) {
+	if v == nil {
+		return Error{code:ERROR_GO_AMF0_NIL_PROPERTY, desc:"amf0 write any should never be nil"}
+	}
+	return v.Write(r)
+}
 // srs_amf0_write_object
 func (r *Amf0Codec) WriteObject(v *Amf0Object) (err error) {
 	return v.Write(r)
@@ -726,6 +908,90 @@ func (r *Amf0Codec) WriteObject(v *Amf0Object) (err error) {
 func (r *Amf0Codec) WriteEcmaArray(v *Amf0EcmaArray) (err error) {
 	return v.Write(r)
 }
+/**
+* 2.12 Strict Array Type
+* array-count = U32
+* strict-array-type = array-count *(value-type)
+*/
+// srs_amf0_read_strict_array
+func (r *Amf0Codec) ReadStrictArray() (v []*Amf0Any, err error) {
+	// marker and count
+	if !r.stream.Requires(1 + 4) {
+		err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 StrictArray requires 5bytes marker and count"}
+		return
+	}
+	if marker := r.stream.ReadByte(); marker != AMF0_StrictArray {
+		err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 StrictArray marker invalid"}
+		return
+	}
+
+	if err = r.enter_object(); err != nil {
+		return
+	}
+	defer r.leave_object()
+
+	// each value is 1byte at least, never alloc for the fake count.
+	count := r.stream.ReadUInt32()
+	if count > uint32(r.max_properties) || count > uint32(r.stream.Left()) {
+		err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 StrictArray count %v exceed max %v or left %v bytes", count, r.max_properties, r.stream.Left())}
+		return
+	}
+
+	v = make([]*Amf0Any, 0, count)
+	for i := 0; i < int(count); i++ {
+		var value Amf0Any
+		if err = value.Read(r); err != nil {
+			return
+		}
+		v = append(v, &value)
+	}
+	return
+}
+// srs_amf0_write_strict_array
+func (r *Amf0Codec) WriteStrictArray(v []*Amf0Any) (err error) {
+	if !r.stream.Requires(1 + 4) {
+		err = Error{code:ERROR_RTMP_AMF0_ENCODE, desc:"amf0 write StrictArray marker and count failed"}
+		return
+	}
+	r.stream.WriteByte(byte(AMF0_StrictArray))
+	r.stream.WriteUInt32(uint32(len(v)))
+
+	for _, e := range v {
+		if err = r.WriteAny(e); err != nil {
+			return
+		}
+	}
+	return
+}
+// enter the nested object or ecma array, decode failed when exceed max_depth.
+func (r *Amf0Codec) enter_object() (err error) {
+	if r.depth >= r.max_depth {
+		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 object depth exceed max %v", r.max_depth)}
+	}
+	r.depth++
+	return
+}
+func (r *Amf0Codec) leave_object() {
+	r.depth--
+}
+/**
+* whether the stream starts with the object EOF, the 0x00 0x00 0x09,
+* consume it if true. the object without EOF is malformed, for example,
+* the truncated object, so the EOF must be in the left bytes.
+*/
+// srs_amf0_is_object_eof
+func (r *Amf0Codec) read_object_eof() (eof bool, err error) {
+	if !r.stream.Requires(Amf0SizeObjectEOF()) {
+		err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 object requires 3bytes object eof"}
+		return
+	}
+
+	b := r.stream.Read(Amf0SizeObjectEOF())
+	if eof = b[0] == 0x00 && b[1] == 0x00 && b[2] == AMF0_ObjectEnd; !eof {
+		r.stream.Skip(-Amf0SizeObjectEOF())
+	}
+	return
+}
 // srs_amf0_write_object_eof
 func (r *Amf0Codec) WriteObjectEOF() (err error) {
 	// value
@@ -0,0 +1,306 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// the amf0 object or ecma array of n number properties.
+func amf0_properties(marker byte, n int) ([]byte) {
+	b := &bytes.Buffer{}
+	b.WriteByte(marker)
+	if marker == AMF0_EcmaArray {
+		b.Write([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("p%v", i)
+		b.Write([]byte{0, byte(len(name))})
+		b.WriteString(name)
+		b.Write([]byte{AMF0_Number, 0, 0, 0, 0, 0, 0, 0, 0})
+	}
+	b.Write([]byte{0, 0, AMF0_ObjectEnd})
+	return b.Bytes()
+}
+
+func TestAmf0MaxObjectProperties(t *testing.T) {
+	for _, marker := range []byte{AMF0_Object, AMF0_EcmaArray} {
+		codec := NewAmf0Codec(NewRtmpStream(amf0_properties(marker, AMF0_DEFAULT_MAX_PROPERTIES)))
+		if _, err := codec.ReadAny(); err != nil {
+			t.Errorf("marker=%v decode %v properties failed, err is %v", marker, AMF0_DEFAULT_MAX_PROPERTIES, err)
+		}
+
+		codec = NewAmf0Codec(NewRtmpStream(amf0_properties(marker, AMF0_DEFAULT_MAX_PROPERTIES + 1)))
+		if _, err := codec.ReadAny(); err == nil {
+			t.Errorf("marker=%v decode %v properties should fail", marker, AMF0_DEFAULT_MAX_PROPERTIES + 1)
+		}
+
+		// the max of codec, never affect others.
+		codec = NewAmf0Codec(NewRtmpStream(amf0_properties(marker, AMF0_DEFAULT_MAX_PROPERTIES + 1)))
+		codec.SetMaxProperties(AMF0_DEFAULT_MAX_PROPERTIES + 1)
+		if _, err := codec.ReadAny(); err != nil {
+			t.Errorf("marker=%v decode %v properties failed, err is %v", marker, AMF0_DEFAULT_MAX_PROPERTIES + 1, err)
+		}
+
+		codec = NewAmf0Codec(NewRtmpStream(amf0_properties(marker, 3)))
+		codec.SetMaxProperties(2)
+		if _, err := codec.ReadAny(); err == nil {
+			t.Errorf("marker=%v decode 3 properties should fail, max 2", marker)
+		}
+	}
+
+	// the strict array and the nested amf3 object.
+	for _, b := range []string{
+		"\x0a\x00\x00\x00\x03\x05\x05\x05",
+		"\x11\x0a\x0b\x01" + "\x03a\x04\x01" + "\x03b\x04\x01" + "\x03c\x04\x01" + "\x01",
+	} {
+		if _, err := NewAmf0Codec(NewRtmpStream([]byte(b))).ReadAny(); err != nil {
+			t.Errorf("decode %q failed, err is %v", b, err)
+		}
+
+		codec := NewAmf0Codec(NewRtmpStream([]byte(b)))
+		codec.SetMaxProperties(2)
+		if _, err := codec.ReadAny(); err == nil {
+			t.Errorf("decode %q should fail, max 2", b)
+		}
+	}
+}
+
+func TestAmf0WriteObjectRoundTrip(t *testing.T) {
+	data := NewAmf0EcmaArray()
+	data.Set("version", NewAmf0("3,5,3,888"))
+	data.Set("srs_id", NewAmf0(100))
+
+	info := NewAmf0Object()
+	info.Set("level", NewAmf0("status"))
+	info.Set("code", NewAmf0("NetConnection.Connect.Success"))
+	info.Set("objectEncoding", NewAmf0(0))
+	info.Set("secure", NewAmf0(false))
+	info.Set("empty", NewAmf0(""))
+	info.Set("none", NewAmf0Null())
+	info.Set("nested", NewAmf0(NewAmf0Object()))
+	info.Set("data", NewAmf0(data))
+
+	b := make([]byte, info.Size())
+	if err := NewAmf0Codec(NewRtmpStream(b)).WriteObject(info); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewAmf0Codec(NewRtmpStream(b)).ReadObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, info) {
+		t.Errorf("read %+v, expect %+v", v.properties, info.properties)
+	}
+
+	// the properties in the inserted order.
+	if !reflect.DeepEqual(v.properties.property_index, []string{"level", "code", "objectEncoding", "secure", "empty", "none", "nested", "data"}) {
+		t.Errorf("properties in order %v", v.properties.property_index)
+	}
+
+	b = make([]byte, data.Size())
+	if err := NewAmf0Codec(NewRtmpStream(b)).WriteEcmaArray(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(b, []byte{0, 0, AMF0_ObjectEnd}) {
+		t.Errorf("ecma array %x without object end", b)
+	}
+	if v, err := NewAmf0Codec(NewRtmpStream(b)).ReadEcmaArray(); err != nil || !reflect.DeepEqual(v.properties, data.properties) {
+		t.Errorf("read %+v, err is %v, expect %+v", v, err, data.properties)
+	}
+}
+
+func TestAmf0WriteSize(t *testing.T) {
+	// the short string, the string of max short length, and the long string.
+	for _, v := range []string{"", "livestream", strings.Repeat("x", 0xffff), strings.Repeat("x", 0x10000)} {
+		b := make([]byte, Amf0SizeString(v))
+		s := NewRtmpStream(b)
+		if err := NewAmf0Codec(s).WriteString(v); err != nil || s.Left() != 0 {
+			t.Errorf("len=%v write %v bytes, size %v, err is %v", len(v), len(b) - s.Left(), len(b), err)
+			continue
+		}
+
+		marker := byte(AMF0_String)
+		if len(v) > 0xffff {
+			marker = AMF0_LongString
+		}
+		if b[0] != marker {
+			t.Errorf("len=%v marker %#x, expect %#x", len(v), b[0], marker)
+		}
+		if got, err := NewAmf0Codec(NewRtmpStream(b)).ReadString(); err != nil || got != v {
+			t.Errorf("len=%v read len=%v, err is %v", len(v), len(got), err)
+		}
+	}
+
+	// the 0x00 marker and big-endian double.
+	b := make([]byte, Amf0SizeNumber())
+	s := NewRtmpStream(b)
+	if err := NewAmf0Codec(s).WriteNumber(1.5); err != nil || s.Left() != 0 {
+		t.Errorf("write %v bytes, size %v, err is %v", len(b) - s.Left(), len(b), err)
+	}
+	if !bytes.Equal(b, []byte{AMF0_Number, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("number 1.5 is %x", b)
+	}
+	if v, err := NewAmf0Codec(NewRtmpStream(b)).ReadNumber(); err != nil || v != 1.5 {
+		t.Errorf("read %v, err is %v", v, err)
+	}
+
+	// the property name never use long string.
+	b = make([]byte, 0x10000 + 2)
+	if err := NewAmf0Codec(NewRtmpStream(b)).WriteUtf8(strings.Repeat("x", 0x10000)); err == nil {
+		t.Error("write long utf8 should fail")
+	}
+}
+
+func TestAmf0StrictArray(t *testing.T) {
+	v := NewAmf0([]*Amf0Any{NewAmf0(0.04), NewAmf0("live"), NewAmf0([]*Amf0Any{})})
+
+	// the marker, count and values.
+	b := make([]byte, v.Size())
+	if err := NewAmf0Codec(NewRtmpStream(b)).WriteAny(v); err != nil {
+		t.Fatal(err)
+	}
+	if expect := "\x0a\x00\x00\x00\x03" + "\x00\x3f\xa4\x7a\xe1\x47\xae\x14\x7b" + "\x02\x00\x04live" + "\x0a\x00\x00\x00\x00"; string(b) != expect {
+		t.Errorf("encode %q, expect %q", b, expect)
+	}
+
+	got, err := NewAmf0Codec(NewRtmpStream(b)).ReadAny()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("read %+v, expect %+v", got, v)
+	}
+
+	// the fake count exceed the left bytes.
+	if _, err := NewAmf0Codec(NewRtmpStream([]byte("\x0a\x7f\xff\xff\xff\x05"))).ReadAny(); err == nil {
+		t.Error("decode fake count should fail")
+	}
+}
+
+func TestAmf0TruncatedObject(t *testing.T) {
+	// {app:"live", ver:1} and the ecma array with the same properties.
+	const properties = "\x00\x03app\x02\x00\x04live" + "\x00\x03ver\x00\x3f\xf0\x00\x00\x00\x00\x00\x00" + "\x00\x00\x09"
+	for _, b := range []string{"\x03" + properties, "\x08\x00\x00\x00\x02" + properties} {
+		codec := NewAmf0Codec(NewRtmpStream([]byte(b)))
+		if _, err := codec.ReadAny(); err != nil {
+			t.Fatalf("decode %x failed, err is %v", b, err)
+		}
+
+		// each truncated object without the object eof is a clean error.
+		for i := 1; i < len(b); i++ {
+			codec := NewAmf0Codec(NewRtmpStream([]byte(b[:i])))
+			_, err := codec.ReadAny()
+			if v, ok := err.(Error); !ok || v.Code() != ERROR_RTMP_AMF0_DECODE {
+				t.Errorf("decode %x truncated at %v, got %v", b, i, err)
+			}
+		}
+	}
+}
+
+/**
+* the random bytes should never panic or allocate unbounded memory,
+* for instance, the huge length prefixes or the deep nested objects,
+* run it by:
+* 		go test -run=none -fuzz=FuzzAmf0Decode
+*/
+func FuzzAmf0Decode(f *testing.F) {
+	for _, seed := range []string{
+		"\x00\x3f\xf0\x00\x00\x00\x00\x00\x00",
+		"\x01\x01",
+		"\x02\x00\x04live",
+		"\x0c\x00\x00\x00\x04live",
+		"\x0c\xff\xff\xff\xff",
+		"\x05", "\x06",
+		"\x03\x00\x03app\x02\x00\x04live\x00\x03ver\x00\x3f\xf0\x00\x00\x00\x00\x00\x00\x00\x00\x09",
+		"\x08\xff\xff\xff\xff\x00\x03app\x02\x00\x04live\x00\x00\x09",
+		"\x03\x00\x01a\x03\x00\x01a\x03\x00\x01a\x05\x00\x00\x09\x00\x00\x09\x00\x00\x09",
+		"\x11\x0a\x0b\x01\x07app\x06\x09live\x09next\x0a\x0b\x01\x00\x06\x02\x01\x01",
+		"\x11\x09\x07\x01\x0a\x0b\x01\x07app\x06\x09live\x01\x0a\x02\x0a\x01\x00\x06\x02\x01",
+		"\x11\x09\xff\xff\xff\xff",
+		strings.Repeat("\x03\x00\x01a", 64),
+		strings.Repeat("\x11\x0a\x0b\x01\x03a", 64),
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		codec := NewAmf0Codec(NewRtmpStream(b))
+		v, err := codec.ReadAny()
+
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > uint64(64 * len(b) + 1024 * 1024) {
+			t.Errorf("decode %v bytes allocate %v bytes", len(b), n)
+		}
+		if err == nil && v == nil {
+			t.Error("decode got nil value")
+		}
+		if codec.depth != 0 {
+			t.Errorf("depth is %v after decode", codec.depth)
+		}
+	})
+}
+
+// the n levels nested object {a:{a:...{a:null}}}, the top level is depth 1.
+func amf0_nested_object(n int) ([]byte) {
+	return []byte(strings.Repeat("\x03\x00\x01a", n) + "\x05" + strings.Repeat("\x00\x00\x09", n))
+}
+
+func TestAmf0MaxDepth(t *testing.T) {
+	codec := NewAmf0Codec(NewRtmpStream(amf0_nested_object(100)))
+	_, err := codec.ReadAny()
+	if v, ok := err.(Error); !ok || v.Code() != ERROR_RTMP_AMF0_DECODE || !strings.Contains(v.desc, "depth") {
+		t.Errorf("decode 100 levels, expect depth error, got %v", err)
+	}
+
+	codec = NewAmf0Codec(NewRtmpStream(amf0_nested_object(AMF0_DEFAULT_MAX_DEPTH)))
+	if _, err := codec.ReadAny(); err != nil {
+		t.Errorf("decode %v levels failed, err is %v", AMF0_DEFAULT_MAX_DEPTH, err)
+	}
+	codec = NewAmf0Codec(NewRtmpStream(amf0_nested_object(AMF0_DEFAULT_MAX_DEPTH + 1)))
+	if _, err := codec.ReadAny(); err == nil {
+		t.Errorf("decode %v levels should fail", AMF0_DEFAULT_MAX_DEPTH + 1)
+	}
+
+	// the codec allows the deep nested object.
+	codec = NewAmf0Codec(NewRtmpStream(amf0_nested_object(100)))
+	codec.SetMaxDepth(100)
+	if _, err := codec.ReadAny(); err != nil {
+		t.Errorf("decode 100 levels with max depth 100 failed, err is %v", err)
+	}
+
+	// the amf3 object in amf0 object shares the depth.
+	b := []byte(strings.Repeat("\x03\x00\x01a", 20) + "\x11" + strings.Repeat("\x0a\x0b\x01\x03a", 20) + "\x01")
+	codec = NewAmf0Codec(NewRtmpStream(b))
+	if _, err := codec.ReadAny(); !errors.Is(err, ErrAmf0Decode) || !strings.Contains(err.Error(), "depth") {
+		t.Errorf("decode 40 levels of amf0 and amf3, expect depth error, got %v", err)
+	}
+}
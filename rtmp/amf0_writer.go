@@ -0,0 +1,192 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"fmt"
+)
+
+/**
+* the amf0 stream writer, encode the amf0 values of a message
+* directly to chunks over the connection, without the whole payload.
+* the object and ecma array is written property by property,
+* so the memory used is about a chunk, for very large metadata.
+* for example:
+* 		w := protocol.NewAmf0StreamWriter(RTMP_CID_OverStream, RTMP_MSG_AMF0DataMessage, stream_id)
+* 		err = w.WriteMessage(NewAmf0(AMF0_DATA_ON_METADATA), NewAmf0(metadata))
+*/
+type Amf0StreamWriter struct {
+	protocol *protocol
+	// the chunk stream id to send over.
+	cid int
+	// the message header, the PayloadLength is set by WriteMessage.
+	header MessageHeader
+	// the payload of current chunk, at most outChunkSize bytes.
+	chunk []byte
+	// the payload bytes sent, the first chunk use fmt0 header.
+	sent int
+}
+func (r *protocol) NewAmf0StreamWriter(cid int, message_type byte, stream_id uint32) (*Amf0StreamWriter) {
+	w := &Amf0StreamWriter{}
+	w.protocol = r
+	w.cid = cid
+	w.header.MessageType = message_type
+	w.header.StreamId = stream_id
+	return w
+}
+
+/**
+* write the values as a message, the values is the payload of message.
+* the chunk header is generated when each chunk is sent.
+* @remark the writer can only write one message.
+ */
+func (r *Amf0StreamWriter) WriteMessage(values ...*Amf0Any) (err error) {
+	var size int
+	for _, v := range values {
+		size += v.Size()
+	}
+	r.header.PayloadLength = uint32(size)
+
+	// write to the connection directly,
+	// lock the output to sync with the send goroutine.
+	p := r.protocol
+	p.msg_out_lock.Lock()
+	defer p.msg_out_lock.Unlock()
+
+	if err = p.io_err(); err != nil {
+		return
+	}
+
+	r.chunk = make([]byte, 0, p.outChunkSize)
+	r.sent = 0
+
+	// the connection is broken when partial message sent.
+	defer func(){
+		if err != nil && r.sent > 0 {
+			p.set_io_err(err)
+		}
+	}()
+
+	for _, v := range values {
+		if err = r.write_any(v); err != nil {
+			return
+		}
+	}
+
+	// the left bytes, or the header of empty message.
+	if len(r.chunk) > 0 || r.sent == 0 {
+		if err = r.flush(); err != nil {
+			return
+		}
+	}
+
+	if r.sent != size {
+		return Error{code:ERROR_RTMP_AMF0_ENCODE, desc:fmt.Sprintf("amf0 stream size mismatch, expect=%v, actual=%v", size, r.sent)}
+	}
+
+	p.stats.on_send_message(r.header.MessageType)
+	return
+}
+
+func (r *Amf0StreamWriter) write_any(v *Amf0Any) (err error) {
+	switch {
+	case v.Marker == AMF0_Object:
+		obj, _ := v.Object()
+		if err = r.write([]byte{AMF0_Object}); err != nil {
+			return
+		}
+		if err = r.write_properties(obj.properties); err != nil {
+			return
+		}
+		return r.write([]byte{0x00, 0x00, AMF0_ObjectEnd})
+	case v.Marker == AMF0_EcmaArray:
+		arr, _ := v.EcmaArray()
+		count := NewRtmpStream(make([]byte, 5))
+		count.WriteByte(AMF0_EcmaArray).WriteUInt32(arr.count)
+		if err = r.write(count.WrittenBytes()); err != nil {
+			return
+		}
+		if err = r.write_properties(arr.properties); err != nil {
+			return
+		}
+		return r.write([]byte{0x00, 0x00, AMF0_ObjectEnd})
+	}
+
+	// the simple value, encode to bytes then write.
+	s := NewRtmpStream(make([]byte, v.Size()))
+	if err = v.Write(NewAmf0Codec(s)); err != nil {
+		return
+	}
+	return r.write(s.WrittenBytes())
+}
+
+func (r *Amf0StreamWriter) write_properties(properties *Amf0UnSortedHashtable) (err error) {
+	for _, k := range properties.property_index {
+		s := NewRtmpStream(make([]byte, Amf0SizeUtf8(k)))
+		if err = NewAmf0Codec(s).WriteUtf8(k); err != nil {
+			return
+		}
+		if err = r.write(s.WrittenBytes()); err != nil {
+			return
+		}
+		if err = r.write_any(properties.properties[k]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// copy bytes to chunk, sendout when chunk is full.
+func (r *Amf0StreamWriter) write(b []byte) (err error) {
+	for len(b) > 0 {
+		n := cap(r.chunk) - len(r.chunk)
+		if n > len(b) {
+			n = len(b)
+		}
+		r.chunk = append(r.chunk, b[0:n]...)
+		b = b[n:]
+
+		if len(r.chunk) == cap(r.chunk) {
+			if err = r.flush(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// sendout the chunk header and payload.
+func (r *Amf0StreamWriter) flush() (err error) {
+	p := r.protocol
+
+	header := p.encode_chunk_header(&r.header, r.cid, r.sent == 0, -1)
+	if _, err = p.conn.Write(header); err != nil {
+		return
+	}
+	if _, err = p.conn.Write(r.chunk); err != nil {
+		return
+	}
+
+	r.sent += len(r.chunk)
+	r.chunk = r.chunk[0:0]
+	return
+}
@@ -0,0 +1,83 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// the large metadata, exceed many chunks.
+func large_metadata() (*Amf0EcmaArray) {
+	v := NewAmf0EcmaArray()
+	for i := 0; i < 64; i++ {
+		v.Set(fmt.Sprintf("key%v", i), NewAmf0(strings.Repeat("v", i * 7)))
+	}
+	obj := NewAmf0Object()
+	obj.Set("width", NewAmf0(1920))
+	obj.Set("enabled", NewAmf0(true))
+	v.Set("nested", NewAmf0(obj))
+	return v
+}
+
+func TestAmf0StreamWriter(t *testing.T) {
+	values := []*Amf0Any{NewAmf0(AMF0_DATA_ON_METADATA), NewAmf0(large_metadata())}
+
+	// the buffered encoder, encode to payload then chunk it.
+	var size int
+	for _, v := range values {
+		size += v.Size()
+	}
+	s := NewRtmpStream(make([]byte, size))
+	for _, v := range values {
+		if err := v.Write(NewAmf0Codec(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg := NewMessage()
+	msg.PerferCid = RTMP_CID_OverStream
+	msg.Header.MessageType = RTMP_MSG_AMF0DataMessage
+	msg.Header.StreamId = 1
+	msg.Header.PayloadLength = uint32(size)
+	msg.Payload = s.WrittenBytes()
+
+	buffered, buffered_conn := new_mock_protocol(nil)
+	if err := buffered.do_send_msg_goroutine_job(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream writer, encode directly to chunks.
+	streamed, streamed_conn := new_mock_protocol(nil)
+	w := streamed.NewAmf0StreamWriter(RTMP_CID_OverStream, RTMP_MSG_AMF0DataMessage, 1)
+	if err := w.WriteMessage(values...); err != nil {
+		t.Fatal(err)
+	}
+
+	if size < 10 * RTMP_DEFAULT_CHUNK_SIZE {
+		t.Fatalf("metadata %v bytes is too small to test chunking", size)
+	}
+	if !bytes.Equal(buffered_conn.w.Bytes(), streamed_conn.w.Bytes()) {
+		t.Fatalf("stream writer output %v bytes, buffered %v bytes, not equal", streamed_conn.w.Len(), buffered_conn.w.Len())
+	}
+}
@@ -0,0 +1,479 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// AMF3 marker
+const AMF3_Undefined = 0x00
+const AMF3_Null = 0x01
+const AMF3_False = 0x02
+const AMF3_True = 0x03
+const AMF3_Integer = 0x04
+const AMF3_Double = 0x05
+const AMF3_String = 0x06
+const AMF3_XmlDocument = 0x07
+const AMF3_Date = 0x08
+const AMF3_Array = 0x09
+const AMF3_Object = 0x0A
+const AMF3_Xml = 0x0B
+const AMF3_ByteArray = 0x0C
+
+/**
+* the amf3 codec, to decode the amf3 values in the AMF3 command,
+* for instance, the AMF0 value is switched to AMF3 by AMF0_AVMplusObject.
+* the decoded value is converted to the amf0 value:
+* 		undefined/null/true/false to AMF0 undefined/null/boolean,
+* 		integer/double to AMF0 number,
+* 		string to AMF0 string,
+* 		object to AMF0 object,
+* 		array to AMF0 ecma array, the dense members use the index as name.
+* the string, object and traits reference tables is kept in codec,
+* so the codec must be used for one message, @see Amf0Codec.amf3.
+*/
+type Amf3Codec struct {
+	stream *Buffer
+	// the reference tables, the value is referenced by the index.
+	strings []string
+	// the object and array, the *Amf0Object or *Amf0EcmaArray.
+	objects []interface {}
+	traits []*amf3_traits
+	// whether the object of reference table is in decoding, the reference
+	// to it is cycle, which is not supported by the amf0 value.
+	decoding []bool
+	// the depth of the nested objects in decoding, @see max_depth.
+	depth int
+	// the max depth of the nested objects, @see AMF0_DEFAULT_MAX_DEPTH.
+	max_depth int
+	// the max properties of object or array, @see AMF0_DEFAULT_MAX_PROPERTIES.
+	max_properties int
+}
+
+// the traits of object, the class name and sealed members.
+type amf3_traits struct {
+	dynamic bool
+	sealed_names []string
+}
+func NewAmf3Codec(stream *Buffer) (*Amf3Codec) {
+	r := Amf3Codec{}
+	r.stream = stream
+	r.max_depth = AMF0_DEFAULT_MAX_DEPTH
+	r.max_properties = AMF0_DEFAULT_MAX_PROPERTIES
+	return &r
+}
+
+// set the max depth of the nested objects or arrays, @see Amf0Codec.SetMaxDepth
+func (r *Amf3Codec) SetMaxDepth(v int) {
+	r.max_depth = v
+}
+
+// set the max properties of object or array, @see Amf0Codec.SetMaxProperties
+func (r *Amf3Codec) SetMaxProperties(v int) {
+	r.max_properties = v
+}
+
+// read any amf3 value, convert to amf0 value.
+func (r *Amf3Codec) ReadAny() (v *Amf0Any, err error) {
+	// marker
+	if !r.stream.Requires(1) {
+		err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:"amf3 any requires 1bytes marker"}
+		return
+	}
+	marker := r.stream.ReadByte()
+
+	switch marker {
+	case AMF3_Undefined:
+		return NewAmf0Undefined(), nil
+	case AMF3_Null:
+		return NewAmf0Null(), nil
+	case AMF3_False:
+		return NewAmf0(false), nil
+	case AMF3_True:
+		return NewAmf0(true), nil
+	case AMF3_Integer:
+		var i int32
+		if i, err = r.ReadInteger(); err != nil {
+			return
+		}
+		return NewAmf0(float64(i)), nil
+	case AMF3_Double:
+		if !r.stream.Requires(8) {
+			err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:"amf3 double requires 8bytes value"}
+			return
+		}
+		return NewAmf0(r.stream.ReadFloat64()), nil
+	case AMF3_String:
+		var s string
+		if s, err = r.ReadUtf8(); err != nil {
+			return
+		}
+		return NewAmf0(s), nil
+	case AMF3_Object:
+		var obj *Amf0Object
+		if obj, err = r.ReadObject(); err != nil {
+			return
+		}
+		return NewAmf0(obj), nil
+	case AMF3_Array:
+		var arr *Amf0EcmaArray
+		if arr, err = r.ReadArray(); err != nil {
+			return
+		}
+		return NewAmf0(arr), nil
+	}
+
+	err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 marker not support. marker=%#x", marker)}
+	return
+}
+
+/**
+* read the U29, the variable length unsigned 29bits integer,
+* the high bit of first 3bytes indicates there is another byte,
+* the 4th byte use all 8bits.
+ */
+func (r *Amf3Codec) ReadU29() (v uint32, err error) {
+	for i := 0; i < 4; i++ {
+		if !r.stream.Requires(1) {
+			err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:"amf3 U29 requires more bytes"}
+			return
+		}
+		b := r.stream.ReadByte()
+
+		if i == 3 {
+			v = (v << 8) | uint32(b)
+			break
+		}
+
+		v = (v << 7) | uint32(b & 0x7F)
+		if (b & 0x80) == 0 {
+			break
+		}
+	}
+	return
+}
+
+/**
+* write the U29, @see ReadU29, the v must be less than 2^29,
+* for example, 0x7F in 1byte, 0x3FFF in 2bytes, 0x1FFFFF in 3bytes.
+ */
+func (r *Amf3Codec) WriteU29(v uint32) (err error) {
+	if v > 0x1FFFFFFF {
+		return Error{code:ERROR_RTMP_AMF3_ENCODE, desc:fmt.Sprintf("amf3 U29 overflow, v=%#x", v)}
+	}
+
+	var b []byte
+	switch {
+	case v < 0x80:
+		b = []byte{byte(v)}
+	case v < 0x4000:
+		b = []byte{byte(v >> 7) | 0x80, byte(v & 0x7F)}
+	case v < 0x200000:
+		b = []byte{byte(v >> 14) | 0x80, byte(v >> 7) | 0x80, byte(v & 0x7F)}
+	default:
+		b = []byte{byte(v >> 22) | 0x80, byte(v >> 15) | 0x80, byte(v >> 8) | 0x80, byte(v)}
+	}
+
+	if !r.stream.Requires(len(b)) {
+		return Error{code:ERROR_RTMP_AMF3_ENCODE, desc:"amf3 U29 requires more bytes"}
+	}
+	r.stream.Write(b)
+	return
+}
+
+// read the integer, the U29 in 29bits signed.
+func (r *Amf3Codec) ReadInteger() (v int32, err error) {
+	var u29 uint32
+	if u29, err = r.ReadU29(); err != nil {
+		return
+	}
+
+	// sign extend the 29bits integer.
+	v = int32(u29 << 3) >> 3
+	return
+}
+
+/**
+* read the utf8 string without marker,
+* UTF-8-vr = U29S-ref | (U29S-value *(UTF8-char))
+ */
+func (r *Amf3Codec) ReadUtf8() (v string, err error) {
+	var ref uint32
+	if ref, err = r.ReadU29(); err != nil {
+		return
+	}
+
+	// the low bit 0 is the string reference.
+	if (ref & 0x01) == 0 {
+		index := int(ref >> 1)
+		if index >= len(r.strings) {
+			err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 string reference %v exceed %v", index, len(r.strings))}
+			return
+		}
+		return r.strings[index], nil
+	}
+
+	n := int(ref >> 1)
+	if n <= 0 {
+		return
+	}
+	if !r.stream.Requires(n) {
+		err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:"amf3 string data requires more bytes"}
+		return
+	}
+	v = string(r.stream.Read(n))
+
+	// the empty string is never sent by reference.
+	r.strings = append(r.strings, v)
+	return
+}
+
+// get the referenced object or array of the U29O-ref.
+func (r *Amf3Codec) object_reference(ref uint32) (v interface {}, err error) {
+	index := int(ref >> 1)
+	if index >= len(r.objects) {
+		err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 object reference %v exceed %v", index, len(r.objects))}
+		return
+	}
+	if r.decoding[index] {
+		err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 object reference %v is cycle", index)}
+		return
+	}
+	return r.objects[index], nil
+}
+
+// add the object to reference table and enter it, decode failed when exceed max_depth.
+func (r *Amf3Codec) enter_object(v interface {}) (index int, err error) {
+	if r.depth >= r.max_depth {
+		err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 object depth exceed max %v", r.max_depth)}
+		return
+	}
+	r.depth++
+
+	r.objects = append(r.objects, v)
+	r.decoding = append(r.decoding, true)
+	return len(r.objects) - 1, nil
+}
+func (r *Amf3Codec) leave_object(index int) {
+	r.depth--
+	r.decoding[index] = false
+}
+
+/**
+* read the object without marker, the sealed and dynamic members
+* is set to the properties of amf0 object.
+* object-type = object-marker (U29O-ref | (U29O-traits-ext class-name *(U8)) | U29O-traits-ref |
+* 		(U29O-traits class-name *(UTF-8-vr))) *(value-type) *(dynamic-member)))
+ */
+func (r *Amf3Codec) ReadObject() (v *Amf0Object, err error) {
+	var ref uint32
+	if ref, err = r.ReadU29(); err != nil {
+		return
+	}
+
+	// the low bit 0 is the object reference.
+	if (ref & 0x01) == 0 {
+		var obj interface {}
+		if obj, err = r.object_reference(ref); err != nil {
+			return
+		}
+		if v, _ = obj.(*Amf0Object); v == nil {
+			err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:"amf3 object reference is not object"}
+		}
+		return
+	}
+
+	var traits *amf3_traits
+	if traits, err = r.read_traits(ref); err != nil {
+		return
+	}
+
+	// add to reference table before members, the member may reference it.
+	v = NewAmf0Object()
+	var index int
+	if index, err = r.enter_object(v); err != nil {
+		return
+	}
+	defer r.leave_object(index)
+
+	for _, name := range traits.sealed_names {
+		var value *Amf0Any
+		if value, err = r.ReadAny(); err != nil {
+			return
+		}
+		if err = v.Set(name, value); err != nil {
+			return
+		}
+	}
+
+	// the dynamic members, end with empty name.
+	for traits.dynamic {
+		var name string
+		if name, err = r.ReadUtf8(); err != nil {
+			return
+		}
+		if name == "" {
+			break
+		}
+		if v.properties.Count() >= r.max_properties {
+			err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 object properties exceed max %v", r.max_properties)}
+			return
+		}
+
+		var value *Amf0Any
+		if value, err = r.ReadAny(); err != nil {
+			return
+		}
+		if err = v.Set(name, value); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+/**
+* read the traits of object, the ref is the U29 of object,
+* the second bit 0 is the traits reference, or the traits inline.
+ */
+func (r *Amf3Codec) read_traits(ref uint32) (v *amf3_traits, err error) {
+	// the second bit 0 is the traits reference.
+	if (ref & 0x02) == 0 {
+		index := int(ref >> 2)
+		if index >= len(r.traits) {
+			err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 traits reference %v exceed %v", index, len(r.traits))}
+			return
+		}
+		return r.traits[index], nil
+	}
+	// the third bit 1 is the externalizable traits.
+	if (ref & 0x04) != 0 {
+		err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:"amf3 externalizable object not support"}
+		return
+	}
+
+	v = &amf3_traits{}
+	v.dynamic = (ref & 0x08) != 0
+	sealed_count := int(ref >> 4)
+	if sealed_count > r.max_properties {
+		err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 object sealed members exceed max %v", r.max_properties)}
+		return
+	}
+
+	// the class name, empty for anonymous object.
+	if _, err = r.ReadUtf8(); err != nil {
+		return
+	}
+
+	v.sealed_names = make([]string, 0, sealed_count)
+	for i := 0; i < sealed_count; i++ {
+		var name string
+		if name, err = r.ReadUtf8(); err != nil {
+			return
+		}
+		v.sealed_names = append(v.sealed_names, name)
+	}
+
+	r.traits = append(r.traits, v)
+	return
+}
+
+/**
+* read the array without marker, the associative members and the dense
+* members are set to the amf0 ecma array, the dense member use the index
+* as the name, for example, "0", "1".
+* array-type = array-marker (U29O-ref | (U29A-value (UTF-8-empty | *(assoc-value) UTF-8-empty) *(value-type)))
+ */
+func (r *Amf3Codec) ReadArray() (v *Amf0EcmaArray, err error) {
+	var ref uint32
+	if ref, err = r.ReadU29(); err != nil {
+		return
+	}
+
+	// the low bit 0 is the object reference.
+	if (ref & 0x01) == 0 {
+		var obj interface {}
+		if obj, err = r.object_reference(ref); err != nil {
+			return
+		}
+		if v, _ = obj.(*Amf0EcmaArray); v == nil {
+			err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:"amf3 array reference is not array"}
+		}
+		return
+	}
+	dense_count := int(ref >> 1)
+	if dense_count > r.max_properties {
+		err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 array dense members exceed max %v", r.max_properties)}
+		return
+	}
+
+	// add to reference table before members, the member may reference it.
+	v = NewAmf0EcmaArray()
+	var index int
+	if index, err = r.enter_object(v); err != nil {
+		return
+	}
+	defer r.leave_object(index)
+
+	// the associative members, end with empty name.
+	for {
+		var name string
+		if name, err = r.ReadUtf8(); err != nil {
+			return
+		}
+		if name == "" {
+			break
+		}
+		if v.properties.Count() >= r.max_properties {
+			err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 array properties exceed max %v", r.max_properties)}
+			return
+		}
+
+		var value *Amf0Any
+		if value, err = r.ReadAny(); err != nil {
+			return
+		}
+		if err = v.Set(name, value); err != nil {
+			return
+		}
+	}
+
+	// the dense members.
+	for i := 0; i < dense_count; i++ {
+		if v.properties.Count() >= r.max_properties {
+			err = Error{code:ERROR_RTMP_AMF3_DECODE, desc:fmt.Sprintf("amf3 array properties exceed max %v", r.max_properties)}
+			return
+		}
+
+		var value *Amf0Any
+		if value, err = r.ReadAny(); err != nil {
+			return
+		}
+		if err = v.Set(strconv.Itoa(i), value); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,201 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// the U29 vectors from the amf3 spec, the boundaries of each length.
+var amf3_u29_vectors = []struct {
+	v uint32
+	b []byte
+}{
+	{0x00, []byte{0x00}},
+	{0x01, []byte{0x01}},
+	{0x7F, []byte{0x7F}},
+	{0x80, []byte{0x81, 0x00}},
+	{0x3FFF, []byte{0xFF, 0x7F}},
+	{0x4000, []byte{0x81, 0x80, 0x00}},
+	{0x1FFFFF, []byte{0xFF, 0xFF, 0x7F}},
+	{0x200000, []byte{0x80, 0xC0, 0x80, 0x00}},
+	{0x3FFFFFF, []byte{0x8F, 0xFF, 0xFF, 0xFF}},
+	{0x1FFFFFFF, []byte{0xFF, 0xFF, 0xFF, 0xFF}},
+}
+
+func TestAmf3ReadU29(t *testing.T) {
+	for _, c := range amf3_u29_vectors {
+		codec := NewAmf3Codec(NewRtmpStream(c.b))
+		v, err := codec.ReadU29()
+		if err != nil {
+			t.Errorf("read %x failed, err is %v", c.b, err)
+			continue
+		}
+		if v != c.v {
+			t.Errorf("read %x got %#x, expect %#x", c.b, v, c.v)
+		}
+		if !codec.stream.Empty() {
+			t.Errorf("read %x left bytes", c.b)
+		}
+	}
+
+	// the continue bit without the next byte.
+	codec := NewAmf3Codec(NewRtmpStream([]byte{0x81}))
+	if _, err := codec.ReadU29(); !errors.Is(err, ErrAmf0Decode) {
+		t.Errorf("expect decode error, got %v", err)
+	}
+}
+
+func TestAmf3WriteU29(t *testing.T) {
+	for _, c := range amf3_u29_vectors {
+		b := make([]byte, len(c.b))
+		codec := NewAmf3Codec(NewRtmpStream(b))
+		if err := codec.WriteU29(c.v); err != nil {
+			t.Errorf("write %#x failed, err is %v", c.v, err)
+			continue
+		}
+		if !bytes.Equal(b, c.b) {
+			t.Errorf("write %#x got %x, expect %x", c.v, b, c.b)
+		}
+	}
+
+	codec := NewAmf3Codec(NewRtmpStream(make([]byte, 4)))
+	if err := codec.WriteU29(0x20000000); err == nil {
+		t.Error("write overflow should fail")
+	} else if err, ok := err.(Error); !ok || err.Code() != ERROR_RTMP_AMF3_ENCODE {
+		t.Errorf("expect amf3 encode error, got %v", err)
+	}
+
+	codec = NewAmf3Codec(NewRtmpStream(make([]byte, 1)))
+	if err := codec.WriteU29(0x80); err == nil {
+		t.Error("write without space should fail")
+	} else if err, ok := err.(Error); !ok || err.Code() != ERROR_RTMP_AMF3_ENCODE {
+		t.Errorf("expect amf3 encode error, got %v", err)
+	}
+}
+
+func TestAmf3ReadInteger(t *testing.T) {
+	cases := []struct {
+		b []byte
+		v int32
+	}{
+		{[]byte{0x00}, 0},
+		{[]byte{0x7F}, 127},
+		{[]byte{0xBF, 0xFF, 0xFF, 0xFF}, 0xFFFFFFF},
+		{[]byte{0xC0, 0x80, 0x80, 0x00}, -0x10000000},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFF}, -1},
+	}
+	for _, c := range cases {
+		codec := NewAmf3Codec(NewRtmpStream(c.b))
+		if v, err := codec.ReadInteger(); err != nil || v != c.v {
+			t.Errorf("read %x got %v, expect %v, err is %v", c.b, v, c.v, err)
+		}
+	}
+}
+
+func TestAmf3StringReference(t *testing.T) {
+	// {app:"live", next:{app:"live"}}, the second app and live are references.
+	b := []byte("\x0a\x0b\x01" +
+		"\x07app\x06\x09live" +
+		"\x09next\x0a\x0b\x01" + "\x00\x06\x02" + "\x01" +
+		"\x01")
+	codec := NewAmf3Codec(NewRtmpStream(b))
+	v, err := codec.ReadAny()
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := v.Object()
+	if !ok {
+		t.Fatal("not object")
+	}
+	if s, ok := obj.GetPropertyString("app"); !ok || s != "live" {
+		t.Errorf("app is %v", s)
+	}
+
+	next, ok := obj.Get("next")
+	if !ok {
+		t.Fatal("no next")
+	}
+	if obj, ok = next.Object(); !ok {
+		t.Fatal("next not object")
+	}
+	if s, ok := obj.GetPropertyString("app"); !ok || s != "live" {
+		t.Errorf("next app is %v", s)
+	}
+	if !codec.stream.Empty() {
+		t.Error("left bytes")
+	}
+
+	// the reference table is per message, a new codec has no strings.
+	codec = NewAmf3Codec(NewRtmpStream([]byte("\x06\x00")))
+	if _, err := codec.ReadAny(); !errors.Is(err, ErrAmf0Decode) {
+		t.Errorf("expect reference error, got %v", err)
+	}
+}
+
+func TestAmf3ObjectReference(t *testing.T) {
+	// [{app:"live"}, ref, {app:"live"}], the second is the object reference,
+	// the third is the traits reference.
+	b := []byte("\x09\x07\x01" +
+		"\x0a\x0b\x01\x07app\x06\x09live\x01" +
+		"\x0a\x02" +
+		"\x0a\x01\x00\x06\x02\x01")
+	codec := NewAmf3Codec(NewRtmpStream(b))
+	v, err := codec.ReadAny()
+	if err != nil {
+		t.Fatal(err)
+	}
+	arr, ok := v.EcmaArray()
+	if !ok {
+		t.Fatal("not array")
+	}
+
+	var objs []*Amf0Object
+	for _, k := range []string{"0", "1", "2"} {
+		e, ok := arr.Get(k)
+		if !ok {
+			t.Fatalf("no element %v", k)
+		}
+		obj, ok := e.Object()
+		if !ok {
+			t.Fatalf("element %v not object", k)
+		}
+		if s, ok := obj.GetPropertyString("app"); !ok || s != "live" {
+			t.Errorf("element %v app is %v", k, s)
+		}
+		objs = append(objs, obj)
+	}
+	if objs[0] != objs[1] {
+		t.Error("the reference should be the same object")
+	}
+	if objs[0] == objs[2] {
+		t.Error("the traits reference should be a new object")
+	}
+
+	// the reference to the object in decoding is cycle.
+	codec = NewAmf3Codec(NewRtmpStream([]byte("\x0a\x0b\x01\x09self\x0a\x00\x01")))
+	if _, err := codec.ReadAny(); !errors.Is(err, ErrAmf0Decode) {
+		t.Errorf("expect cycle error, got %v", err)
+	}
+}
@@ -22,12 +22,23 @@
 package rtmp
 
 import (
+	"fmt"
 	"math"
 )
 
 // rtmp socket recv buffer
 const RTMP_SOCKET_READ_SIZE = 16*1024
 
+/**
+* the default initial and max size of the recv buffer, the buffer grows to
+* hold the chunk, and the consumed bytes is compacted to reuse, the max size
+* avoid the peer to drive the buffer to arbitrary size, the recv failed
+* when the chunk requires more bytes than it.
+* @see Protocol.SetRecvBufferSize
+*/
+const RTMP_DEFAULT_RECV_BUFFER_SIZE = RTMP_SOCKET_READ_SIZE
+const RTMP_DEFAULT_MAX_RECV_BUFFER_SIZE = RTMP_MAX_CHUNK_SIZE + RTMP_SOCKET_READ_SIZE
+
 // read data from socket if needed.
 type Buffer struct{
 	// high performance buffer, to read/write from zero.
@@ -36,12 +47,15 @@ type Buffer struct{
 	conn *Socket
 	// the 4k socket read buffer
 	skt_buf []byte
+	// the max bytes cached in buf, <=0 to disable.
+	max_size int
 }
 func NewRtmpBuffer(conn *Socket) (*Buffer) {
 	r := &Buffer{}
 	r.conn = conn
-	r.buf = NewHPBuffer(nil)
+	r.buf = NewHPBuffer(make([]byte, 0, RTMP_DEFAULT_RECV_BUFFER_SIZE))
 	r.skt_buf = make([]byte, RTMP_SOCKET_READ_SIZE)
+	r.max_size = RTMP_DEFAULT_MAX_RECV_BUFFER_SIZE
 	return r
 }
 func NewRtmpStream(b []byte) (*Buffer) {
@@ -50,6 +64,25 @@ func NewRtmpStream(b []byte) (*Buffer) {
 	return r
 }
 
+/**
+* set the initial and max size of the recv buffer,
+* the cached bytes is kept when realloc the buffer.
+ */
+func (r *Buffer) SetSize(size int, max_size int) {
+	cached := r.buf.buffer.Bytes()
+	if size < len(cached) {
+		size = len(cached)
+	}
+
+	b := make([]byte, len(cached), size)
+	copy(b, cached)
+
+	off := r.buf.off
+	r.buf = NewHPBuffer(b)
+	r.buf.off = off
+	r.max_size = max_size
+}
+
 /**
 * ensure the buffer contains n bytes, append from connection if needed.
  */
@@ -57,8 +90,20 @@ func (r *Buffer) EnsureBufferBytes(n int) (err error) {
 	var buffer *HPBuffer = r.buf
 
 	for buffer.Len() < n {
+		// never read more than the left space of max size.
+		b := r.skt_buf
+		if r.max_size > 0 {
+			left := r.max_size - buffer.cached_bytes()
+			if left <= 0 {
+				return Error{code:ERROR_GO_BUFFER_OVERFLOW, desc:fmt.Sprintf("recv buffer requires %v bytes exceed max %v", n, r.max_size)}
+			}
+			if left < len(b) {
+				b = b[:left]
+			}
+		}
+
 		var nsize int
-		if nsize, err = r.conn.Read(r.skt_buf); err != nil {
+		if nsize, err = r.conn.Read(b); err != nil {
 			return
 		}
 
@@ -0,0 +1,230 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"sync"
+)
+
+/**
+* the cache of stream, for the player joins later, for example,
+* the sequence headers must send to player before any media,
+* or the player cannot initialize the decoders.
+* the publisher goroutine cache the message, and the player
+* goroutines get the cached messages, so it's goroutine safe.
+*/
+type StreamCache struct {
+	lock *sync.Mutex
+	// the AVC sequence header, the AVCDecoderConfigurationRecord.
+	video_sequence_header *Message
+	// the AAC sequence header, the AudioSpecificConfig.
+	audio_sequence_header *Message
+	// the bare onMetaData, the @setDataFrame is stripped.
+	metadata *Message
+}
+func NewStreamCache() (*StreamCache) {
+	r := &StreamCache{}
+	r.lock = &sync.Mutex{}
+	return r
+}
+
+/**
+* cache the message if it's sequence header or metadata, ignore others.
+* the metadata of FMLE @setDataFrame("onMetaData", {...}) is cached
+* as the bare onMetaData({...}), which is required by player.
+* @remark the message is shared, never modify it after cached.
+*/
+func (r *StreamCache) Cache(msg *Message) {
+	r.cache(msg)
+}
+
+// cache the message, return whether it's sequence header or metadata.
+func (r *StreamCache) cache(msg *Message) (cached bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if is_video_sequence_header(msg) {
+		r.video_sequence_header = msg
+	} else if is_audio_sequence_header(msg) {
+		r.audio_sequence_header = msg
+	} else if msg = StripSetDataFrame(msg); is_metadata(msg) {
+		r.metadata = msg
+	} else {
+		return false
+	}
+	return true
+}
+
+// get the cached bare onMetaData, nil if not cached.
+func (r *StreamCache) Metadata() (*Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.metadata
+}
+
+// get the cached sequence headers, nil if not cached.
+func (r *StreamCache) SequenceHeaders() (video *Message, audio *Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.video_sequence_header, r.audio_sequence_header
+}
+
+// whether the message is AVC sequence header, by the FLV video tag header.
+func is_video_sequence_header(msg *Message) (bool) {
+	if !msg.Header.IsVideo() || len(msg.Payload) < 2 {
+		return false
+	}
+	return msg.Payload[0] == CodecVideoAVCFrameKeyFrame << 4 | CodecVideoAVC && msg.Payload[1] == CodecVideoAVCTypeSequenceHeader
+}
+
+// whether the message is AAC sequence header, by the FLV audio tag header.
+func is_audio_sequence_header(msg *Message) (bool) {
+	if !msg.Header.IsAudio() || len(msg.Payload) < 2 {
+		return false
+	}
+	return (msg.Payload[0] >> 4) & 0x0f == CodecAudioAAC && msg.Payload[1] == CodecAudioTypeSequenceHeader
+}
+
+// whether the message is the bare onMetaData, the @setDataFrame must be stripped.
+func is_metadata(msg *Message) (bool) {
+	if !msg.Header.IsAmf0Data() {
+		return false
+	}
+
+	codec := NewAmf0Codec(NewRtmpStream(msg.Payload))
+	if v, err := codec.ReadString(); err != nil || v != AMF0_DATA_ON_METADATA {
+		return false
+	}
+	return true
+}
+
+/**
+* the gop cache of stream, for the player to start play immediately,
+* cache the sequence headers and the messages of the latest gop,
+* which starts with the video keyframe, and replay to the new player,
+* the metadata is cached as the bare onMetaData and replayed first.
+* when a new keyframe arrives, the cached gop is dropped, and when the gop
+* exceed the max messages, it's dropped to the keyframe, the newest one,
+* and the messages after it are ignored until the next keyframe.
+* @remark the audio before the first keyframe is not cached,
+* 		so nothing is cached for the pure audio stream.
+* @remark the message is shared, never modify it after cached.
+*/
+type GopCache struct {
+	lock *sync.Mutex
+	max_messages int
+	// the sequence headers and metadata.
+	cache *StreamCache
+	// the messages of gop, the first is the video keyframe.
+	gop []*Message
+	// whether the gop exceed the max messages, ignore until the next keyframe.
+	overflow bool
+}
+/**
+* @param max_messages the max messages of gop, for example, 2048,
+* 		to avoid the huge gop exhaust the memory, <=0 to never limit.
+*/
+func NewGopCache(max_messages int) (*GopCache) {
+	r := &GopCache{}
+	r.lock = &sync.Mutex{}
+	r.max_messages = max_messages
+	r.cache = NewStreamCache()
+	return r
+}
+
+// cache the audio/video message and the metadata, ignore others.
+func (r *GopCache) Cache(msg *Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.cache.cache(msg) {
+		return
+	}
+
+	if !msg.Header.IsAudio() && !msg.Header.IsVideo() {
+		return
+	}
+
+	// the keyframe starts a new gop.
+	if is_video_keyframe(msg) {
+		r.gop = append(r.gop[:0:0], msg)
+		r.overflow = false
+		return
+	}
+
+	// drop the messages before the first keyframe.
+	if len(r.gop) == 0 || r.overflow {
+		return
+	}
+
+	// the gop is too large, drop it to the keyframe until the next keyframe,
+	// the new player still starts with the keyframe.
+	if r.max_messages > 0 && len(r.gop) >= r.max_messages {
+		r.gop = append(r.gop[:0:0], r.gop[0])
+		r.overflow = true
+		return
+	}
+
+	r.gop = append(r.gop, msg)
+}
+
+// clear the cache, for example, when the publisher unpublish.
+func (r *GopCache) Clear() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.cache = NewStreamCache()
+	r.gop, r.overflow = nil, false
+}
+
+/**
+* get the cached messages in order, the metadata and sequence headers
+* first, then the messages of gop start with the keyframe.
+*/
+func (r *GopCache) Messages() (msgs []*Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	video, audio := r.cache.SequenceHeaders()
+	for _, msg := range []*Message{r.cache.Metadata(), video, audio} {
+		if msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return append(msgs, r.gop...)
+}
+
+/**
+* send the cached messages to the new player, must be called
+* after StartPlay and before any other media.
+* @param stream_id the stream id of player.
+*/
+func (r *GopCache) Dump(protocol Protocol, stream_id uint32) (err error) {
+	for _, msg := range r.Messages() {
+		if err = protocol.SendSharedMessage(msg, stream_id); err != nil {
+			return
+		}
+	}
+	return
+}
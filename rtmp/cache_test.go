@@ -0,0 +1,118 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"testing"
+)
+
+// the timestamps of messages, to compare the order.
+func message_timestamps(msgs []*Message) (v []uint64) {
+	for _, msg := range msgs {
+		v = append(v, msg.Header.Timestamp)
+	}
+	return
+}
+
+func equal_timestamps(a, b []uint64) (bool) {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGopCacheKeyframeBoundary(t *testing.T) {
+	gop := NewGopCache(0)
+
+	// the audio and inter frame before the first keyframe are dropped.
+	gop.Cache(new_av_message(RTMP_MSG_AudioMessage, 0, []byte{0xaf, 0x01}))
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 1, []byte{0x27, 0x01}))
+	if msgs := gop.Messages(); len(msgs) != 0 {
+		t.Fatalf("cached %v messages before keyframe", len(msgs))
+	}
+
+	// the sequence headers are cached, but never start the gop.
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 2, []byte{0x17, 0x00}))
+	gop.Cache(new_av_message(RTMP_MSG_AudioMessage, 3, []byte{0xaf, 0x00}))
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 4, []byte{0x27, 0x01}))
+	if v := message_timestamps(gop.Messages()); !equal_timestamps(v, []uint64{2, 3}) {
+		t.Fatalf("cached %v, expect the sequence headers", v)
+	}
+
+	// the keyframe starts the gop.
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 10, []byte{0x17, 0x01}))
+	gop.Cache(new_av_message(RTMP_MSG_AudioMessage, 11, []byte{0xaf, 0x01}))
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 12, []byte{0x27, 0x01}))
+	if v := message_timestamps(gop.Messages()); !equal_timestamps(v, []uint64{2, 3, 10, 11, 12}) {
+		t.Errorf("cached %v", v)
+	}
+
+	// the next keyframe drops the previous gop.
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 20, []byte{0x17, 0x01}))
+	gop.Cache(new_av_message(RTMP_MSG_AudioMessage, 21, []byte{0xaf, 0x01}))
+	if v := message_timestamps(gop.Messages()); !equal_timestamps(v, []uint64{2, 3, 20, 21}) {
+		t.Errorf("cached %v", v)
+	}
+
+	// the new sequence header replaces the cached one, keep the gop.
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 22, []byte{0x17, 0x00}))
+	if v := message_timestamps(gop.Messages()); !equal_timestamps(v, []uint64{22, 3, 20, 21}) {
+		t.Errorf("cached %v", v)
+	}
+
+	gop.Clear()
+	if msgs := gop.Messages(); len(msgs) != 0 {
+		t.Errorf("cached %v messages after clear", len(msgs))
+	}
+}
+
+func TestGopCacheMaxMessages(t *testing.T) {
+	gop := NewGopCache(3)
+
+	for _, ts := range []uint64{10, 11, 12} {
+		gop.Cache(new_av_message(RTMP_MSG_VideoMessage, ts, []byte{0x27, 0x01}))
+	}
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 0, []byte{0x17, 0x01}))
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 1, []byte{0x27, 0x01}))
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 2, []byte{0x27, 0x01}))
+	if v := message_timestamps(gop.Messages()); !equal_timestamps(v, []uint64{0, 1, 2}) {
+		t.Fatalf("cached %v", v)
+	}
+
+	// exceed the max, drop to the keyframe and ignore until the next keyframe.
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 3, []byte{0x27, 0x01}))
+	gop.Cache(new_av_message(RTMP_MSG_AudioMessage, 4, []byte{0xaf, 0x01}))
+	if v := message_timestamps(gop.Messages()); !equal_timestamps(v, []uint64{0}) {
+		t.Errorf("cached %v, expect the keyframe", v)
+	}
+
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 5, []byte{0x17, 0x01}))
+	gop.Cache(new_av_message(RTMP_MSG_VideoMessage, 6, []byte{0x27, 0x01}))
+	if v := message_timestamps(gop.Messages()); !equal_timestamps(v, []uint64{5, 6}) {
+		t.Errorf("cached %v, expect the new gop", v)
+	}
+}
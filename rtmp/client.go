@@ -0,0 +1,438 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+/**
+* the default args of connect app request, for the client.
+* @see: SrsRtmpClient::connect_app
+*/
+const (
+	CLIENT_FLASH_VER = "WIN 12,0,0,41"
+	CLIENT_CAPABILITIES = 239
+	CLIENT_AUDIO_CODECS = 3575
+	CLIENT_VIDEO_CODECS = 252
+	CLIENT_VIDEO_FUNCTION = 1
+)
+
+/**
+* the rtmp client interface, user can create it by func NewClient(),
+* or dial and connect to server by func Dial().
+ */
+type Client interface {
+	/**
+	* destroy the client stack.
+	 */
+	Destroy()
+	/**
+	* close the client gracefully, send deleteStream for the streams
+	* created by Publish or Play, then close the protocol.
+	* @remark it's idempotent and goroutine safe, the second Close is ignored.
+	 */
+	Close() (err error)
+	/**
+	* get the underlayer protocol stack sdk.
+	 */
+	Protocol() (Protocol)
+	/**
+	* handshake with server, use simple handshake.
+	 */
+	Handshake() (err error)
+	/**
+	* send the connect app request to server, and wait for the response,
+	* the Window Ack Size and Set Peer Bandwidth is handled by protocol.
+	* @param tc_url the tcUrl, for example, rtmp://server:1935/live
+	* @param args the args to override the default connect args, nil to ignore,
+	* 		for example, set the objectEncoding to 3 for AMF3.
+	* @return the response of server, the Info contains the code and fmsVer etc.
+	 */
+	ConnectApp(tc_url string, args *Amf0Object) (res *ConnectAppResPacket, err error)
+	/**
+	* publish the stream, like FMLE, send releaseStream, FCPublish, createStream and publish,
+	* then wait for the onStatus(NetStream.Publish.Start) of server.
+	* user can send the audio/video/metadata over the stream_id when return.
+	* @param stream_name the stream to publish, for example, livestream or the stream key.
+	* @param publish_type the publish type, for example, PUBLISH_TYPE_Live, "" to use live.
+	* @return the stream id allocated by server.
+	 */
+	Publish(stream_name string, publish_type string) (stream_id uint32, err error)
+	/**
+	* play the stream, send createStream and play, then wait for the
+	* StreamBegin and onStatus(NetStream.Play.Start) of server,
+	* the onStatus(NetStream.Play.Reset) before start is ignored.
+	* user can loop RecvMessage to get the audio/video/metadata when return,
+	* the audio/video/data received while waiting are queued and got first.
+	* @param stream_name the stream to play, for example, livestream.
+	* @return the stream id allocated by server, and the first metadata
+	* 		if server send it before return, nil if not.
+	 */
+	Play(stream_name string) (stream_id uint32, metadata *OnMetaDataPacket, err error)
+	/**
+	* recv the message, get the messages queued by Play first,
+	* then from the protocol, @see Protocol.RecvMessage.
+	* @remark not goroutine safe, use it in the goroutine of Play.
+	 */
+	RecvMessage() (msg *Message, err error)
+}
+func NewClient(conn net.Conn) (Client, error) {
+	var err error
+	r := &client{}
+	r.streams_lock = &sync.Mutex{}
+	if r.protocol, err = NewProtocol(conn); err != nil {
+		return r, err
+	}
+	return r, err
+}
+
+/**
+* dial to the server of tc_url, handshake and connect app,
+* return the connected client ready for createStream.
+* @param tc_url the tcUrl, for example, rtmp://server:1935/live,
+* 		the port is DefaultPort when not specified.
+* 		the rtmps://server/live is dialed over TLS by DialRTMPS,
+* 		the port is DefaultRTMPSPort when not specified.
+* @param args the args to override the default connect args, nil to ignore.
+*/
+func Dial(tc_url string, args *Amf0Object) (c Client, res *ConnectAppResPacket, err error) {
+	var u *RtmpUrl
+	if u, err = ParseTcUrl(tc_url); err != nil {
+		return
+	}
+
+	var conn net.Conn
+	if u.Schema == "rtmps" {
+		conn, err = DialRTMPS(net.JoinHostPort(u.Host, u.Port), nil)
+	} else {
+		conn, err = net.Dial("tcp", net.JoinHostPort(u.Host, u.Port))
+	}
+	if err != nil {
+		return
+	}
+
+	var cli Client
+	if cli, err = NewClient(conn); err != nil {
+		conn.Close()
+		return
+	}
+
+	// close the conn to abort the goroutines, then destroy the client.
+	defer func() {
+		if err != nil {
+			conn.Close()
+			cli.Destroy()
+		}
+	}()
+
+	if err = cli.Handshake(); err != nil {
+		return
+	}
+
+	if res, err = cli.ConnectApp(tc_url, args); err != nil {
+		return
+	}
+
+	return cli, res, nil
+}
+
+type client struct {
+	protocol Protocol
+	// the streams created by createStream.
+	streams []uint32
+	streams_lock *sync.Mutex
+	// the audio/video/data received by Play before start.
+	pending []*Message
+}
+
+func (r *client) Destroy() {
+	r.protocol.Destroy()
+}
+
+func (r *client) Close() (err error) {
+	// the stream is deleted once, the second Close do nothing.
+	r.streams_lock.Lock()
+	streams := r.streams
+	r.streams = nil
+	r.streams_lock.Unlock()
+
+	for _, stream_id := range streams {
+		pkt := NewDeleteStreamPacket()
+		pkt.StreamId = float64(stream_id)
+		if err = r.protocol.SendPacket(pkt, uint32(0)); err != nil {
+			break
+		}
+	}
+
+	if cerr := r.protocol.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+func (r *client) Protocol() (Protocol) {
+	return r.protocol
+}
+
+func (r *client) Handshake() (err error) {
+	return r.protocol.SimpleHandshake2Server()
+}
+
+func (r *client) ConnectApp(tc_url string, args *Amf0Object) (res *ConnectAppResPacket, err error) {
+	// the app of tcUrl, for example, the app of rtmp://ip/app...vhost...xxx
+	var u *RtmpUrl
+	if u, err = parse_tc_url(tc_url); err != nil {
+		return
+	}
+
+	app := u.App
+	if app == "" {
+		err = Error{code:ERROR_RTMP_REQ_TCURL, desc:fmt.Sprintf("discovery app failed. tcUrl=%v", tc_url)}
+		return
+	}
+
+	// connect(tcUrl)
+	if true {
+		pkt := NewConnectAppPacket()
+		pkt.Set("app", app).Set("flashVer", CLIENT_FLASH_VER).Set("tcUrl", tc_url)
+		pkt.Set("fpad", false).Set("capabilities", float64(CLIENT_CAPABILITIES))
+		pkt.Set("audioCodecs", float64(CLIENT_AUDIO_CODECS)).Set("videoCodecs", float64(CLIENT_VIDEO_CODECS))
+		pkt.Set("videoFunction", float64(CLIENT_VIDEO_FUNCTION)).Set("objectEncoding", float64(CodecAMF0))
+		if args != nil {
+			for _, k := range args.properties.property_index {
+				pkt.CommandObject.Set(k, args.properties.properties[k])
+			}
+		}
+		if err = r.protocol.SendPacket(pkt, uint32(0)); err != nil {
+			return
+		}
+
+		// the connect is AMF0, the commands after it is AMF3 for AMF3.
+		if v, ok := pkt.CommandObject.GetPropertyNumber("objectEncoding"); ok && int(v) == CodecAMF3 {
+			r.protocol.SetObjectEncoding(CodecAMF3)
+		}
+	}
+
+	// expect the _result or _error of connect.
+	for {
+		var msg *Message
+		if msg, err = r.protocol.RecvMessage(); err != nil {
+			return
+		}
+
+		var pkt interface {}
+		if pkt, err = r.protocol.DecodeMessage(msg); err != nil {
+			return
+		}
+
+		if pkt, ok := pkt.(*CallResPacket); ok && pkt.IsError() {
+			err = Error{code:ERROR_RTMP_REQ_CONNECT, desc:fmt.Sprintf("connect app rejected. tcUrl=%v", tc_url)}
+			return
+		}
+
+		if pkt, ok := pkt.(*ConnectAppResPacket); ok {
+			if code, _ := pkt.Info.GetPropertyString(SCODE); code != SCODE_ConnectSuccess {
+				err = Error{code:ERROR_RTMP_REQ_CONNECT, desc:fmt.Sprintf("connect app failed. code=%v, tcUrl=%v", code, tc_url)}
+				return
+			}
+			return pkt, nil
+		}
+	}
+}
+
+func (r *client) Publish(stream_name string, publish_type string) (stream_id uint32, err error) {
+	// releaseStream(stream_name), FCPublish(stream_name)
+	if true {
+		if err = r.protocol.SendPacket(NewReleaseStreamPacket(r.protocol.NextTransactionId(), stream_name), uint32(0)); err != nil {
+			return
+		}
+		if err = r.protocol.SendPacket(NewFCPublishPacket(r.protocol.NextTransactionId(), stream_name), uint32(0)); err != nil {
+			return
+		}
+	}
+
+	// createStream
+	if stream_id, err = r.create_stream(r.protocol.NextTransactionId(), stream_name); err != nil {
+		return
+	}
+
+	// publish(stream_name)
+	if true {
+		pkt := NewPublishPacket()
+		pkt.StreamName = stream_name
+		if publish_type != "" {
+			pkt.StreamType = publish_type
+		}
+		if err = r.protocol.SendPacket(pkt, stream_id); err != nil {
+			return
+		}
+	}
+
+	// expect the onStatus(NetStream.Publish.Start), ignore the onFCPublish.
+	var info *Amf0Object
+	for info == nil {
+		var pkt interface {}
+		if pkt, err = r.recv_packet(); err != nil {
+			return
+		}
+		info, _ = on_status_info(pkt)
+	}
+
+	level, _ := info.GetPropertyString(SLEVEL)
+	code, _ := info.GetPropertyString(SCODE)
+	if level == SLEVEL_Error || code != SCODE_PublishStart {
+		err = Error{code:ERROR_RTMP_ACCESS_DENIED, desc:fmt.Sprintf("publish failed. level=%v, code=%v, stream=%v", level, code, stream_name)}
+		return
+	}
+	return
+}
+
+func (r *client) Play(stream_name string) (stream_id uint32, metadata *OnMetaDataPacket, err error) {
+	// createStream
+	if stream_id, err = r.create_stream(r.protocol.NextTransactionId(), stream_name); err != nil {
+		return
+	}
+
+	// play(stream_name)
+	if true {
+		pkt := NewPlayPacket()
+		pkt.StreamName = stream_name
+		if err = r.protocol.SendPacket(pkt, stream_id); err != nil {
+			return
+		}
+	}
+
+	// expect the StreamBegin and onStatus(NetStream.Play.Start),
+	// ignore the onStatus(NetStream.Play.Reset) before start.
+	var stream_begin, play_start bool
+	for !stream_begin || !play_start {
+		var msg *Message
+		if msg, err = r.protocol.RecvMessage(); err != nil {
+			return
+		}
+
+		// the server may send media before start, queue it for RecvMessage.
+		if msg.IsAudio() || msg.IsVideo() || msg.IsData() || msg.Header.IsAggregate() {
+			r.pending = append(r.pending, msg)
+			if !msg.IsData() || metadata != nil {
+				continue
+			}
+		}
+
+		var pkt interface {}
+		if pkt, err = r.protocol.DecodeMessage(msg); err != nil {
+			return
+		}
+
+		if pkt, ok := pkt.(*UserControlPacket); ok {
+			if pkt.EventType == PCUCStreamBegin {
+				stream_begin = true
+			}
+			continue
+		}
+
+		// the first metadata, the server may send before start.
+		if pkt, ok := pkt.(*OnMetaDataPacket); ok {
+			metadata = pkt
+			continue
+		}
+
+		info, ok := on_status_info(pkt)
+		if !ok {
+			continue
+		}
+
+		level, _ := info.GetPropertyString(SLEVEL)
+		code, _ := info.GetPropertyString(SCODE)
+		if level == SLEVEL_Error {
+			err = Error{code:ERROR_RTMP_ACCESS_DENIED, desc:fmt.Sprintf("play failed. level=%v, code=%v, stream=%v", level, code, stream_name)}
+			return
+		}
+		if code == SCODE_StreamStart {
+			play_start = true
+		}
+	}
+	return
+}
+
+/**
+* send the createStream request, and wait for the _result,
+* ignore other packets, for example, the response of FCPublish.
+*/
+func (r *client) create_stream(transaction_id float64, stream_name string) (stream_id uint32, err error) {
+	if true {
+		pkt := NewCreateStreamPacket()
+		pkt.TransactionId = transaction_id
+		if err = r.protocol.SendPacket(pkt, uint32(0)); err != nil {
+			return
+		}
+	}
+
+	for {
+		var pkt interface {}
+		if pkt, err = r.recv_packet(); err != nil {
+			return
+		}
+
+		if pkt, ok := pkt.(*CallResPacket); ok && pkt.IsError() && pkt.TransactionId == transaction_id {
+			err = Error{code:ERROR_RTMP_ACCESS_DENIED, desc:fmt.Sprintf("create stream rejected. stream=%v", stream_name)}
+			return
+		}
+
+		if pkt, ok := pkt.(*CreateStreamResPacket); ok {
+			stream_id = uint32(pkt.StreamId)
+			r.streams_lock.Lock()
+			r.streams = append(r.streams, stream_id)
+			r.streams_lock.Unlock()
+			return
+		}
+	}
+}
+
+func (r *client) RecvMessage() (msg *Message, err error) {
+	if len(r.pending) > 0 {
+		msg, r.pending = r.pending[0], r.pending[1:]
+		return
+	}
+	return r.protocol.RecvMessage()
+}
+
+// get the info object of onStatus call, which is the first argument.
+func on_status_info(pkt interface {}) (info *Amf0Object, ok bool) {
+	call, ok := pkt.(*CallPacket)
+	if !ok || call.CommandName != AMF0_COMMAND_ON_STATUS || len(call.Arguments) == 0 {
+		return nil, false
+	}
+	return call.Arguments[0].Object()
+}
+
+// recv a message and decode it to packet.
+func (r *client) recv_packet() (pkt interface {}, err error) {
+	var msg *Message
+	if msg, err = r.protocol.RecvMessage(); err != nil {
+		return
+	}
+	return r.protocol.DecodeMessage(msg)
+}
@@ -0,0 +1,331 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+/**
+* create the connected client and server over net.Pipe, the handshake is done,
+* the server is served by the handler in goroutine, for example:
+* 		c := client_loopback(t, func(srv *server) {
+* 			srv.ConnectApp(NewRequest())
+* 		})
+*/
+func client_loopback(t testing.TB, handler func(srv *server)) (c *client) {
+	cc, sc := net.Pipe()
+	v, _ := NewClient(cc)
+	s, _ := NewServer(sc)
+	c, srv := v.(*client), s.(*server)
+	done := make(chan bool)
+	t.Cleanup(func() {
+		cc.Close()
+		sc.Close()
+		<-done
+		c.protocol.Close()
+		srv.protocol.Close()
+	})
+
+	go func() {
+		defer close(done)
+		if err := srv.Handshake(); err != nil {
+			t.Error(err)
+			return
+		}
+		handler(srv)
+	}()
+
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s, _ := NewServer(conn)
+			req := NewRequest()
+			if err = s.Handshake(); err == nil {
+				err = s.ConnectApp(req)
+			}
+			// reject the app "deny" by closing the connection.
+			if err == nil && req.App != "deny" {
+				err = s.ReponseConnectApp(req, "", nil)
+			}
+			s.Close()
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	c, res, err := Dial(fmt.Sprintf("rtmp://127.0.0.1:%v/live", port), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if code, _ := res.Info.GetPropertyString(SCODE); code != SCODE_ConnectSuccess {
+		t.Errorf("code=%v", code)
+	}
+
+	if c, _, err = Dial(fmt.Sprintf("rtmp://127.0.0.1:%v/deny", port), nil); err == nil || c != nil {
+		t.Errorf("expect error and nil client, err=%v", err)
+	}
+}
+
+func TestDialRTMPS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the rtmps dial over TLS, the first byte is the TLS handshake record.
+	first := make(chan byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		b := make([]byte, 1)
+		io.ReadFull(conn, b)
+		first <- b[0]
+		conn.Close()
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	if _, _, err = Dial(fmt.Sprintf("rtmps://127.0.0.1:%v/live", port), nil); err == nil {
+		t.Error("expect tls error for plain server")
+	}
+	if b := <-first; b != 0x16 {
+		t.Errorf("first byte %#x, expect tls handshake 0x16", b)
+	}
+}
+
+func TestClientConnectAppTcUrl(t *testing.T) {
+	apps := make(chan string, 1)
+	c := client_loopback(t, func(srv *server) {
+		p := srv.protocol
+		msg, err := p.RecvMessage()
+		if err != nil {
+			return
+		}
+		pkt, _ := p.DecodeMessage(msg)
+		if pkt, ok := pkt.(*ConnectAppPacket); ok {
+			app, _ := pkt.CommandObject.GetPropertyString("app")
+			apps <- app
+		}
+		p.SendPacket(NewConnectAppResPacket().InfoSet(SCODE, SCODE_ConnectSuccess), 0)
+	})
+
+	// the vhost in tcUrl is not part of app.
+	if _, err := c.ConnectApp("rtmp://127.0.0.1/live...vhost...ossrs.net", nil); err != nil {
+		t.Fatal(err)
+	}
+	if app := <-apps; app != "live" {
+		t.Errorf("app=%v, expect live", app)
+	}
+
+	if _, err := c.ConnectApp("rtmp:///live", nil); err == nil {
+		t.Error("expect error for no host")
+	}
+}
+
+func TestClientPublishTransactionId(t *testing.T) {
+	var tids []float64
+	c := client_loopback(t, func(srv *server) {
+		p := srv.protocol
+		for {
+			msg, err := p.RecvMessage()
+			if err != nil {
+				return
+			}
+			pkt, _ := p.DecodeMessage(msg)
+
+			// response the requests by the transaction id.
+			var res Encoder
+			switch pkt := pkt.(type) {
+			case *FMLEStartPacket:
+				tids = append(tids, pkt.TransactionId)
+				res = NewFMLEStartResPacket(pkt.TransactionId)
+			case *CreateStreamPacket:
+				tids = append(tids, pkt.TransactionId)
+				res = NewCreateStreamResPacket(pkt.TransactionId, 1)
+			case *PublishPacket:
+				res = NewOnStatusCallPacket().Set(SLEVEL, SLEVEL_Status).Set(SCODE, SCODE_PublishStart)
+			default:
+				continue
+			}
+			if err = p.SendPacket(res, msg.Header.StreamId); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	// the requests before publish, for example, the connect and calls.
+	for i := 0; i < 5; i++ {
+		c.protocol.NextTransactionId()
+	}
+
+	stream_id, err := c.Publish("livestream", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream_id != 1 {
+		t.Errorf("stream id %v, expect 1", stream_id)
+	}
+	if len(tids) != 3 || tids[0] != 6 || tids[1] != 7 || tids[2] != 8 {
+		t.Errorf("transaction ids %v, expect [6 7 8]", tids)
+	}
+}
+
+func TestClientPlayEarlyMedia(t *testing.T) {
+	video := new_av_message(RTMP_MSG_VideoMessage, 0, []byte{0x17, 0x01, 0x00})
+	audio := new_av_message(RTMP_MSG_AudioMessage, 0, []byte{0xaf, 0x01, 0x21})
+	later := new_av_message(RTMP_MSG_VideoMessage, 40, []byte{0x27, 0x01, 0x00})
+	metadata := NewOnMetaDataPacket()
+	metadata.Metadata.Set("width", NewAmf0(float64(1280)))
+
+	c := client_loopback(t, func(srv *server) {
+		p := srv.protocol
+		var create *CreateStreamPacket
+		if _, err := p.ExpectPacket(&create); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := p.SendPacket(NewCreateStreamResPacket(create.TransactionId, 1), 0); err != nil {
+			t.Error(err)
+			return
+		}
+		var play *PlayPacket
+		if _, err := p.ExpectPacket(&play); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// the media and metadata before StreamBegin and Play.Start.
+		reset := NewOnStatusCallPacket().Set(SLEVEL, SLEVEL_Status).Set(SCODE, SCODE_StreamReset)
+		start := NewOnStatusCallPacket().Set(SLEVEL, SLEVEL_Status).Set(SCODE, SCODE_StreamStart)
+		for _, v := range []interface {}{video, metadata, &UserControlPacket{EventType:PCUCStreamBegin, EventData:1}, reset, audio, start, later} {
+			var err error
+			if msg, ok := v.(*Message); ok {
+				err = p.SendSharedMessage(msg, 1)
+			} else {
+				err = p.SendPacket(v.(Encoder), 1)
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	stream_id, md, err := c.Play("livestream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream_id != 1 || md == nil {
+		t.Fatalf("stream id %v, metadata %v", stream_id, md)
+	}
+	if v, _ := md.Metadata.GetPropertyNumber("width"); v != 1280 {
+		t.Errorf("width=%v", v)
+	}
+
+	// the media before start is got first, in order.
+	for i, expect := range []byte{RTMP_MSG_VideoMessage, RTMP_MSG_AMF0DataMessage, RTMP_MSG_AudioMessage, RTMP_MSG_VideoMessage} {
+		msg, err := c.RecvMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Header.MessageType != expect {
+			t.Errorf("#%v type %v, expect %v", i, msg.Header.MessageType, expect)
+		}
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	deleted := make(chan float64, 10)
+	c := client_loopback(t, func(srv *server) {
+		p := srv.protocol
+		for {
+			msg, err := p.RecvMessage()
+			if err != nil {
+				close(deleted)
+				return
+			}
+			pkt, _ := p.DecodeMessage(msg)
+
+			switch pkt := pkt.(type) {
+			case *CreateStreamPacket:
+				if err = p.SendPacket(NewCreateStreamResPacket(pkt.TransactionId, 1), 0); err != nil {
+					t.Error(err)
+				}
+			case *DeleteStreamPacket:
+				deleted <- pkt.StreamId
+			}
+		}
+	})
+
+	if _, err := c.create_stream(c.protocol.NextTransactionId(), "livestream"); err != nil {
+		t.Fatal(err)
+	}
+
+	// close in different goroutines, the stream is deleted once.
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- c.Close()
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	var streams []float64
+	for v := range deleted {
+		streams = append(streams, v)
+	}
+	if len(streams) != 1 || streams[0] != 1 {
+		t.Errorf("deleted streams %v, want [1]", streams)
+	}
+
+	// the second Close is a no-op.
+	if err := c.Close(); err != nil {
+		t.Errorf("second close got %v", err)
+	}
+}
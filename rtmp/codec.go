@@ -0,0 +1,300 @@
+// The MIT License (MIT)
+// 
+// Copyright (c) 2014 winlin
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+/**
+* the FLV/RTMP audio codec id, the SoundFormat of audio tag header.
+* @see: E.4.2 Audio Tags, video_file_format_spec_v10_1.pdf, page 76
+*/
+const (
+	CodecAudioLinearPCMPlatformEndian = 0
+	CodecAudioADPCM = 1
+	CodecAudioMP3 = 2
+	CodecAudioLinearPCMLittleEndian = 3
+	CodecAudioNellymoser16kHzMono = 4
+	CodecAudioNellymoser8kHzMono = 5
+	CodecAudioNellymoser = 6
+	CodecAudioReservedG711AlawLogarithmicPCM = 7
+	CodecAudioReservedG711MuLawLogarithmicPCM = 8
+	CodecAudioReserved = 9
+	CodecAudioAAC = 10
+	CodecAudioSpeex = 11
+	CodecAudioReservedMP3_8kHz = 14
+	CodecAudioReservedDeviceSpecificSound = 15
+)
+
+/**
+* the audio sample rate, the SoundRate of audio tag header.
+* for AAC, always 3(44kHz), the real rate is in the AudioSpecificConfig.
+*/
+const (
+	CodecAudioSampleRate5512 = 0
+	CodecAudioSampleRate11025 = 1
+	CodecAudioSampleRate22050 = 2
+	CodecAudioSampleRate44100 = 3
+)
+
+// the SoundSize of audio tag header.
+const (
+	CodecAudioSampleSize8bit = 0
+	CodecAudioSampleSize16bit = 1
+)
+
+// the SoundType of audio tag header.
+const (
+	CodecAudioSoundTypeMono = 0
+	CodecAudioSoundTypeStereo = 1
+)
+
+// the AACPacketType, only valid when SoundFormat is AAC.
+const (
+	CodecAudioTypeSequenceHeader = 0
+	CodecAudioTypeRawData = 1
+)
+
+/**
+* the audio message, the payload is the FLV audio tag data.
+* the first byte is the codec info, AAC has another byte AACPacketType.
+* @remark the Payload references the message payload, never copy.
+* @see: E.4.2 Audio Tags, video_file_format_spec_v10_1.pdf, page 76
+*/
+type AudioPacket struct {
+	SoundFormat byte
+	SoundRate byte
+	SoundSize byte
+	SoundType byte
+	// only valid when SoundFormat is AAC.
+	AACPacketType byte
+	// the whole payload of message, include the tag header.
+	Payload []byte
+}
+func NewAudioPacket() (*AudioPacket) {
+	return &AudioPacket{}
+}
+// whether the packet is the AAC sequence header, the AudioSpecificConfig.
+func (r *AudioPacket) IsSequenceHeader() (bool) {
+	return r.SoundFormat == CodecAudioAAC && r.AACPacketType == CodecAudioTypeSequenceHeader
+}
+// Decoder
+func (r *AudioPacket) Decode(s *Buffer) (err error) {
+	// empty audio is ok, for example, some encoder send it.
+	if s.Empty() {
+		return
+	}
+
+	r.Payload = s.Read(s.Left())
+	b := r.Payload[0]
+
+	r.SoundFormat = (b >> 4) & 0x0f
+	r.SoundRate = (b >> 2) & 0x03
+	r.SoundSize = (b >> 1) & 0x01
+	r.SoundType = b & 0x01
+
+	if r.SoundFormat == CodecAudioAAC {
+		if len(r.Payload) < 2 {
+			return Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:"decode aac packet type failed"}
+		}
+		r.AACPacketType = r.Payload[1]
+	}
+	return
+}
+// Encoder
+func (r *AudioPacket) GetPerferCid() (v int) {
+	return RTMP_CID_Audio
+}
+func (r *AudioPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AudioMessage
+}
+func (r *AudioPacket) GetSize() (v int) {
+	return len(r.Payload)
+}
+func (r *AudioPacket) Encode(s *Buffer) (err error) {
+	s.Write(r.Payload)
+	return
+}
+
+/**
+* the FLV/RTMP video frame type, the FrameType of video tag header.
+* @see: E.4.3 Video Tags, video_file_format_spec_v10_1.pdf, page 78
+*/
+const (
+	CodecVideoAVCFrameKeyFrame = 1
+	CodecVideoAVCFrameInterFrame = 2
+	CodecVideoAVCFrameDisposableInterFrame = 3
+	CodecVideoAVCFrameGeneratedKeyFrame = 4
+	CodecVideoAVCFrameVideoInfoFrame = 5
+)
+
+// the CodecID of video tag header.
+const (
+	CodecVideoSorensonH263 = 2
+	CodecVideoScreenVideo = 3
+	CodecVideoOn2VP6 = 4
+	CodecVideoOn2VP6WithAlphaChannel = 5
+	CodecVideoScreenVideoVersion2 = 6
+	CodecVideoAVC = 7
+)
+
+// the AVCPacketType, only valid when CodecID is AVC.
+const (
+	CodecVideoAVCTypeSequenceHeader = 0
+	CodecVideoAVCTypeNALU = 1
+	CodecVideoAVCTypeSequenceHeaderEOF = 2
+)
+
+/**
+* the video message, the payload is the FLV video tag data.
+* the first byte is the codec info, AVC has another 4bytes,
+* the AVCPacketType and the CompositionTime.
+* @remark the Payload references the message payload, never copy.
+* @see: E.4.3 Video Tags, video_file_format_spec_v10_1.pdf, page 78
+*/
+type VideoPacket struct {
+	FrameType byte
+	CodecId byte
+	// only valid when CodecId is AVC.
+	AVCPacketType byte
+	CompositionTime int32
+	// the whole payload of message, include the tag header.
+	Payload []byte
+}
+func NewVideoPacket() (*VideoPacket) {
+	return &VideoPacket{}
+}
+// whether the packet is keyframe, the sequence header is also keyframe.
+func (r *VideoPacket) IsKeyframe() (bool) {
+	return r.FrameType == CodecVideoAVCFrameKeyFrame
+}
+// whether the packet is the AVC sequence header, the AVCDecoderConfigurationRecord(sps/pps).
+func (r *VideoPacket) IsSequenceHeader() (bool) {
+	return r.IsKeyframe() && r.CodecId == CodecVideoAVC && r.AVCPacketType == CodecVideoAVCTypeSequenceHeader
+}
+// Decoder
+func (r *VideoPacket) Decode(s *Buffer) (err error) {
+	// empty video is ok, for example, some encoder send it.
+	if s.Empty() {
+		return
+	}
+
+	r.Payload = s.Read(s.Left())
+	b := r.Payload[0]
+
+	r.FrameType = (b >> 4) & 0x0f
+	r.CodecId = b & 0x0f
+
+	if r.CodecId == CodecVideoAVC {
+		if len(r.Payload) < 5 {
+			return Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:"decode avc packet type and composition time failed"}
+		}
+		r.AVCPacketType = r.Payload[1]
+
+		// SI24, sign extend the 24bits composition time.
+		ct := uint32(r.Payload[2]) << 16 | uint32(r.Payload[3]) << 8 | uint32(r.Payload[4])
+		r.CompositionTime = int32(ct << 8) >> 8
+	}
+	return
+}
+// Encoder
+func (r *VideoPacket) GetPerferCid() (v int) {
+	return RTMP_CID_Video
+}
+func (r *VideoPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_VideoMessage
+}
+func (r *VideoPacket) GetSize() (v int) {
+	return len(r.Payload)
+}
+func (r *VideoPacket) Encode(s *Buffer) (err error) {
+	s.Write(r.Payload)
+	return
+}
+
+/**
+* the aggregate message, which consists of a number of sub-messages,
+* each sub-message is a FLV tag without the FLV header:
+* 		TagType(1B) DataSize(3B) Timestamp(3B) TimestampExtended(1B) StreamID(3B)
+* 		Data(DataSize) PreviousTagSize(4B)
+* the first sub-message timestamp is the base, the timestamp of
+* sub-message is the aggregate timestamp plus the offset to the base.
+* @remark the payload of sub-message references the aggregate payload, never copy.
+* @see: RTMP 3.6. Aggregate message
+*/
+type AggregatePacket struct {
+	// the header of aggregate message.
+	Header MessageHeader
+	// the splitted sub-messages.
+	Messages []*Message
+}
+func NewAggregatePacket(header *MessageHeader) (*AggregatePacket) {
+	r := &AggregatePacket{}
+	r.Header = *header
+	return r
+}
+// Decoder
+func (r *AggregatePacket) Decode(s *Buffer) (err error) {
+	var base uint64
+	for i := 0; !s.Empty(); i++ {
+		if !s.Requires(11) {
+			return Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:"decode aggregate tag header failed"}
+		}
+		tag_type := s.ReadByte()
+		data_size := int(s.ReadUInt24())
+		timestamp := uint64(s.ReadUInt24())
+		timestamp |= uint64(s.ReadByte()) << 24
+		s.ReadUInt24() // stream id, always 0.
+
+		if !s.Requires(data_size + 4) {
+			return Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:"decode aggregate tag data failed"}
+		}
+		data := s.Read(data_size)
+		s.ReadUInt32() // previous tag size.
+
+		// the first tag is the base timestamp.
+		if i == 0 {
+			base = timestamp
+		}
+
+		msg := NewMessage()
+		msg.Header.MessageType = tag_type
+		msg.Header.PayloadLength = uint32(data_size)
+		msg.Header.StreamId = r.Header.StreamId
+		msg.Header.Timestamp = r.Header.Timestamp
+		// the jitter before base is corrected to the aggregate timestamp.
+		if timestamp > base {
+			msg.Header.Timestamp += timestamp - base
+		}
+		msg.Payload = data
+		msg.ReceivedPayloadLength = data_size
+
+		switch {
+		case msg.Header.IsAudio():
+			msg.PerferCid = RTMP_CID_Audio
+		case msg.Header.IsVideo():
+			msg.PerferCid = RTMP_CID_Video
+		default:
+			msg.PerferCid = RTMP_CID_OverConnection2
+		}
+
+		r.Messages = append(r.Messages, msg)
+	}
+	return
+}
@@ -0,0 +1,88 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"testing"
+)
+
+func TestAudioPacketAAC(t *testing.T) {
+	// 0xaf, AAC 44kHz 16bit stereo, then the AACPacketType.
+	pkt, ok := decode_packet(t, RTMP_MSG_AudioMessage, "\xaf\x00\x12\x10").(*AudioPacket)
+	if !ok {
+		t.Fatalf("decode %T, expect audio", pkt)
+	}
+	if pkt.SoundFormat != CodecAudioAAC || pkt.SoundRate != CodecAudioSampleRate44100 {
+		t.Errorf("format=%v rate=%v", pkt.SoundFormat, pkt.SoundRate)
+	}
+	if pkt.SoundSize != CodecAudioSampleSize16bit || pkt.SoundType != CodecAudioSoundTypeStereo {
+		t.Errorf("size=%v type=%v", pkt.SoundSize, pkt.SoundType)
+	}
+	if !pkt.IsSequenceHeader() || len(pkt.Payload) != 4 {
+		t.Errorf("expect sequence header of 4 bytes, got %v bytes", len(pkt.Payload))
+	}
+
+	if pkt, _ := decode_packet(t, RTMP_MSG_AudioMessage, "\xaf\x01\x21\x00").(*AudioPacket); pkt.IsSequenceHeader() {
+		t.Error("the raw data is not sequence header")
+	}
+}
+
+// the FLV tag of aggregate message, with the previous tag size.
+func aggregate_tag(tag_type byte, timestamp uint32, data []byte) ([]byte) {
+	b := make([]byte, 11 + len(data) + 4)
+	s := NewRtmpStream(b)
+	s.WriteByte(tag_type).WriteUInt24(uint32(len(data)))
+	s.WriteUInt24(timestamp & 0xFFFFFF).WriteByte(byte(timestamp >> 24)).WriteUInt24(0)
+	s.Write(data).WriteUInt32(uint32(11 + len(data)))
+	return b
+}
+
+func TestAggregatePacket(t *testing.T) {
+	// the second audio jitter before the first tag.
+	var payload []byte
+	payload = append(payload, aggregate_tag(RTMP_MSG_VideoMessage, 1000, []byte{0x17, 0x01, 0, 0, 0})...)
+	payload = append(payload, aggregate_tag(RTMP_MSG_AudioMessage, 990, []byte{0xaf, 0x01, 0x21})...)
+	payload = append(payload, aggregate_tag(RTMP_MSG_VideoMessage, 1040, []byte{0x27, 0x01, 0, 0, 0})...)
+
+	msg := new_av_message(RTMP_MSG_AggregateMessage, 5000, payload)
+	p, _ := new_mock_protocol(encode_chunks(t, msg))
+	pkt, err := p.DecodeMessage(mock_recv_message(t, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agg, ok := pkt.(*AggregatePacket)
+	if !ok || len(agg.Messages) != 3 {
+		t.Fatalf("expect 3 messages, got %+v", pkt)
+	}
+
+	for i, timestamp := range []uint64{5000, 5000, 5040} {
+		sub := agg.Messages[i]
+		if sub.Header.Timestamp != timestamp || sub.Header.StreamId != 1 {
+			t.Errorf("#%v timestamp=%v stream=%v, expect %v", i, sub.Header.Timestamp, sub.Header.StreamId, timestamp)
+		}
+
+		// the sub-message can be decoded.
+		if pkt, err := sub.Packet(); err != nil || pkt == nil {
+			t.Errorf("#%v decode %v, err=%v", i, pkt, err)
+		}
+	}
+}
@@ -185,9 +185,17 @@ const RTMP_EXTENDED_TIMESTAMP  = 0xFFFFFF
 const AMF0_COMMAND_CONNECT = "connect"
 const AMF0_COMMAND_CREATE_STREAM = "createStream"
 const AMF0_COMMAND_CLOSE_STREAM = "closeStream"
+const AMF0_COMMAND_DELETE_STREAM = "deleteStream"
 const AMF0_COMMAND_PLAY = "play"
 const AMF0_COMMAND_PAUSE = "pause"
+const AMF0_COMMAND_RECEIVE_AUDIO = "receiveAudio"
+const AMF0_COMMAND_RECEIVE_VIDEO = "receiveVideo"
 const AMF0_COMMAND_ON_BW_DONE = "onBWDone"
+// the FMS bandwidth check, client call _checkbw, server call _onbwcheck
+// with payload and client response _result, finally server call _onbwdone.
+const AMF0_COMMAND_CHECK_BW = "_checkbw"
+const AMF0_COMMAND_ON_BW_CHECK = "_onbwcheck"
+const AMF0_COMMAND_ON_BW_CHECK_DONE = "_onbwdone"
 const AMF0_COMMAND_ON_STATUS = "onStatus"
 const AMF0_COMMAND_RESULT = "_result"
 const AMF0_COMMAND_ERROR = "_error"
@@ -195,9 +203,16 @@ const AMF0_COMMAND_RELEASE_STREAM = "releaseStream"
 const AMF0_COMMAND_FC_PUBLISH = "FCPublish"
 const AMF0_COMMAND_UNPUBLISH = "FCUnpublish"
 const AMF0_COMMAND_PUBLISH = "publish"
+const AMF0_COMMAND_GET_STREAM_LENGTH = "getStreamLength"
+const AMF0_COMMAND_SEEK = "seek"
+const AMF0_COMMAND_FC_SUBSCRIBE = "FCSubscribe"
+const AMF0_COMMAND_FC_UNSUBSCRIBE = "FCUnsubscribe"
 const AMF0_DATA_SAMPLE_ACCESS = "|RtmpSampleAccess"
 const AMF0_DATA_SET_DATAFRAME = "@setDataFrame"
 const AMF0_DATA_ON_METADATA = "onMetaData"
+const AMF0_DATA_ON_CUE_POINT = "onCuePoint"
+const AMF0_DATA_ON_TEXT_DATA = "onTextData"
+const AMF0_DATA_ON_FI = "onFI"
 
 /**
 * band width check method name, which will be invoked by client.
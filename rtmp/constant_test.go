@@ -0,0 +1,89 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"testing"
+)
+
+func TestMessageTypeConstants(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		v byte
+		expect byte
+	}{
+		{"SetChunkSize", RTMP_MSG_SetChunkSize, 1},
+		{"AbortMessage", RTMP_MSG_AbortMessage, 2},
+		{"Acknowledgement", RTMP_MSG_Acknowledgement, 3},
+		{"UserControlMessage", RTMP_MSG_UserControlMessage, 4},
+		{"WindowAcknowledgementSize", RTMP_MSG_WindowAcknowledgementSize, 5},
+		{"SetPeerBandwidth", RTMP_MSG_SetPeerBandwidth, 6},
+		{"AudioMessage", RTMP_MSG_AudioMessage, 8},
+		{"VideoMessage", RTMP_MSG_VideoMessage, 9},
+		{"AMF3DataMessage", RTMP_MSG_AMF3DataMessage, 15},
+		{"AMF3SharedObject", RTMP_MSG_AMF3SharedObject, 16},
+		{"AMF3CommandMessage", RTMP_MSG_AMF3CommandMessage, 17},
+		{"AMF0DataMessage", RTMP_MSG_AMF0DataMessage, 18},
+		{"AMF0SharedObject", RTMP_MSG_AMF0SharedObject, 19},
+		{"AMF0CommandMessage", RTMP_MSG_AMF0CommandMessage, 20},
+		{"AggregateMessage", RTMP_MSG_AggregateMessage, 22},
+	} {
+		if c.v != c.expect {
+			t.Errorf("%v=%v, expect %v", c.name, c.v, c.expect)
+		}
+	}
+}
+
+func TestCommandNameConstants(t *testing.T) {
+	for _, c := range []struct {
+		v string
+		expect string
+	}{
+		{AMF0_COMMAND_CONNECT, "connect"},
+		{AMF0_COMMAND_CREATE_STREAM, "createStream"},
+		{AMF0_COMMAND_CLOSE_STREAM, "closeStream"},
+		{AMF0_COMMAND_DELETE_STREAM, "deleteStream"},
+		{AMF0_COMMAND_PLAY, "play"},
+		{AMF0_COMMAND_PAUSE, "pause"},
+		{AMF0_COMMAND_PUBLISH, "publish"},
+		{AMF0_COMMAND_RELEASE_STREAM, "releaseStream"},
+		{AMF0_COMMAND_FC_PUBLISH, "FCPublish"},
+		{AMF0_COMMAND_UNPUBLISH, "FCUnpublish"},
+		{AMF0_COMMAND_ON_STATUS, "onStatus"},
+		{AMF0_COMMAND_RESULT, "_result"},
+		{AMF0_COMMAND_ERROR, "_error"},
+		{AMF0_COMMAND_ON_BW_DONE, "onBWDone"},
+		{AMF0_COMMAND_RECEIVE_AUDIO, "receiveAudio"},
+		{AMF0_COMMAND_RECEIVE_VIDEO, "receiveVideo"},
+		{AMF0_COMMAND_GET_STREAM_LENGTH, "getStreamLength"},
+		{AMF0_COMMAND_SEEK, "seek"},
+		{AMF0_COMMAND_FC_SUBSCRIBE, "FCSubscribe"},
+		{AMF0_COMMAND_FC_UNSUBSCRIBE, "FCUnsubscribe"},
+		{AMF0_DATA_SET_DATAFRAME, "@setDataFrame"},
+		{AMF0_DATA_ON_METADATA, "onMetaData"},
+		{AMF0_DATA_SAMPLE_ACCESS, "|RtmpSampleAccess"},
+	} {
+		if c.v != c.expect {
+			t.Errorf("%q, expect %q", c.v, c.expect)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"sync"
+	"time"
+)
+
+/**
+* send the StreamDry to player when the publisher is idle,
+* for example, the source stalls but not unpublish.
+* the publisher goroutine call OnMessage for each message,
+* when no message for the idle duration, send StreamDry once,
+* the next message reset it, so the StreamDry is sent again
+* when the publisher idle again.
+*/
+type StreamDryNotifier struct {
+	lock *sync.Mutex
+	server Server
+	stream_id uint32
+	idle time.Duration
+	timer *time.Timer
+	// whether the StreamDry is sent for current idle.
+	dry bool
+	closed bool
+}
+/**
+* @param server the player to send StreamDry to.
+* @param stream_id the stream id of player.
+* @param idle the duration without message to send StreamDry, for example, 3*time.Second
+*/
+func NewStreamDryNotifier(server Server, stream_id uint32, idle time.Duration) (*StreamDryNotifier) {
+	r := &StreamDryNotifier{}
+	r.lock = &sync.Mutex{}
+	r.server = server
+	r.stream_id = stream_id
+	r.idle = idle
+	r.timer = time.AfterFunc(idle, r.on_idle)
+	return r
+}
+
+// got message of publisher, reset the idle timer.
+func (r *StreamDryNotifier) OnMessage() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	r.dry = false
+	r.timer.Reset(r.idle)
+}
+
+// stop the notifier, never send StreamDry after closed.
+func (r *StreamDryNotifier) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closed = true
+	r.timer.Stop()
+}
+
+func (r *StreamDryNotifier) on_idle() {
+	r.lock.Lock()
+	if r.closed || r.dry {
+		r.lock.Unlock()
+		return
+	}
+	r.dry = true
+	r.lock.Unlock()
+
+	// send without lock, never block the publisher when send blocked.
+	// ignore the error, the player will get it when send next message.
+	r.server.SendStreamDry(r.stream_id)
+}
@@ -0,0 +1,82 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"testing"
+	"time"
+)
+
+// recv the StreamDry of client, return the elapsed time.
+func expect_stream_dry(t *testing.T, client Protocol, stream_id uint32) (elapsed time.Duration) {
+	t.Helper()
+
+	starttime := time.Now()
+	var pkt *UserControlPacket
+	if _, err := client.ExpectPacket(&pkt); err != nil {
+		t.Fatal(err)
+	}
+	if pkt.EventType != PCUCStreamDry || pkt.EventData != stream_id {
+		t.Fatalf("expect StreamDry of stream %v, got event=%v, data=%v", stream_id, pkt.EventType, pkt.EventData)
+	}
+	return time.Since(starttime)
+}
+
+func TestStreamDryNotifier(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	idle := 100 * time.Millisecond
+	notifier := NewStreamDryNotifier(srv, 1, idle)
+	defer notifier.Close()
+
+	// never send StreamDry before the idle threshold.
+	if elapsed := expect_stream_dry(t, client, 1); elapsed < idle / 2 {
+		t.Errorf("StreamDry after %v, expect about %v", elapsed, idle)
+	}
+
+	// the publisher resume and idle again, send StreamDry again.
+	notifier.OnMessage()
+	if elapsed := expect_stream_dry(t, client, 1); elapsed < idle / 2 {
+		t.Errorf("StreamDry again after %v, expect about %v", elapsed, idle)
+	}
+}
+
+func TestSendStreamDry(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	go func() {
+		if err := srv.SendStreamDry(5); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	msg, err := client.RecvMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the event type 2 and the stream id.
+	if b := msg.Payload; string(b) != "\x00\x02\x00\x00\x00\x05" {
+		t.Errorf("payload is %x", b)
+	}
+}
@@ -21,7 +21,10 @@
 
 package rtmp
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 const ERROR_SUCCESS = 0
 
@@ -31,6 +34,10 @@ const ERROR_GO_REFLECT_CAN_SET = 102
 const ERROR_GO_AMF0_NIL_PROPERTY = 103
 const ERROR_GO_RTMP_NOT_SUPPORT_MSG = 104
 const ERROR_GO_PROTOCOL_DESTROYED = 105
+const ERROR_GO_QUEUE_OVERFLOW = 106
+const ERROR_GO_CHUNK_STREAMS_OVERFLOW = 107
+const ERROR_GO_EXPECT_EXCEEDED = 108
+const ERROR_GO_BUFFER_OVERFLOW = 109
 
 const ERROR_SOCKET_CREATE = 200
 const ERROR_SOCKET_SETREUSE = 201
@@ -64,6 +71,9 @@ const ERROR_RTMP_VHOST_NOT_FOUND = 314
 const ERROR_RTMP_ACCESS_DENIED = 315
 const ERROR_RTMP_HANDSHAKE = 316
 const ERROR_RTMP_NO_REQUEST = 317
+const ERROR_RTMP_AMF3_DECODE = 318
+const ERROR_RTMP_FLV_DECODE = 319
+const ERROR_RTMP_AMF3_ENCODE = 320
 
 const ERROR_SYSTEM_STREAM_INIT = 400
 const ERROR_SYSTEM_PACKET_INVALID = 401
@@ -147,3 +157,69 @@ type Error struct {
 func (err Error) Error() string {
 	return fmt.Sprintf("rtmp error code=%v: %s", err.code, err.desc)
 }
+
+// the code of error, for example, ERROR_SOCKET_TIMEOUT
+func (err Error) Code() (int) {
+	return err.code
+}
+
+/**
+* the category of errors, use errors.Is to check the category, for example:
+* 		if errors.Is(err, rtmp.ErrTimeout) {
+* 			// the transient error, retry it.
+* 		}
+* the Error of the category unwrap to the category error.
+*/
+var (
+	// the handshake failed, for example, the client not plain text.
+	ErrHandshake = errors.New("rtmp handshake failed")
+	// the amf0, amf3 or message decode failed, the peer send the invalid data.
+	ErrAmf0Decode = errors.New("rtmp amf0 decode failed")
+	// the chunk size is invalid, the peer send the invalid Set Chunk Size.
+	ErrChunkSize = errors.New("rtmp chunk size invalid")
+	// the chunk stream is corrupted, for example, the invalid chunk fmt,
+	// the message size mismatch or too many chunk streams.
+	ErrProtocol = errors.New("rtmp chunk stream corrupted")
+	// the socket read or write timeout.
+	ErrTimeout = errors.New("rtmp timeout")
+	// the socket read or write failed, for example, the peer closed.
+	ErrIO = errors.New("rtmp io failed")
+)
+
+// the category error of code, nil when not in any category.
+func error_category(code int) (error) {
+	switch {
+	case code == ERROR_SOCKET_TIMEOUT:
+		return ErrTimeout
+	case code >= ERROR_SOCKET_CREATE && code <= ERROR_SOCKET_GET_LOCAL_IP:
+		return ErrIO
+	case code == ERROR_RTMP_PLAIN_REQUIRED || code == ERROR_RTMP_TRY_SIMPLE_HS || code == ERROR_RTMP_CH_SCHEMA || code == ERROR_RTMP_HANDSHAKE:
+		return ErrHandshake
+	case code >= ERROR_OpenSslCreateDH && code <= ERROR_OpenSslSha256DigestSize:
+		return ErrHandshake
+	case code == ERROR_RTMP_AMF0_DECODE || code == ERROR_RTMP_AMF0_INVALID || code == ERROR_RTMP_AMF3_DECODE || code == ERROR_RTMP_MESSAGE_DECODE:
+		return ErrAmf0Decode
+	case code == ERROR_RTMP_CHUNK_SIZE:
+		return ErrChunkSize
+	case code == ERROR_RTMP_CHUNK_START || code == ERROR_RTMP_MSG_INVLIAD_SIZE || code == ERROR_RTMP_PACKET_SIZE:
+		return ErrProtocol
+	case code == ERROR_GO_CHUNK_STREAMS_OVERFLOW || code == ERROR_GO_BUFFER_OVERFLOW:
+		return ErrProtocol
+	}
+	// the ERROR_GO_EXPECT_EXCEEDED is not ErrTimeout, for the socket is ok
+	// and it also means too many dropped messages, check the code for it.
+	return nil
+}
+
+// for errors.Is and errors.As to match the category error.
+func (err Error) Unwrap() (error) {
+	return error_category(err.code)
+}
+
+// for errors.Is to match the Error with the same code.
+func (err Error) Is(target error) (bool) {
+	if target, ok := target.(Error); ok {
+		return err.code == target.code
+	}
+	return false
+}
@@ -0,0 +1,532 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+/**
+* the FLV tag type, the same as the RTMP message type.
+* @see: E.4.1 FLV Tag, video_file_format_spec_v10_1.pdf, page 74
+*/
+const (
+	FlvTagAudio = RTMP_MSG_AudioMessage
+	FlvTagVideo = RTMP_MSG_VideoMessage
+	FlvTagScript = RTMP_MSG_AMF0DataMessage
+)
+
+// the size of FLV header, tag header and the previous tag size.
+const (
+	FLV_HEADER_SIZE = 9
+	FLV_TAG_HEADER_SIZE = 11
+	FLV_PREVIOUS_TAG_SIZE = 4
+)
+
+/**
+* write the RTMP messages to FLV file, for example, to record the stream:
+* 		w := NewFlvWriter(f)
+* 		err = w.WriteHeader(true, true)
+* 		// write the metadata and sequence headers first, then others.
+* 		err = w.WriteMessage(msg)
+* the audio, video and AMF0 data messages are written as FLV tags,
+* the @setDataFrame is stripped, others are ignored.
+* the timestamp of tag is the timestamp of message, @see TimeJitter.
+* the long recording can be split to files, @see RotateAt.
+* call Finalize when done to write the keyframes index to the file.
+* @remark not goroutine safe.
+*/
+type FlvWriter struct {
+	w io.Writer
+	// the flags of FLV header, for the header of new file when rotate.
+	has_audio bool
+	has_video bool
+	// the metadata and sequence headers, written to the new file when rotate.
+	cache *StreamCache
+	// split the file when the duration reach it, 0 to never split.
+	rotate_duration time.Duration
+	// create the new file when split, @see RotateAt.
+	rotate_handler func() (w io.Writer, err error)
+	// the number of tags and the timestamp of first and last tag of file.
+	tags int
+	start_timestamp uint32
+	last_timestamp uint32
+	// the bytes written to the file, the position of the next tag.
+	position int64
+	// the first onMetaData of file, where to write the keyframes index.
+	metadata []byte
+	metadata_position int64
+	metadata_timestamp uint32
+	// the keyframes index of file, the time in seconds and the position of tag.
+	keyframe_times []float64
+	keyframe_positions []float64
+	// the cache for FLV header and tag header.
+	header [FLV_HEADER_SIZE + FLV_PREVIOUS_TAG_SIZE]byte
+	tag_header [FLV_TAG_HEADER_SIZE]byte
+	previous_tag_size [FLV_PREVIOUS_TAG_SIZE]byte
+}
+func NewFlvWriter(w io.Writer) (*FlvWriter) {
+	r := &FlvWriter{}
+	r.w = w
+	r.cache = NewStreamCache()
+	return r
+}
+
+/**
+* split the file when the duration of file reach d, for example, hourly:
+* 		w.RotateAt(time.Hour, func() (io.Writer, error) {
+* 			f.Close()
+* 			f, err = os.Create(fmt.Sprintf("%v.flv", time.Now().Unix()))
+* 			return f, err
+* 		})
+* the file is split at the video keyframe when has video, so the new
+* file is playable, @see Rotate.
+* @param d the max duration of file, 0 to never split.
+* @param handler close the current file and create the new file to write,
+* 		it's called after the current file is finalized, @see Finalize.
+*/
+func (r *FlvWriter) RotateAt(d time.Duration, handler func() (w io.Writer, err error)) {
+	r.rotate_duration = d
+	r.rotate_handler = handler
+}
+
+/**
+* finalize the current file and start the new file to write,
+* the FLV header is written, then the latest metadata and sequence headers,
+* with the timestamp of last tag, so the new file can be decoded.
+* @remark user should close the current file after Rotate.
+*/
+func (r *FlvWriter) Rotate(w io.Writer) (err error) {
+	if err = r.Finalize(); err != nil {
+		return
+	}
+	return r.start(w)
+}
+
+// start the new file, the current file is finalized.
+func (r *FlvWriter) start(w io.Writer) (err error) {
+	r.w = w
+	r.tags = 0
+	r.metadata, r.metadata_timestamp = nil, 0
+	r.keyframe_times, r.keyframe_positions = nil, nil
+
+	if err = r.WriteHeader(r.has_audio, r.has_video); err != nil {
+		return
+	}
+
+	video, audio := r.cache.SequenceHeaders()
+	for _, msg := range []*Message{r.cache.Metadata(), video, audio} {
+		if msg == nil {
+			continue
+		}
+		if err = r.WriteTag(msg.Header.MessageType, r.last_timestamp, msg.Payload); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**
+* write the FLV header and the first previous tag size, which is 0.
+* @param has_audio whether the stream contains audio.
+* @param has_video whether the stream contains video.
+*/
+func (r *FlvWriter) WriteHeader(has_audio, has_video bool) (err error) {
+	r.has_audio, r.has_video = has_audio, has_video
+
+	var flags byte
+	if has_audio {
+		flags |= 0x04
+	}
+	if has_video {
+		flags |= 0x01
+	}
+
+	// 'FLV', version 1, flags, header size 9, previous tag size 0.
+	s := NewRtmpStream(r.header[:])
+	s.Write([]byte{'F', 'L', 'V', 0x01}).WriteByte(flags).WriteUInt32(FLV_HEADER_SIZE).WriteUInt32(0)
+
+	_, err = r.w.Write(r.header[:])
+	r.position = int64(len(r.header))
+	return
+}
+
+// write the message as FLV tag, ignore the message which is not audio, video or AMF0 data.
+func (r *FlvWriter) WriteMessage(msg *Message) (err error) {
+	if !msg.Header.IsAudio() && !msg.Header.IsVideo() && !msg.Header.IsAmf0Data() {
+		return
+	}
+
+	// the FLV script tag is the bare onMetaData.
+	msg = StripSetDataFrame(msg)
+	r.cache.Cache(msg)
+
+	if r.should_rotate(msg) {
+		if err = r.Finalize(); err != nil {
+			return
+		}
+
+		var w io.Writer
+		if w, err = r.rotate_handler(); err != nil {
+			return
+		}
+		if err = r.start(w); err != nil {
+			return
+		}
+	}
+
+	return r.WriteTag(msg.Header.MessageType, uint32(msg.Header.Timestamp), msg.Payload)
+}
+
+/**
+* write the FLV tag, the tag header, data and the previous tag size.
+* @param tag_type the type of tag, for example, FlvTagVideo.
+* @param timestamp the timestamp in ms, 32bits with the extended 8bits.
+*/
+func (r *FlvWriter) WriteTag(tag_type byte, timestamp uint32, data []byte) (err error) {
+	if len(data) > 0xFFFFFF {
+		return Error{code:ERROR_RTMP_MESSAGE_ENCODE, desc:"flv tag data exceed 24bits"}
+	}
+
+	// index the keyframe and the first onMetaData, @see Finalize.
+	tag := &Message{Header:&MessageHeader{MessageType:tag_type}, Payload:data}
+	if is_video_keyframe(tag) {
+		r.keyframe_times = append(r.keyframe_times, float64(timestamp) / 1000)
+		r.keyframe_positions = append(r.keyframe_positions, float64(r.position))
+	}
+	if r.metadata == nil && is_metadata(tag) {
+		r.metadata = append([]byte{}, data...)
+		r.metadata_position, r.metadata_timestamp = r.position, timestamp
+	}
+
+	if err = r.write_tag(tag_type, timestamp, data); err != nil {
+		return
+	}
+
+	if r.tags == 0 {
+		r.start_timestamp = timestamp
+	}
+	r.tags++
+	r.last_timestamp = timestamp
+	return
+}
+
+// write the tag header, data and the previous tag size.
+func (r *FlvWriter) write_tag(tag_type byte, timestamp uint32, data []byte) (err error) {
+	// TagType(1B) DataSize(3B) Timestamp(3B) TimestampExtended(1B) StreamID(3B)
+	s := NewRtmpStream(r.tag_header[:])
+	s.WriteByte(tag_type).WriteUInt24(uint32(len(data)))
+	s.WriteUInt24(timestamp & 0xFFFFFF).WriteByte(byte(timestamp >> 24)).WriteUInt24(0)
+
+	NewRtmpStream(r.previous_tag_size[:]).WriteUInt32(uint32(FLV_TAG_HEADER_SIZE + len(data)))
+
+	for _, b := range [][]byte{r.tag_header[:], data, r.previous_tag_size[:]} {
+		if _, err = r.w.Write(b); err != nil {
+			return
+		}
+	}
+	r.position += int64(FLV_TAG_HEADER_SIZE + len(data) + FLV_PREVIOUS_TAG_SIZE)
+	return
+}
+
+/**
+* finalize the current file, write the keyframes index to the onMetaData,
+* the {keyframes:{filepositions:[...], times:[...]}}, for player to seek.
+* the onMetaData is inserted after the FLV header when file has no one.
+* the tags after the onMetaData are moved to make room for the index,
+* so the writer must be io.ReadWriteSeeker, for example, the *os.File,
+* the index is ignored for others, for example, the HTTP stream.
+* @remark Rotate and RotateAt finalize the file before switch to new file.
+*/
+func (r *FlvWriter) Finalize() (err error) {
+	f, ok := r.w.(io.ReadWriteSeeker)
+	if !ok || len(r.keyframe_times) == 0 {
+		return
+	}
+
+	// insert the onMetaData after the FLV header when no metadata.
+	position, size := int64(len(r.header)), int64(0)
+	if r.metadata != nil {
+		position = r.metadata_position
+		size = int64(FLV_TAG_HEADER_SIZE + len(r.metadata) + FLV_PREVIOUS_TAG_SIZE)
+	}
+
+	// the tags after the onMetaData are moved by delta, the size of
+	// index is fixed, so encode it twice to get the delta then the positions.
+	var data []byte
+	if data, err = r.index_metadata(position, 0); err != nil {
+		return
+	}
+	delta := int64(FLV_TAG_HEADER_SIZE + len(data) + FLV_PREVIOUS_TAG_SIZE) - size
+	if data, err = r.index_metadata(position, delta); err != nil {
+		return
+	}
+
+	end := r.position + delta
+	if err = flv_move(f, position + size, r.position, delta); err != nil {
+		return
+	}
+	if _, err = f.Seek(position, io.SeekStart); err != nil {
+		return
+	}
+	if err = r.write_tag(FlvTagScript, r.metadata_timestamp, data); err != nil {
+		return
+	}
+	if r.position, err = f.Seek(end, io.SeekStart); err != nil {
+		return
+	}
+
+	// the file is indexed, finalize again to update the index.
+	for i, v := range r.keyframe_positions {
+		if int64(v) >= position {
+			r.keyframe_positions[i] = v + float64(delta)
+		}
+	}
+	r.metadata, r.metadata_position = data, position
+	return
+}
+
+// the onMetaData with the keyframes index, the tags after position are moved by delta.
+func (r *FlvWriter) index_metadata(position int64, delta int64) (data []byte, err error) {
+	pkt := NewOnMetaDataPacket()
+	if r.metadata != nil {
+		if err = pkt.Decode(NewRtmpStream(r.metadata)); err != nil {
+			return
+		}
+	}
+
+	var times, filepositions []*Amf0Any
+	for i, v := range r.keyframe_positions {
+		if int64(v) >= position {
+			v += float64(delta)
+		}
+		times = append(times, NewAmf0(r.keyframe_times[i]))
+		filepositions = append(filepositions, NewAmf0(v))
+	}
+
+	keyframes := NewAmf0Object()
+	keyframes.Set("filepositions", NewAmf0(filepositions))
+	keyframes.Set("times", NewAmf0(times))
+	pkt.Metadata.Set("keyframes", NewAmf0(keyframes))
+
+	data = make([]byte, pkt.GetSize())
+	err = pkt.Encode(NewRtmpStream(data))
+	return
+}
+
+// move the bytes [start, end) of file by delta, truncate the file when shrink.
+func flv_move(f io.ReadWriteSeeker, start int64, end int64, delta int64) (err error) {
+	if delta == 0 {
+		return
+	}
+
+	// move from the end when grow, never overwrite the bytes to move.
+	b := make([]byte, 64 * 1024)
+	for moved := int64(0); moved < end - start; {
+		n := int64(len(b))
+		if n > end - start - moved {
+			n = end - start - moved
+		}
+
+		from := start + moved
+		if delta > 0 {
+			from = end - moved - n
+		}
+		if _, err = f.Seek(from, io.SeekStart); err != nil {
+			return
+		}
+		if _, err = io.ReadFull(f, b[:n]); err != nil {
+			return
+		}
+		if _, err = f.Seek(from + delta, io.SeekStart); err != nil {
+			return
+		}
+		if _, err = f.Write(b[:n]); err != nil {
+			return
+		}
+		moved += n
+	}
+
+	if t, ok := f.(interface { Truncate(size int64) error }); ok && delta < 0 {
+		return t.Truncate(end + delta)
+	}
+	return
+}
+
+// whether split the file before the message, @see RotateAt.
+func (r *FlvWriter) should_rotate(msg *Message) (bool) {
+	if r.rotate_duration <= 0 || r.rotate_handler == nil || r.tags == 0 {
+		return false
+	}
+
+	// split at the keyframe for video, or any audio frame for pure audio.
+	if r.has_video && !is_video_keyframe(msg) || !r.has_video && !is_droppable(msg) {
+		return false
+	}
+
+	duration := time.Duration(uint32(msg.Header.Timestamp) - r.start_timestamp) * time.Millisecond
+	return duration >= r.rotate_duration
+}
+
+/**
+* read the RTMP messages from FLV file, for example, to publish the file:
+* 		r := NewFlvReader(f)
+* 		has_audio, has_video, err := r.ReadHeader()
+* 		for {
+* 			msg, err := r.ReadMessage()
+* 			if err == io.EOF {
+* 				break
+* 			}
+* 			msg.Header.StreamId = stream_id
+* 			err = protocol.SendMessage(msg, 0)
+* 		}
+* the script tag is the bare onMetaData, which is wrapped as the FMLE
+* form @setDataFrame("onMetaData", {...}) to publish it.
+* the corrupt tag, for example, the unknown tag type or the previous tag
+* size mismatch, is skipped, @see SkippedTags.
+* @remark not goroutine safe.
+*/
+type FlvReader struct {
+	r io.Reader
+	// the cache for FLV header and tag header.
+	header [FLV_HEADER_SIZE + FLV_PREVIOUS_TAG_SIZE]byte
+	tag_header [FLV_TAG_HEADER_SIZE]byte
+	previous_tag_size [FLV_PREVIOUS_TAG_SIZE]byte
+	// the number of corrupt tags skipped.
+	skipped int
+}
+func NewFlvReader(r io.Reader) (*FlvReader) {
+	v := &FlvReader{}
+	v.r = r
+	return v
+}
+
+/**
+* read and validate the FLV header and the first previous tag size.
+* @return the audio and video flags of header.
+*/
+func (r *FlvReader) ReadHeader() (has_audio, has_video bool, err error) {
+	if _, err = io.ReadFull(r.r, r.header[:FLV_HEADER_SIZE]); err != nil {
+		return
+	}
+
+	s := NewRtmpStream(r.header[:FLV_HEADER_SIZE])
+	if signature := s.Read(3); string(signature) != "FLV" {
+		err = Error{code:ERROR_RTMP_FLV_DECODE, desc:fmt.Sprintf("flv signature invalid, signature=%q", signature)}
+		return
+	}
+	if version := s.ReadByte(); version != 0x01 {
+		err = Error{code:ERROR_RTMP_FLV_DECODE, desc:fmt.Sprintf("flv version %v not support", version)}
+		return
+	}
+	flags := s.ReadByte()
+	has_audio, has_video = (flags & 0x04) != 0, (flags & 0x01) != 0
+
+	// skip the extra bytes when header size larger than 9.
+	offset := s.ReadUInt32()
+	if offset < FLV_HEADER_SIZE {
+		err = Error{code:ERROR_RTMP_FLV_DECODE, desc:fmt.Sprintf("flv header size %v invalid", offset)}
+		return
+	}
+	if _, err = io.CopyN(io.Discard, r.r, int64(offset - FLV_HEADER_SIZE)); err != nil {
+		return
+	}
+
+	// the PreviousTagSize0 is always 0.
+	if _, err = io.ReadFull(r.r, r.previous_tag_size[:]); err != nil {
+		return
+	}
+	if v := NewRtmpStream(r.previous_tag_size[:]).ReadUInt32(); v != 0 {
+		err = Error{code:ERROR_RTMP_FLV_DECODE, desc:fmt.Sprintf("flv previous tag size0 %v invalid", v)}
+		return
+	}
+	return
+}
+
+/**
+* read the next audio, video or script tag as message.
+* @return io.EOF when no more tags.
+*/
+func (r *FlvReader) ReadMessage() (msg *Message, err error) {
+	for {
+		if _, err = io.ReadFull(r.r, r.tag_header[:]); err != nil {
+			return
+		}
+
+		// TagType(1B) DataSize(3B) Timestamp(3B) TimestampExtended(1B) StreamID(3B)
+		s := NewRtmpStream(r.tag_header[:])
+		tag_type := s.ReadByte() & 0x1f // ignore the reserved and filter bits.
+		data_size := int(s.ReadUInt24())
+		timestamp := s.ReadUInt24()
+		timestamp |= uint32(s.ReadByte()) << 24
+
+		var data []byte
+		if tag_type == FlvTagAudio || tag_type == FlvTagVideo || tag_type == FlvTagScript {
+			if data_size, err = safe_payload_size(uint32(data_size), RTMP_DEFAULT_MAX_MESSAGE_SIZE); err != nil {
+				return
+			}
+			data = make([]byte, data_size)
+			if _, err = io.ReadFull(r.r, data); err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+		} else if _, err = io.CopyN(io.Discard, r.r, int64(data_size)); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		if _, err = io.ReadFull(r.r, r.previous_tag_size[:]); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		// skip the corrupt tag.
+		if data == nil || NewRtmpStream(r.previous_tag_size[:]).ReadUInt32() != uint32(FLV_TAG_HEADER_SIZE + data_size) {
+			r.skipped++
+			continue
+		}
+
+		msg = NewMessage()
+		msg.Header.MessageType = tag_type
+		msg.Header.PayloadLength = uint32(data_size)
+		msg.Header.Timestamp = uint64(timestamp)
+		msg.Payload = data
+		msg.ReceivedPayloadLength = data_size
+
+		switch tag_type {
+		case FlvTagAudio:
+			msg.PerferCid = RTMP_CID_Audio
+		case FlvTagVideo:
+			msg.PerferCid = RTMP_CID_Video
+		default:
+			msg.PerferCid = RTMP_CID_OverConnection2
+			if is_metadata(msg) {
+				msg = WrapSetDataFrame(msg)
+			}
+		}
+		return
+	}
+}
+
+// the number of corrupt tags skipped.
+func (r *FlvReader) SkippedTags() (int) {
+	return r.skipped
+}
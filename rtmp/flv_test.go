@@ -0,0 +1,384 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// read all messages of FLV file.
+func read_flv_messages(t testing.TB, b []byte) (msgs []*Message) {
+	t.Helper()
+
+	r := NewFlvReader(bytes.NewReader(b))
+	if _, _, err := r.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg, err := r.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if r.SkippedTags() != 0 {
+		t.Errorf("skipped %v corrupt tags", r.SkippedTags())
+	}
+	return
+}
+
+// the metadata, sequence headers, then 15s audio and video with keyframe every 6s.
+func flv_rotate_messages() (msgs []*Message) {
+	// onMetaData({width:1280})
+	const metadata = "\x02\x00\x0aonMetaData\x08\x00\x00\x00\x01\x00\x05width\x00\x40\x94\x00\x00\x00\x00\x00\x00\x00\x00\x09"
+	msgs = []*Message{
+		new_av_message(RTMP_MSG_AMF0DataMessage, 0, []byte(metadata)),
+		new_av_message(RTMP_MSG_VideoMessage, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00}),
+		new_av_message(RTMP_MSG_AudioMessage, 0, []byte{0xaf, 0x00, 0x12, 0x10}),
+	}
+	for ts := uint64(0); ts < 15000; ts += 1000 {
+		if ts % 6000 == 0 {
+			msgs = append(msgs, new_av_message(RTMP_MSG_VideoMessage, ts, []byte{0x17, 0x01, 0x00, 0x00, 0x00}))
+		} else {
+			msgs = append(msgs, new_av_message(RTMP_MSG_VideoMessage, ts, []byte{0x27, 0x01, 0x00, 0x00, 0x00}))
+		}
+		msgs = append(msgs, new_av_message(RTMP_MSG_AudioMessage, ts, []byte{0xaf, 0x01, 0x00}))
+	}
+	return
+}
+
+func TestFlvWriterRotate(t *testing.T) {
+	var segments []*bytes.Buffer
+	segments = append(segments, &bytes.Buffer{})
+
+	w := NewFlvWriter(segments[0])
+	w.RotateAt(10 * time.Second, func() (io.Writer, error) {
+		segments = append(segments, &bytes.Buffer{})
+		return segments[len(segments) - 1], nil
+	})
+	if err := w.WriteHeader(true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keyframe every 6s, so split at 12s.
+	msgs := flv_rotate_messages()
+	for _, msg := range msgs {
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(segments) != 2 {
+		t.Fatalf("got %v segments, want 2", len(segments))
+	}
+
+	// each segment starts with the metadata and sequence headers, then the keyframe.
+	for i, segment := range segments {
+		v := read_flv_messages(t, segment.Bytes())
+		if len(v) < 4 {
+			t.Fatalf("segment #%v got %v messages", i, len(v))
+		}
+		if !is_metadata(StripSetDataFrame(v[0])) {
+			t.Errorf("segment #%v message #0 is not metadata", i)
+		}
+		if !is_video_sequence_header(v[1]) || !is_audio_sequence_header(v[2]) {
+			t.Errorf("segment #%v has no sequence headers", i)
+		}
+		if !is_video_keyframe(v[3]) {
+			t.Errorf("segment #%v starts with %x, want keyframe", i, v[3].Payload)
+		}
+	}
+
+	first, second := read_flv_messages(t, segments[0].Bytes()), read_flv_messages(t, segments[1].Bytes())
+	if v := first[len(first) - 1].Header.Timestamp; v != 11000 {
+		t.Errorf("first segment ends at %v, want 11000", v)
+	}
+	if v := second[3].Header.Timestamp; v != 12000 {
+		t.Errorf("second segment starts at %v, want 12000", v)
+	}
+	// all messages are written once, except the re-sent headers.
+	if n := len(first) + len(second) - 3; n != len(msgs) {
+		t.Errorf("got %v messages, want %v", n, len(msgs))
+	}
+}
+
+// check the keyframes index of FLV file, the positions point to the keyframes.
+func expect_flv_keyframes(t *testing.T, b []byte, times []float64) {
+	t.Helper()
+
+	msgs := read_flv_messages(t, b)
+	pkt := NewOnMetaDataPacket()
+	if err := pkt.Decode(NewRtmpStream(StripSetDataFrame(msgs[0]).Payload)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := pkt.Metadata.GetPropertyNumber("width"); !ok || v != 1280 {
+		t.Errorf("width=%v, the metadata is lost", v)
+	}
+
+	any, _ := pkt.Metadata.Get("keyframes")
+	if any == nil {
+		t.Fatal("no keyframes index")
+	}
+	keyframes, _ := any.Object()
+	any, _ = keyframes.Get("times")
+	ts, _ := any.StrictArray()
+	any, _ = keyframes.Get("filepositions")
+	positions, _ := any.StrictArray()
+	if len(ts) != len(times) || len(positions) != len(times) {
+		t.Fatalf("got %v times, %v positions, want %v", len(ts), len(positions), times)
+	}
+
+	for i, v := range times {
+		if got, _ := ts[i].Number(); got != v {
+			t.Errorf("keyframe #%v time %v, want %v", i, got, v)
+		}
+
+		// the video tag of keyframe, and the timestamp is the time.
+		p, _ := positions[i].Number()
+		tag := b[int(p):]
+		if tag[0] != FlvTagVideo || tag[FLV_TAG_HEADER_SIZE] != 0x17 || tag[FLV_TAG_HEADER_SIZE + 1] != 0x01 {
+			t.Errorf("keyframe #%v at %v is %x", i, p, tag[:FLV_TAG_HEADER_SIZE + 2])
+		}
+		if ts := float64(uint32(tag[4]) << 16 | uint32(tag[5]) << 8 | uint32(tag[6])) / 1000; ts != v {
+			t.Errorf("keyframe #%v at %v timestamp %v, want %v", i, p, ts, v)
+		}
+	}
+}
+
+func TestFlvWriterKeyframesIndex(t *testing.T) {
+	segments := []*mock_file{&mock_file{}}
+	w := NewFlvWriter(segments[0])
+	w.RotateAt(10 * time.Second, func() (io.Writer, error) {
+		segments = append(segments, &mock_file{})
+		return segments[len(segments) - 1], nil
+	})
+	if err := w.WriteHeader(true, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range flv_rotate_messages() {
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the segments are finalized with the keyframes index.
+	if len(segments) != 2 {
+		t.Fatalf("got %v segments, want 2", len(segments))
+	}
+	expect_flv_keyframes(t, segments[0].b, []float64{0, 6})
+	expect_flv_keyframes(t, segments[1].b, []float64{12})
+
+	// finalize again, the index is updated with the new keyframes.
+	for _, ts := range []uint64{15000, 18000} {
+		if err := w.WriteMessage(new_av_message(RTMP_MSG_VideoMessage, ts, []byte{0x17, 0x01, 0x00})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	expect_flv_keyframes(t, segments[1].b, []float64{12, 15, 18})
+	if n := int64(len(segments[1].b)); segments[1].off != n {
+		t.Errorf("write at %v, want the end %v", segments[1].off, n)
+	}
+}
+
+func TestFlvWriterKeyframesIndexNoMetadata(t *testing.T) {
+	// insert the onMetaData for the file without metadata.
+	f := &mock_file{}
+	w := NewFlvWriter(f)
+	if err := w.WriteHeader(false, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []uint32{0, 40, 80} {
+		if err := w.WriteTag(FlvTagVideo, ts, []byte{0x17, 0x01, 0x00}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := read_flv_messages(t, f.b)
+	if len(msgs) != 4 || !is_metadata(StripSetDataFrame(msgs[0])) {
+		t.Fatalf("got %v messages, want metadata then 3 keyframes", len(msgs))
+	}
+	pkt := NewOnMetaDataPacket()
+	if err := pkt.Decode(NewRtmpStream(StripSetDataFrame(msgs[0]).Payload)); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := pkt.Metadata.Get("keyframes")
+	keyframes, _ := v.Object()
+	v, _ = keyframes.Get("filepositions")
+	positions, _ := v.StrictArray()
+	if len(positions) != 3 {
+		t.Fatalf("got %v positions, want 3", len(positions))
+	}
+	for i, position := range positions {
+		p, _ := position.Number()
+		if tag := f.b[int(p):]; tag[0] != FlvTagVideo || tag[6] != byte(i * 40) {
+			t.Errorf("keyframe #%v at %v is %x", i, p, tag[:7])
+		}
+	}
+}
+
+func TestFlvWriterStructure(t *testing.T) {
+	const metadata = "\x02\x00\x0aonMetaData\x08\x00\x00\x00\x00\x00\x00\x09"
+	var b bytes.Buffer
+	w := NewFlvWriter(&b)
+	if err := w.WriteHeader(true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	data := new_av_message(RTMP_MSG_AMF0DataMessage, 0, []byte(metadata))
+	msgs := []*Message{
+		data,
+		new_av_message(RTMP_MSG_VideoMessage, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01}),
+		new_av_message(RTMP_MSG_AudioMessage, 0, []byte{0xaf, 0x00, 0x12, 0x10}),
+		new_av_message(RTMP_MSG_VideoMessage, 40, []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0xaa}),
+		new_av_message(RTMP_MSG_AudioMessage, 46, []byte{0xaf, 0x01, 0xbb}),
+		// the timestamp exceed 24bits, use the extended byte.
+		new_av_message(RTMP_MSG_VideoMessage, 0x01000010, []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xcc}),
+	}
+	for _, msg := range msgs {
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 'FLV', version 1, audio and video flags, header size 9, previous tag size 0.
+	v := b.Bytes()
+	if len(v) < 13 || string(v[:13]) != "FLV\x01\x05\x00\x00\x00\x09\x00\x00\x00\x00" {
+		t.Fatalf("header is %x", v[:13])
+	}
+	v = v[13:]
+
+	for i, msg := range msgs {
+		if len(v) < FLV_TAG_HEADER_SIZE {
+			t.Fatalf("tag %v requires header, left %v bytes", i, len(v))
+		}
+		tag_type := v[0]
+		size := int(v[1]) << 16 | int(v[2]) << 8 | int(v[3])
+		timestamp := uint64(v[7]) << 24 | uint64(v[4]) << 16 | uint64(v[5]) << 8 | uint64(v[6])
+		if tag_type != msg.Header.MessageType || timestamp != msg.Header.Timestamp {
+			t.Errorf("tag %v type=%v timestamp=%v, expect type=%v timestamp=%v", i, tag_type, timestamp, msg.Header.MessageType, msg.Header.Timestamp)
+		}
+		if v[8] != 0 || v[9] != 0 || v[10] != 0 {
+			t.Errorf("tag %v stream id is %x", i, v[8:11])
+		}
+		v = v[FLV_TAG_HEADER_SIZE:]
+
+		if len(v) < size + 4 {
+			t.Fatalf("tag %v requires %v bytes, left %v bytes", i, size + 4, len(v))
+		}
+		if !bytes.Equal(v[:size], msg.Payload) {
+			t.Errorf("tag %v data is %x, expect %x", i, v[:size], msg.Payload)
+		}
+		v = v[size:]
+
+		previous_tag_size := int(v[0]) << 24 | int(v[1]) << 16 | int(v[2]) << 8 | int(v[3])
+		if previous_tag_size != FLV_TAG_HEADER_SIZE + size {
+			t.Errorf("tag %v previous tag size is %v, expect %v", i, previous_tag_size, FLV_TAG_HEADER_SIZE + size)
+		}
+		v = v[4:]
+	}
+	if len(v) != 0 {
+		t.Errorf("left %v bytes", len(v))
+	}
+}
+
+func TestFlvReaderRoundTrip(t *testing.T) {
+	const metadata = "\x02\x00\x0aonMetaData\x08\x00\x00\x00\x00\x00\x00\x09"
+	var src bytes.Buffer
+	w := NewFlvWriter(&src)
+	if err := w.WriteHeader(true, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []struct {
+		tag_type byte
+		timestamp uint32
+		data string
+	}{
+		{FlvTagScript, 0, metadata},
+		{FlvTagVideo, 0, "\x17\x00\x00\x00\x00\x01"},
+		{FlvTagAudio, 0, "\xaf\x00\x12\x10"},
+		{FlvTagVideo, 40, "\x17\x01\x00\x00\x00\xaa"},
+		{FlvTagAudio, 46, "\xaf\x01\xbb"},
+		{FlvTagVideo, 0x01000010, "\x27\x01\x00\x00\x00\xcc"},
+	} {
+		if err := w.WriteTag(tag.tag_type, tag.timestamp, []byte(tag.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// FlvReader -> messages -> FlvWriter, the file is not changed.
+	msgs := read_flv_messages(t, src.Bytes())
+	if len(msgs) != 6 {
+		t.Fatalf("read %v messages, expect 6", len(msgs))
+	}
+	if !bytes.HasPrefix(msgs[0].Payload, []byte("\x02\x00\x0d@setDataFrame")) {
+		t.Errorf("metadata is not @setDataFrame to publish, got %q", msgs[0].Payload)
+	}
+
+	var dst bytes.Buffer
+	w = NewFlvWriter(&dst)
+	if err := w.WriteHeader(true, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(src.Bytes(), dst.Bytes()) {
+		t.Errorf("round trip is\n%x\nexpect\n%x", dst.Bytes(), src.Bytes())
+	}
+
+	// the tag with invalid previous tag size is skipped.
+	b := append([]byte(nil), src.Bytes()...)
+	b[len(b) - 1]++
+	r := NewFlvReader(bytes.NewReader(b))
+	if _, _, err := r.ReadHeader(); err != nil {
+		t.Fatal(err)
+	}
+	var nb_msgs int
+	for {
+		if _, err := r.ReadMessage(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		nb_msgs++
+	}
+	if nb_msgs != 5 || r.SkippedTags() != 1 {
+		t.Errorf("read %v messages, skipped %v, expect 5 and 1", nb_msgs, r.SkippedTags())
+	}
+}
@@ -24,6 +24,7 @@ package rtmp
 import (
 	"io"
 	"math/rand"
+	"time"
 )
 
 func (r *protocol) handshake_read_c0c1() (err error) {
@@ -68,6 +69,10 @@ func (r *protocol) SimpleHandshake2Client() (err error) {
 		return
 	}
 
+	if r.logger != nil {
+		r.logger.Debugf("handshake read c0c1 ok, version=%v", handshake.c0c1[0])
+	}
+
 	// plain text required.
 	if handshake.c0c1[0] != 0x03 {
 		err = Error{code:ERROR_RTMP_PLAIN_REQUIRED, desc:"only support rtmp plain text"}
@@ -91,13 +96,93 @@ func (r *protocol) SimpleHandshake2Client() (err error) {
 		return
 	}
 
+	if r.logger != nil {
+		r.logger.Debugf("handshake send s0s1s2 ok")
+	}
+
 	// read the c2 from connection if not read yet
 	if err = r.handshake_read_c2(); err != nil {
 		return
 	}
 
+	if r.logger != nil {
+		r.logger.Debugf("handshake read c2 ok, simple handshake success")
+	}
+
+	if r.on_handshake_complete != nil {
+		r.on_handshake_complete(r.conn.RemoteAddr(), time.Now())
+	}
+
+	// start messages input/outout goroutines
+	r.start_message_pump_goroutines()
+
+	return
+}
+
+func (r *protocol) SimpleHandshake2Server() (err error) {
+	var handshake *Handshake = r.handshake
+
+	// for simple handshake, fill the c0c1 with random data
+	handshake.c0c1 = make([]byte, 1537)
+	for i, _ := range handshake.c0c1 {
+		handshake.c0c1[i] = byte(rand.Int())
+	}
+	// plain text required.
+	handshake.c0c1[0] = 0x03
+
+	if _, err = r.conn.Write(handshake.c0c1); err != nil {
+		return
+	}
+
+	if r.logger != nil {
+		r.logger.Debugf("handshake send c0c1 ok")
+	}
+
+	// read the s0s1s2 from connection
+	handshake.s0s1s2 = make([]byte, 3073)
+	if _, err = io.ReadFull(r.conn, handshake.s0s1s2); err != nil {
+		return
+	}
+
+	// plain text required.
+	if handshake.s0s1s2[0] != 0x03 {
+		err = Error{code:ERROR_RTMP_PLAIN_REQUIRED, desc:"only support rtmp plain text"}
+		return
+	}
+
+	if r.logger != nil {
+		r.logger.Debugf("handshake read s0s1s2 ok, version=%v", handshake.s0s1s2[0])
+	}
+
+	// for simple handshake, the c2 is the copy of s1
+	handshake.s1 = handshake.s0s1s2[1:1537]
+	handshake.c2 = handshake.s1
+	if _, err = r.conn.Write(handshake.c2); err != nil {
+		return
+	}
+
+	if r.logger != nil {
+		r.logger.Debugf("handshake send c2 ok, simple handshake success")
+	}
+
+	if r.on_handshake_complete != nil {
+		r.on_handshake_complete(r.conn.RemoteAddr(), time.Now())
+	}
+
 	// start messages input/outout goroutines
 	r.start_message_pump_goroutines()
 
 	return
 }
+
+func (r *protocol) HandshakeS1() (s1 []byte) {
+	var handshake *Handshake = r.handshake
+
+	if handshake.s1 == nil {
+		return nil
+	}
+
+	s1 = make([]byte, len(handshake.s1))
+	copy(s1, handshake.s1)
+	return
+}
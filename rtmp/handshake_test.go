@@ -0,0 +1,108 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOnHandshakeComplete(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	srv_addr := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		s, _ := NewServer(conn)
+		defer s.Protocol().Close()
+		s.Protocol().SetOnHandshakeComplete(func(remote_addr string, t time.Time) {
+			srv_addr <- remote_addr
+		})
+		if err := s.Handshake(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewClient(conn)
+	defer c.Protocol().Close()
+
+	var cli_addr string
+	var cli_time time.Time
+	c.Protocol().SetOnHandshakeComplete(func(remote_addr string, t time.Time) {
+		cli_addr, cli_time = remote_addr, t
+	})
+	start := time.Now()
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cli_addr != ln.Addr().String() {
+		t.Errorf("client side remote addr %v, want %v", cli_addr, ln.Addr())
+	}
+	if cli_time.Before(start) {
+		t.Errorf("client side time %v before %v", cli_time, start)
+	}
+
+	select {
+	case v := <-srv_addr:
+		if v != conn.LocalAddr().String() {
+			t.Errorf("server side remote addr %v, want %v", v, conn.LocalAddr())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("server side callback not fired")
+	}
+}
+
+func TestHandshakeS1(t *testing.T) {
+	client, server := loopback(t)
+
+	s1 := client.HandshakeS1()
+	want := server.(*protocol).handshake.s0s1s2[1:1537]
+	if !bytes.Equal(s1, want) {
+		t.Fatalf("s1 %x..., want %x...", s1[:8], want[:8])
+	}
+
+	// the returned s1 is a copy.
+	s1[0] ^= 0xff
+	if !bytes.Equal(client.HandshakeS1(), want) {
+		t.Error("s1 of handshake changed by user")
+	}
+
+	// the server never receive s1.
+	if v := server.HandshakeS1(); v != nil {
+		t.Errorf("server s1 %x, want nil", v[:8])
+	}
+}
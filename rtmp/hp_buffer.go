@@ -76,6 +76,14 @@ func (r *BytesList) grow_to(n int) {
 		return
 	}
 
+	// compact to reuse the removed bytes at the start, when no space at the end,
+	// so the buffer never grows when the peer send bytes slowly.
+	if r.start > 0 && n > len(r.buf) - r.start {
+		copy(r.buf, r.buf[r.start:r.end])
+		r.end -= r.start
+		r.start = 0
+	}
+
 	// grow the capacity
 	capacity_grow := n - (len(r.buf) - r.start)
 	if capacity_grow > 0 {
@@ -118,6 +126,10 @@ func (r *HPBuffer) WrittenBytes() ([]byte) {
 	b := r.buffer.Bytes()
 	return b[0:r.off]
 }
+// the bytes in buffer, include the bytes read or written.
+func (r *HPBuffer) cached_bytes() (int) {
+	return r.buffer.Len()
+}
 func (r *HPBuffer) Append(b []byte) (n int, err error) {
 	r.buffer.Append(b)
 
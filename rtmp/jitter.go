@@ -0,0 +1,91 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+/**
+* the default max jitter of timestamp, in ms,
+* the delta exceed it is considered as the jitter.
+*/
+const RTMP_MAX_JITTER_MS = 500
+// the default delta of message when jitter, in ms.
+const RTMP_DEFAULT_FRAME_TIME_MS = 10
+
+/**
+* correct the timestamp of audio/video messages, for the publisher
+* produce the backwards or large jumps of timestamp, to keep the
+* timestamp monotonic and smooth for the picky players:
+* 		when delta is negative or exceed the threshold, use the default delta,
+* 		otherwise keep the delta of publisher.
+* the first message is corrected to 0, so the output start from 0.
+* @remark not goroutine safe, use one jitter for each stream.
+* @remark correct the message before cached or shared, for it changes the header.
+*/
+// @see: SrsRtmpJitter
+type TimeJitter struct {
+	// the max delta in ms, @see RTMP_MAX_JITTER_MS
+	threshold uint64
+	// the timestamp of last message, before corrected.
+	last_pkt_time uint64
+	// the timestamp of last message, after corrected.
+	last_pkt_correct_time uint64
+	started bool
+}
+/**
+* @param threshold the max delta in ms, the delta exceed it is the jitter,
+* 		for example, 3000 for the stream with huge gop, 0 to use RTMP_MAX_JITTER_MS.
+*/
+func NewTimeJitter(threshold uint64) (*TimeJitter) {
+	r := &TimeJitter{}
+	r.threshold = threshold
+	if r.threshold <= 0 {
+		r.threshold = RTMP_MAX_JITTER_MS
+	}
+	return r
+}
+
+// correct the timestamp of audio/video message, ignore others.
+func (r *TimeJitter) Correct(msg *Message) {
+	if !msg.Header.IsAudio() && !msg.Header.IsVideo() {
+		return
+	}
+
+	timestamp := msg.Header.Timestamp
+
+	// the delta of publisher, the uint64 overflow when jump back.
+	delta := timestamp - r.last_pkt_time
+	if !r.started {
+		delta = 0
+	} else if timestamp < r.last_pkt_time || delta > r.threshold {
+		delta = RTMP_DEFAULT_FRAME_TIME_MS
+	}
+
+	r.started = true
+	r.last_pkt_correct_time += delta
+	r.last_pkt_time = timestamp
+
+	msg.Header.Timestamp = r.last_pkt_correct_time
+}
+
+// the timestamp of last message, after corrected.
+func (r *TimeJitter) LastTimestamp() (uint64) {
+	return r.last_pkt_correct_time
+}
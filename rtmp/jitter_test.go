@@ -0,0 +1,75 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"testing"
+)
+
+// correct the timestamps of video messages, return the corrected timestamps.
+func correct_timestamps(jitter *TimeJitter, timestamps ...uint64) (v []uint64) {
+	for _, ts := range timestamps {
+		msg := new_av_message(RTMP_MSG_VideoMessage, ts, []byte{0x27, 0x01})
+		jitter.Correct(msg)
+		v = append(v, msg.Header.Timestamp)
+	}
+	return
+}
+
+func TestTimeJitterBackwards(t *testing.T) {
+	// the publisher jump back from 1080 to 40, then continue from 40.
+	v := correct_timestamps(NewTimeJitter(0), 1000, 1040, 1080, 40, 80, 120)
+	expect := []uint64{0, 40, 80, 80 + RTMP_DEFAULT_FRAME_TIME_MS, 130, 170}
+	if !equal_timestamps(v, expect) {
+		t.Errorf("corrected %v, expect %v", v, expect)
+	}
+}
+
+func TestTimeJitterGap(t *testing.T) {
+	// the publisher stalls for 5s, the gap exceed the threshold.
+	v := correct_timestamps(NewTimeJitter(0), 0, 40, 5040, 5080)
+	expect := []uint64{0, 40, 40 + RTMP_DEFAULT_FRAME_TIME_MS, 90}
+	if !equal_timestamps(v, expect) {
+		t.Errorf("corrected %v, expect %v", v, expect)
+	}
+
+	// the large threshold keeps the gap under it.
+	v = correct_timestamps(NewTimeJitter(10000), 0, 40, 5040, 5080)
+	expect = []uint64{0, 40, 5040, 5080}
+	if !equal_timestamps(v, expect) {
+		t.Errorf("corrected %v, expect %v", v, expect)
+	}
+}
+
+func TestTimeJitterIgnoreData(t *testing.T) {
+	jitter := NewTimeJitter(0)
+	correct_timestamps(jitter, 1000, 1040)
+
+	msg := new_av_message(RTMP_MSG_AMF0DataMessage, 9000, nil)
+	jitter.Correct(msg)
+	if msg.Header.Timestamp != 9000 {
+		t.Errorf("data corrected to %v", msg.Header.Timestamp)
+	}
+	if v := correct_timestamps(jitter, 1080); v[0] != 80 || jitter.LastTimestamp() != 80 {
+		t.Errorf("corrected %v, last %v, expect 80", v, jitter.LastTimestamp())
+	}
+}
@@ -0,0 +1,86 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"net"
+	"testing"
+)
+
+/**
+* create two connected protocols over net.Pipe, the handshake is done,
+* for test to send and recv packets without socket, for example:
+* 		client, server := loopback(t)
+* 		client.SendPacket(NewConnectAppPacket(), 0)
+* 		var pkt *ConnectAppPacket
+* 		server.ExpectPacket(&pkt)
+* both protocols are closed when the test finished.
+*/
+func loopback(t testing.TB) (client Protocol, server Protocol) {
+	c, s := net.Pipe()
+	client, _ = NewProtocol(c)
+	server, _ = NewProtocol(s)
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+		client.Close()
+		server.Close()
+	})
+
+	// the handshake must be done in both side at the same time.
+	client_err := make(chan error, 1)
+	go func() {
+		client_err <- client.SimpleHandshake2Server()
+	}()
+
+	// close the pipe to abort the client when server failed.
+	if err := server.SimpleHandshake2Client(); err != nil {
+		c.Close()
+		t.Fatal(err)
+	}
+	if err := <-client_err; err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestLoopbackConnect(t *testing.T) {
+	client, server := loopback(t)
+
+	pkt := NewConnectAppPacket()
+	pkt.CommandObject.Set("app", NewAmf0("live"))
+	pkt.CommandObject.Set("tcUrl", NewAmf0("rtmp://127.0.0.1/live"))
+	if err := client.SendPacket(pkt, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var connect *ConnectAppPacket
+	if _, err := server.ExpectPacket(&connect); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := connect.CommandObject.GetPropertyString("app"); !ok || v != "live" {
+		t.Errorf("app=%v, expect live", v)
+	}
+	if v, ok := connect.CommandObject.GetPropertyString("tcUrl"); !ok || v != "rtmp://127.0.0.1/live" {
+		t.Errorf("tcUrl=%v", v)
+	}
+}
@@ -22,6 +22,7 @@
 package rtmp
 
 import (
+	"context"
 	"net"
 	"math/rand"
 	"time"
@@ -63,6 +64,31 @@ type Message struct {
 	* the payload sent length.
 	 */
 	SentPayloadLength int
+	/**
+	* the protocol which received the message, to decode the packet.
+	* the decoded packet and error is cached, decode only once.
+	 */
+	protocol *protocol
+	decoded bool
+	packet interface {}
+	packet_err error
+	/**
+	* whether the payload is alloc from the payload pool,
+	* and whether to release the message after it's sent.
+	 */
+	pooled bool
+	release_on_sent bool
+	/**
+	* the fmt of first chunk and the chunk size when received,
+	* to forward the message verbatim, @see SendVerbatimMessage.
+	 */
+	recv_fmt byte
+	recv_chunk_size uint32
+	verbatim bool
+	/**
+	* whether the payload is streamed to the ChunkHandler, never buffered.
+	*/
+	streamed bool
 }
 func NewMessage() (*Message) {
 	r := &Message{}
@@ -70,6 +96,70 @@ func NewMessage() (*Message) {
 	return r
 }
 
+/**
+* decode the payload to packet on demand, the packet is cached,
+* so the payload is decoded only once, for the proxy to forward
+* the message and only inspect some of them.
+* @remark the message received from protocol can be decoded,
+* 		others return nil packet.
+* @remark not goroutine safe, the message should be used in one goroutine.
+ */
+func (r *Message) Packet() (pkt interface {}, err error) {
+	if r.protocol == nil || r.Payload == nil {
+		return
+	}
+
+	if !r.decoded {
+		r.packet, r.packet_err = DecodePacket(r.protocol, r.Header, r.Payload)
+		r.decoded = true
+
+		// the sub-messages of aggregate can be decoded by the protocol.
+		if pkt, ok := r.packet.(*AggregatePacket); ok {
+			for _, msg := range pkt.Messages {
+				msg.protocol = r.protocol
+			}
+		}
+	}
+
+	return r.packet, r.packet_err
+}
+
+/**
+* release the payload of message to the pool, to reduce the allocations,
+* the message received by RecvMessage and encoded by EncodeMessage can be
+* released after dispatch, for example, forwarded to all consumers.
+* @remark the message, the decoded packet, and the copies of message
+* 		must not be used after release, for they share the payload.
+* @remark it's ok to never release, the payload is collected by gc.
+ */
+func (r *Message) Release() {
+	if r.pooled && r.Payload != nil {
+		free_payload(r.Payload)
+	}
+	r.Payload = nil
+	r.pooled = false
+	r.decoded = false
+	r.packet = nil
+	r.packet_err = nil
+}
+
+// the type of message, @see MessageHeader.
+func (r *Message) IsAudio() (bool) {
+	return r.Header.IsAudio()
+}
+func (r *Message) IsVideo() (bool) {
+	return r.Header.IsVideo()
+}
+func (r *Message) IsData() (bool) {
+	return r.Header.IsData()
+}
+func (r *Message) IsCommand() (bool) {
+	return r.Header.IsCommand()
+}
+func (r *Message) IsProtocolControl() (bool) {
+	return r.Header.IsProtocolControl()
+}
+
 // copy the message, deep copy header and field, share copy the payload
 func (r *Message) Copy() (*Message) {
 	copy := &Message{}
@@ -79,6 +169,9 @@ func (r *Message) Copy() (*Message) {
 	copy.ReceivedPayloadLength = r.ReceivedPayloadLength
 	copy.PerferCid = r.PerferCid
 	copy.SentPayloadLength = r.SentPayloadLength
+	copy.protocol = r.protocol
+	copy.recv_fmt = r.recv_fmt
+	copy.recv_chunk_size = r.recv_chunk_size
 	return copy
 }
 
@@ -160,12 +253,43 @@ type MessageHeader struct {
 	Timestamp uint64
 }
 
+/**
+* the handler to receive the payload of message chunk by chunk as it arrives,
+* for the relay to forward the large message, for example, the keyframe,
+* without waiting for and buffering the whole message.
+* the handler is called in the recv goroutine, never block in it.
+* @see Protocol.SetChunkHandler
+*/
+type ChunkHandler interface {
+	/**
+	* whether to stream the message, called when the first chunk arrives,
+	* the streamed message is never buffered and never got by RecvMessage.
+	* @remark the protocol control messages are never streamed.
+	 */
+	OnMessageStart(header *MessageHeader) (stream bool)
+	/**
+	* got the payload of chunk of the streamed message.
+	* @param offset the offset of chunk payload in the message payload.
+	* @param payload the chunk payload, which is only valid in the callback,
+	* 		the handler must copy it to use after return.
+	* @return the error to stop the recv, which is returned by RecvMessage.
+	 */
+	OnChunk(header *MessageHeader, offset int, payload []byte) (err error)
+}
+
 type Protocol interface {
 	/**
 	* destroy the protocol stack, close channels, stop goroutines.
 	 */
 	Destroy()
 	/**
+	* close the protocol gracefully, flush the pending messages,
+	* then close the connection, return the error of close connection.
+	* it's idempotent and goroutine safe, the second Close is ignored,
+	* for example, the server evict the client in other goroutine.
+	 */
+	Close() (err error)
+	/**
 	* get the message input channel,
 	* the input goroutine decode and put message into the input channel,
 	* where user can select the channel to recv message.
@@ -178,11 +302,28 @@ type Protocol interface {
 	 */
 	SimpleHandshake2Client() (err error)
 	/**
+	* do simple handshake with server, for the client.
+	* when handshake success, start the message input/outout goroutines
+	 */
+	SimpleHandshake2Server() (err error)
+	/**
+	* get the copy of s1 received from server, for the client to inspect the
+	* handshake payload of server, for example, to fingerprint the CDN.
+	* @return nil if not handshake with server.
+	 */
+	HandshakeS1() (s1 []byte)
+	/**
 	* recv message from connection.
 	* the payload of message is []byte, user can decode it by DecodeMessage.
 	 */
 	RecvMessage() (msg *Message, err error)
 	/**
+	* recv message, return ctx.Err() when ctx is done,
+	* for example, to drain the connections for graceful shutdown.
+	* @remark the blocked read is aborted when ctx is done, the protocol is broken.
+	 */
+	RecvMessageContext(ctx context.Context) (msg *Message, err error)
+	/**
 	* decode the received message to pkt.
 	 */
 	DecodeMessage(msg *Message) (pkt interface {}, err error)
@@ -197,55 +338,246 @@ type Protocol interface {
 	 */
 	ExpectPacket(v interface {}) (msg *Message, err error)
 	/**
+	* expect specified packet by v like ExpectPacket, but give up when
+	* timeout or dropped too many messages, for the misbehaving peer
+	* never send the expected packet.
+	* @param timeout the max duration to wait, <=0 to never timeout.
+	* @param max_drops the max messages to drop, <=0 to drop any messages.
+	* @return the dropped messages count, and the ERROR_GO_EXPECT_EXCEEDED
+	* 		error when timeout or exceed the max drops.
+	* @remark the protocol is ok when give up, user can recv message again.
+	 */
+	ExpectPacketLimit(v interface {}, timeout time.Duration, max_drops int) (msg *Message, dropped int, err error)
+	/**
 	* encode the packet to message, then send out by SendMessage.
-	* return the cid which packet prefer.
+	* return the cid which packet prefer, which is also set to the msg.
 	 */
-	//EncodeMessage(pkt Encoder) (cid int, msg *Message, err error)
+	EncodeMessage(pkt Encoder) (cid int, msg *Message, err error)
 	/**
 	* send message to peer over rtmp connection.
-	* if pkt is Encoder, encode the pkt to Message and send out.
-	* if pkt is Message already, directly send it out.
+	* SendPacket encode the pkt to Message by EncodeMessage, where
+	* the message type and the perfer cid is set by the pkt,
+	* then send out to the stream_id, for example:
+	* 		pkt := NewOnStatusCallPacket()
+	* 		pkt.Set(SLEVEL, SLEVEL_Status).Set(SCODE, SCODE_StreamStart)
+	* 		err = protocol.SendPacket(pkt, stream_id)
+	* SendMessage directly send the Message out.
+	* the send is goroutine safe, multiple goroutines can send at the same time,
+	* the messages are queued then sent in the send goroutine one by one,
+	* so the chunks of messages never interlace, and the Set Chunk Size is
+	* applied after it's sent. but the message must not be sent to multiple
+	* protocols, for the header and sent size of message is changed,
+	* use SendSharedMessage to fan-out the message.
+	* @param stream_id the stream to send to, 0 to use the stream id of message.
 	 */
 	SendPacket(pkt Encoder, stream_id uint32) (err error)
 	SendMessage(pkt *Message, stream_id uint32) (err error)
+	/**
+	* send the shared message, which maybe sent to many protocols at the same time,
+	* for example, the live server encode the video frame once and send to all players.
+	* only the chunk headers are generated for this connection, the payload is
+	* never copied or changed, and the header of shared message is never changed.
+	* @remark the payload must not be changed or released before sent by all protocols.
+	* @param stream_id the stream to send to, 0 to use the stream id of message.
+	 */
+	SendSharedMessage(msg *Message, stream_id uint32) (err error)
+	/**
+	* forward the received message verbatim, for the transparent proxy,
+	* the fmt of first chunk is the same as received, so the bytes is exactly
+	* the same as received when the chunk size of input and output is the same,
+	* and the stream id is not changed, and all messages of the cid are forwarded.
+	* fallback to normal send when chunk size changed, or peer use fmt3
+	* for the first chunk of message, or the fmt is invalid for output.
+	* @remark the message is not changed, it's ok to forward to multiple protocols.
+	* @param stream_id the stream to send to, 0 to use the stream id of message.
+	 */
+	SendVerbatimMessage(msg *Message, stream_id uint32) (err error)
+	/**
+	* send message, return ctx.Err() when ctx is done while the output
+	* queue is full and block the send, @see SetOutQueuePolicy.
+	* @remark the blocked write is aborted when ctx is done, the protocol is broken.
+	 */
+	SendMessageContext(ctx context.Context, pkt *Message, stream_id uint32) (err error)
+	/**
+	* create a amf0 stream writer, to encode the amf0 values
+	* directly to chunks over connection, for very large metadata.
+	* @param cid the chunk stream id, for example, RTMP_CID_OverStream
+	* @param message_type the message type, for example, RTMP_MSG_AMF0DataMessage
+	 */
+	NewAmf0StreamWriter(cid int, message_type byte, stream_id uint32) (*Amf0StreamWriter)
+	/**
+	* send the call packet, alloc and set the transaction id of pkt,
+	* the response can be decoded by DecodeMessage as CallResPacket.
+	 */
+	SendCall(pkt *CallPacket, stream_id uint32) (err error)
+	/**
+	* alloc the next transaction id, which is never used by the requests
+	* sent, for the request which has no SendCall, for example:
+	* 		pkt := NewFCPublishPacket(protocol.NextTransactionId(), stream_name)
+	 */
+	NextTransactionId() (transaction_id float64)
+	/**
+	* get the peer bandwidth and limit type, set by the
+	* Set Peer Bandwidth message received from peer.
+	* @return the bandwidth and type, for example, PeerBandwidthDynamic;
+	* 		zero bandwidth when never received.
+	 */
+	PeerBandwidth() (bandwidth uint32, bw_type byte)
+	/**
+	* set the max messages of output queue and the policy when full,
+	* default to RTMP_MSG_CHANNEL_BUFFER messages and QueuePolicyBlock.
+	* @param max_messages the max messages in queue, <=0 to use current.
+	* @param policy the policy when queue is full, for instance,
+	* 		QueuePolicyDropToKeyframe to skip to the latest gop for slow client,
+	* 		QueuePolicyDisconnect to return error by SendMessage.
+	 */
+	SetOutQueuePolicy(max_messages int, policy int)
+	/**
+	* set the timeout of each read/write of connection, <=0 to never timeout.
+	* when timeout, the protocol is broken by the ERROR_SOCKET_TIMEOUT error,
+	* user can use IsTimeout(err) to identify it from protocol error.
+	 */
+	SetRecvTimeout(timeout time.Duration)
+	SetSendTimeout(timeout time.Duration)
+	/**
+	* set the kernel buffer size of the tcp connection, in bytes,
+	* for the high throughput ingest, for example, 4MB for the 1080p60.
+	* ignored when the connection is not tcp, for example, the net.Pipe.
+	 */
+	SetRecvBuffer(size int) (err error)
+	SetSendBuffer(size int) (err error)
+	/**
+	* whether auto send the acknowledgement when exceed the window, default true.
+	* the transparent proxy should disable it, for the endpoints ack themselves.
+	 */
+	SetAutoAck(v bool)
+	/**
+	* whether validate the connect command object strictly, default false,
+	* when strict, decode the connect failed when missing required property,
+	* for example, the app, @see ConnectAppPacket.Validate.
+	 */
+	SetStrictConnect(v bool)
+	/**
+	* set or get the object encoding negotiated by connect, default CodecAMF0,
+	* for CodecAMF3, the command packets is sent as AMF3 command message,
+	* the message type 17, which is AMF0 command with 1byte 0 prefix.
+	 */
+	SetObjectEncoding(v int)
+	ObjectEncoding() (v int)
+	/**
+	* send the Set Chunk Size to peer, to use larger chunk for the media,
+	* for example, 4096, which reduce the chunk headers and cpu.
+	* the messages sent after it use the new chunk size, and the messages
+	* already queued before it still use the previous chunk size.
+	* @param chunk_size in [RTMP_MIN_CHUNK_SIZE, RTMP_MAX_CHUNK_SIZE].
+	 */
+	SetOutChunkSize(chunk_size uint32) (err error)
+	/**
+	* set the logger to debug the protocol, nil to log nothing.
+	* @remark set it before handshake, it is not goroutine safe.
+	 */
+	SetLogger(logger Logger)
+	/**
+	* set the handler to receive the payload of message chunk by chunk,
+	* nil to buffer the whole message for RecvMessage, which is the default.
+	* @remark set it before handshake, it is not goroutine safe.
+	 */
+	SetChunkHandler(handler ChunkHandler)
+	/**
+	* set the callback when the handshake complete, nil to ignore, which is the default.
+	* user can record the connect time of each remote address to rate-limit,
+	* for example, to reject the buggy client which connect-disconnect rapidly.
+	* it's called in the handshake goroutine, for both the server and client side.
+	* @param remote_addr the address of peer, for example, 192.168.1.10:50410
+	* @param t the time when handshake complete.
+	* @remark set it before handshake, it is not goroutine safe.
+	 */
+	SetOnHandshakeComplete(handler func(remote_addr string, t time.Time))
+	/**
+	* set the max payload size of received message, the peer may advertise
+	* a huge payload length to make us alloc memory for nothing, recv failed
+	* with ERROR_RTMP_MESSAGE_DECODE when exceed it, <=0 to disable.
+	* default to RTMP_DEFAULT_MAX_MESSAGE_SIZE, increase it for huge keyframe.
+	* @remark set it before handshake, it is not goroutine safe.
+	 */
+	SetMaxMessageSize(size int)
+	/**
+	* set the max chunk streams of received messages, the peer may use all cid
+	* to exhaust the memory, recv failed with ERROR_GO_CHUNK_STREAMS_OVERFLOW
+	* when exceed it, <=0 to disable. default to RTMP_DEFAULT_MAX_CHUNK_STREAMS.
+	* @remark set it before handshake, it is not goroutine safe.
+	 */
+	SetMaxChunkStreams(v int)
+	/**
+	* set the initial and max size of the recv buffer, the buffer compacts the
+	* consumed bytes rather than grows, recv failed with ERROR_GO_BUFFER_OVERFLOW
+	* when a chunk requires more than max_size bytes, <=0 to disable the max.
+	* default to RTMP_DEFAULT_RECV_BUFFER_SIZE and RTMP_DEFAULT_MAX_RECV_BUFFER_SIZE,
+	* decrease it to reduce the memory of thousands of connections.
+	* @remark set it before handshake, it is not goroutine safe.
+	 */
+	SetRecvBufferSize(size int, max_size int)
+	/**
+	* get the snapshot of statistic, safe to call in any goroutine.
+	 */
+	Stats() (v Stats)
+	/**
+	* set or get the context of user, nil by default, safe to call in any goroutine,
+	* to attach the state to connection, for example, the auth info or stream handle.
+	 */
+	SetContext(v interface {})
+	Context() (v interface {})
 }
 /**
 * max rtmp header size:
-* 	1bytes basic header,
+* 	3bytes basic header, for cid>=320,
 * 	11bytes message header,
 * 	4bytes timestamp header,
-* that is, 1+11+4=16bytes.
+* that is, 3+11+4=18bytes.
 */
-const RTMP_MAX_FMT0_HEADER_SIZE = 16
+const RTMP_MAX_FMT0_HEADER_SIZE = 18
 /**
 * max rtmp header size:
-* 	1bytes basic header,
+* 	3bytes basic header, for cid>=320,
 * 	4bytes timestamp header,
-* that is, 1+4=5bytes.
+* that is, 3+4=7bytes.
 */
-const RTMP_MAX_FMT3_HEADER_SIZE = 5
+const RTMP_MAX_FMT3_HEADER_SIZE = 7
 // the buffer size of msg channel
 const RTMP_MSG_CHANNEL_BUFFER = 100
 /**
 * create the rtmp protocol.
  */
-func NewProtocol(conn *net.TCPConn) (Protocol, error) {
+func NewProtocol(conn net.Conn) (Protocol, error) {
 	r := &protocol{}
 
 	r.conn = NewSocket(conn)
+	r.requests = map[float64]string{}
+	r.requests_lock = &sync.Mutex{}
+	r.peer_bandwidth_lock = &sync.Mutex{}
+	r.user_context_lock = &sync.Mutex{}
 	r.chunkStreams = map[int]*ChunkStream{}
+	r.outChunkStreams = map[int]*ChunkStream{}
 	r.buffer = NewRtmpBuffer(r.conn)
 	r.handshake = &Handshake{}
 
 	r.inChunkSize = RTMP_DEFAULT_CHUNK_SIZE
 	r.outChunkSize = r.inChunkSize
+	r.max_message_size = RTMP_DEFAULT_MAX_MESSAGE_SIZE
+	r.max_chunk_streams = RTMP_DEFAULT_MAX_CHUNK_STREAMS
+	r.stats = new_protocol_stats()
+	r.stats.on_in_chunk_size(r.inChunkSize)
+	r.stats.on_out_chunk_size(r.outChunkSize)
 	r.outHeaderFmt0 = NewRtmpStream(make([]byte, RTMP_MAX_FMT0_HEADER_SIZE))
 	r.outHeaderFmt3 = NewRtmpStream(make([]byte, RTMP_MAX_FMT3_HEADER_SIZE))
 
 	r.msg_in_lock = &sync.Mutex{}
 	r.msg_out_lock = &sync.Mutex{}
 	r.msg_in_queue = make(chan *Message, RTMP_MSG_CHANNEL_BUFFER)
-	r.msg_out_queue = make(chan *Message, RTMP_MSG_CHANNEL_BUFFER)
+	r.msg_in_queue_once = &sync.Once{}
+	r.msg_io_err_lock = &sync.Mutex{}
+	r.send_done = make(chan bool)
+	r.msg_out_queue = new_message_queue(RTMP_MSG_CHANNEL_BUFFER)
 
 	rand.Seed(time.Now().UnixNano())
 
@@ -297,9 +629,9 @@ func DecodePacket(r *protocol, header *MessageHeader, payload []byte) (packet in
 	var stream *Buffer = NewRtmpStream(payload)
 
 	// decode specified packet type
-	if header.IsAmf0Command() || header.IsAmf3Command() || header.IsAmf0Data() || header.IsAmf3Data() {
-		// skip 1bytes to decode the amf3 command.
-		if header.IsAmf3Command() &&  stream.Requires(1) {
+	if header.IsCommand() || header.IsData() {
+		// skip 1bytes to decode the amf3 command and data.
+		if (header.IsAmf3Command() || header.IsAmf3Data()) &&  stream.Requires(1) {
 			stream = NewRtmpStream(payload[1:])
 		}
 
@@ -322,36 +654,80 @@ func DecodePacket(r *protocol, header *MessageHeader, payload []byte) (packet in
 			// reset to zero to restart decode.
 			stream.Reset()
 
+			// the request maybe not sent by us, for example, the packet sent
+			// by SendMessage directly, decode as the general call response.
 			var request_name string
-			if request_name = r.HistoryRequestName(transaction_id); request_name == "" {
-				err = Error{code:ERROR_RTMP_NO_REQUEST, desc:"decode AMF0/AMF3 transaction request failed"}
-				return
+			if r != nil {
+				request_name = r.on_recv_response(transaction_id)
+				if request_name == "" && r.logger != nil {
+					r.logger.Warnf("no request for %v transaction_id=%v, decode as call response", command, transaction_id)
+				}
 			}
 
-			// TODO: FIXME: implements it
+			// the _result decode by the request, others by the call response.
+			switch {
+			case command == AMF0_COMMAND_ERROR || request_name == "":
+				pkt = NewCallResPacket()
+			case request_name == AMF0_COMMAND_CONNECT:
+				pkt = NewConnectAppResPacket()
+			case request_name == AMF0_COMMAND_CREATE_STREAM:
+				pkt = NewCreateStreamResPacket(0, 0)
+			case request_name == AMF0_COMMAND_RELEASE_STREAM || request_name == AMF0_COMMAND_FC_PUBLISH || request_name == AMF0_COMMAND_UNPUBLISH:
+				pkt = NewFMLEStartResPacket(0)
+			default:
+				pkt = NewCallResPacket()
+			}
 		}
 
 		// reset to zero to restart decode.
 		stream.Reset()
 
-		// decode command object.
-		switch command {
-		case AMF0_COMMAND_CONNECT:
-			pkt = NewConnectAppPacket()
-		case AMF0_COMMAND_CREATE_STREAM:
-			pkt = NewCreateStreamPacket()
-		case AMF0_COMMAND_PLAY:
-			pkt = NewPlayPacket()
-		case AMF0_COMMAND_PUBLISH:
-			pkt = NewPublishPacket()
-		case AMF0_COMMAND_CLOSE_STREAM:
-			pkt = NewCloseStreamPacket()
-		case AMF0_COMMAND_RELEASE_STREAM:
-			pkt = NewFMLEStartPacket()
-		case AMF0_COMMAND_FC_PUBLISH:
-			pkt = NewFMLEStartPacket()
-		case AMF0_COMMAND_UNPUBLISH:
-			pkt = NewFMLEStartPacket()
+		// decode command object, the response is decoded by the request.
+		if pkt == nil {
+			switch command {
+			case AMF0_COMMAND_CONNECT:
+				pkt = NewConnectAppPacket()
+			case AMF0_COMMAND_CREATE_STREAM:
+				pkt = NewCreateStreamPacket()
+			case AMF0_COMMAND_PLAY:
+				pkt = NewPlayPacket()
+			case AMF0_COMMAND_PUBLISH:
+				pkt = NewPublishPacket()
+			case AMF0_COMMAND_PAUSE:
+				pkt = NewPausePacket()
+			case AMF0_COMMAND_RECEIVE_AUDIO, AMF0_COMMAND_RECEIVE_VIDEO:
+				pkt = NewReceiveAVPacket()
+			case AMF0_COMMAND_CLOSE_STREAM:
+				pkt = NewCloseStreamPacket()
+			case AMF0_COMMAND_DELETE_STREAM:
+				pkt = NewDeleteStreamPacket()
+			case AMF0_COMMAND_RELEASE_STREAM:
+				pkt = NewFMLEStartPacket()
+			case AMF0_COMMAND_FC_PUBLISH:
+				pkt = NewFMLEStartPacket()
+			case AMF0_COMMAND_UNPUBLISH:
+				pkt = NewFMLEStartPacket()
+			case AMF0_COMMAND_CHECK_BW:
+				pkt = NewCheckBandwidthPacket()
+			case AMF0_DATA_SET_DATAFRAME, AMF0_DATA_ON_METADATA:
+				pkt = NewOnMetaDataPacket()
+			default:
+				if r != nil && r.logger != nil {
+					r.logger.Debugf("unknown command name=%v, type=%v", command, header.MessageType)
+				}
+				// the unknown command, for example, the custom RPC.
+				if header.IsCommand() {
+					pkt = NewCallPacket()
+				}
+				// the unknown data, for example, the onFI or onCuePoint.
+				if header.IsData() {
+					data := NewDataPacket()
+					data.MessageType = header.MessageType
+					pkt = data
+					// keep the whole payload to forward, include the amf3 leading byte.
+					stream = NewRtmpStream(payload)
+				}
+			}
 		}
 		// TODO: FIXME: implements it
 	} else if header.IsWindowAcknowledgementSize() {
@@ -360,6 +736,18 @@ func DecodePacket(r *protocol, header *MessageHeader, payload []byte) (packet in
 		pkt = NewUserControlPacket()
 	} else if header.IsSetChunkSize() {
 		pkt = NewSetChunkSizePacket()
+	} else if header.IsAcknowledgement() {
+		pkt = NewAcknowledgementPacket()
+	} else if header.IsSetPeerBandwidth() {
+		pkt = NewSetPeerBandwidthPacket()
+	} else if header.IsAudio() {
+		pkt = NewAudioPacket()
+	} else if header.IsVideo() {
+		pkt = NewVideoPacket()
+	} else if header.IsAggregate() {
+		pkt = NewAggregatePacket(header)
+	} else if header.IsAmf0SharedObject() {
+		pkt = NewSharedObjectPacket()
 	}
 	// TODO: FIXME: implements it
 
@@ -367,6 +755,11 @@ func DecodePacket(r *protocol, header *MessageHeader, payload []byte) (packet in
 		packet, err = pkt, pkt.Decode(stream)
 	}
 
+	// validate the connect in strict mode.
+	if pkt, ok := pkt.(*ConnectAppPacket); ok && err == nil && r != nil && r.strict_connect() {
+		err = pkt.Validate()
+	}
+
 	return
 }
 
@@ -383,17 +776,59 @@ type ConnectAppPacket struct {
 }
 func NewConnectAppPacket() (*ConnectAppPacket) {
 	r := &ConnectAppPacket{}
+	r.CommandName = AMF0_COMMAND_CONNECT
 	r.TransactionId = float64(1.0)
 	r.CommandObject = NewAmf0Object()
 	return r
 }
 func (r *ConnectAppPacket) Set(k string, v interface {}) (*ConnectAppPacket) {
-	// if empty or empty object, any value must has content.
-	if a := NewAmf0(v); a != nil && a.Size() > 0 {
+	// ignore the nil value and the empty object or ecma array.
+	if a := NewAmf0(v); a != nil && !a.is_empty() {
 		r.CommandObject.Set(k, a)
 	}
 	return r
 }
+// the string property of command object, empty if absent or not string.
+func (r *ConnectAppPacket) property_string(k string) (v string) {
+	v, _ = r.CommandObject.GetPropertyString(k)
+	return
+}
+// the well-known properties of command object, empty if absent.
+func (r *ConnectAppPacket) App() (string) {
+	return r.property_string("app")
+}
+func (r *ConnectAppPacket) TcUrl() (string) {
+	return r.property_string("tcUrl")
+}
+func (r *ConnectAppPacket) FlashVer() (string) {
+	return r.property_string("flashVer")
+}
+func (r *ConnectAppPacket) SwfUrl() (string) {
+	return r.property_string("swfUrl")
+}
+func (r *ConnectAppPacket) PageUrl() (string) {
+	return r.property_string("pageUrl")
+}
+/**
+* validate the command object, which must contains the required properties,
+* for the strict mode, @see Protocol.SetStrictConnect.
+* @return the ERROR_RTMP_REQ_CONNECT error with the missing property.
+*/
+func (r *ConnectAppPacket) Validate() (err error) {
+	for _, k := range []string{"app"} {
+		if _, ok := r.CommandObject.Get(k); !ok {
+			return Error{code:ERROR_RTMP_REQ_CONNECT, desc:fmt.Sprintf("connect command object missing required property %v", k)}
+		}
+	}
+	return
+}
+// the objectEncoding of client, CodecAMF0 if absent.
+func (r *ConnectAppPacket) ObjectEncoding() (int) {
+	if v, ok := r.CommandObject.GetPropertyNumber("objectEncoding"); ok {
+		return int(v)
+	}
+	return CodecAMF0
+}
 // Decoder
 func (r *ConnectAppPacket) Decode(s *Buffer) (err error) {
 	codec := NewAmf0Codec(s)
@@ -405,17 +840,32 @@ func (r *ConnectAppPacket) Decode(s *Buffer) (err error) {
 		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 decode name failed. expect=%v, actual=%v", AMF0_COMMAND_CONNECT, r.CommandName)}
 	}
 
-	if r.TransactionId, err = codec.ReadNumber(); err != nil {
+	// the transaction id maybe AMF0 number, or AMF3 integer for AMF3 client.
+	var transaction_id *Amf0Any
+	if transaction_id, err = codec.ReadAny(); err != nil {
 		return
 	}
-	if r.TransactionId != 1.0 {
+	if r.TransactionId, _ = transaction_id.Number(); r.TransactionId != 1.0 {
 		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 decode connect transaction_id failed."}
 	}
 
-	if r.CommandObject, err = codec.ReadObject(); err != nil {
+	// some minimal client send empty, null or no command object,
+	// use the empty object, the app and tcUrl is empty.
+	if s.Empty() {
+		r.CommandObject = NewAmf0Object()
+		return
+	}
+
+	// the command object maybe AMF0 object, or AMF3 object for AMF3 client.
+	var command_object *Amf0Any
+	if command_object, err = codec.ReadAny(); err != nil {
+		return
+	}
+	if command_object.IsNil() {
+		r.CommandObject = NewAmf0Object()
 		return
 	}
-	if r.CommandObject == nil {
+	if r.CommandObject, _ = command_object.Object(); r.CommandObject == nil {
 		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 decode connect command_object failed."}
 	}
 
@@ -431,7 +881,7 @@ func (r *ConnectAppPacket) GetMessageType() (v byte) {
 func (r *ConnectAppPacket) GetSize() (v int) {
 	v = Amf0SizeString(r.CommandName)
 	v += Amf0SizeNumber()
-	v += r.CommandObject.Size()
+	v += Amf0SizeObject(r.CommandObject)
 	return
 }
 func (r *ConnectAppPacket) Encode(s *Buffer) (err error) {
@@ -443,10 +893,8 @@ func (r *ConnectAppPacket) Encode(s *Buffer) (err error) {
 	if err = codec.WriteNumber(r.TransactionId); err != nil {
 		return
 	}
-	if r.CommandObject.Size() > 0 {
-		if err = codec.WriteObject(r.CommandObject); err != nil {
-			return
-		}
+	if err = codec.WriteObject(r.CommandObject); err != nil {
+		return
 	}
 	return
 }
@@ -470,19 +918,54 @@ func NewConnectAppResPacket() (*ConnectAppResPacket) {
 	return r
 }
 func (r *ConnectAppResPacket) PropsSet(k string, v interface {}) (*ConnectAppResPacket) {
-	// if empty or empty object, any value must has content.
-	if a := NewAmf0(v); a != nil && a.Size() > 0 {
+	// ignore the nil value and the empty object or ecma array.
+	if a := NewAmf0(v); a != nil && !a.is_empty() {
 		r.Props.Set(k, a)
 	}
 	return r
 }
 func (r *ConnectAppResPacket) InfoSet(k string, v interface {}) (*ConnectAppResPacket) {
-	// if empty or empty object, any value must has content.
-	if a := NewAmf0(v); a != nil && a.Size() > 0 {
+	// ignore the nil value and the empty object or ecma array.
+	if a := NewAmf0(v); a != nil && !a.is_empty() {
 		r.Info.Set(k, a)
 	}
 	return r
 }
+// Decoder
+func (r *ConnectAppResPacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.CommandName, err = codec.ReadString(); err != nil {
+		return
+	}
+	if r.CommandName != AMF0_COMMAND_RESULT {
+		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 decode name failed. expect=%v, actual=%v", AMF0_COMMAND_RESULT, r.CommandName)}
+	}
+	if r.TransactionId, err = codec.ReadNumber(); err != nil {
+		return
+	}
+
+	// the props and info maybe null or object.
+	var v *Amf0Any
+	if !s.Empty() {
+		if v, err = codec.ReadAny(); err != nil {
+			return
+		}
+		if obj, ok := v.Object(); ok {
+			r.Props = obj
+		}
+	}
+	if !s.Empty() {
+		if v, err = codec.ReadAny(); err != nil {
+			return
+		}
+		if obj, ok := v.Object(); ok {
+			r.Info = obj
+		}
+	}
+
+	return
+}
 // Encoder
 func (r *ConnectAppResPacket) GetPerferCid() (v int) {
 	return RTMP_CID_OverConnection
@@ -493,8 +976,8 @@ func (r *ConnectAppResPacket) GetMessageType() (v byte) {
 func (r *ConnectAppResPacket) GetSize() (v int) {
 	v = Amf0SizeString(r.CommandName)
 	v += Amf0SizeNumber()
-	v += r.Props.Size()
-	v += r.Info.Size()
+	v += Amf0SizeObject(r.Props)
+	v += Amf0SizeObject(r.Info)
 	return
 }
 func (r *ConnectAppResPacket) Encode(s *Buffer) (err error) {
@@ -506,15 +989,11 @@ func (r *ConnectAppResPacket) Encode(s *Buffer) (err error) {
 	if err = codec.WriteNumber(r.TransactionId); err != nil {
 		return
 	}
-	if r.Props.Size() > 0 {
-		if err = codec.WriteObject(r.Props); err != nil {
-			return
-		}
+	if err = codec.WriteObject(r.Props); err != nil {
+		return
 	}
-	if r.Info.Size() > 0 {
-		if err = codec.WriteObject(r.Info); err != nil {
-			return
-		}
+	if err = codec.WriteObject(r.Info); err != nil {
+		return
 	}
 	return
 }
@@ -558,6 +1037,45 @@ func (r *SetWindowAckSizePacket) Encode(s *Buffer) (err error) {
 	return
 }
 
+/**
+* 5.3. Acknowledgement (3)
+* The client or the server sends the acknowledgment to the peer after
+* receiving bytes equal to the window size.
+*/
+// @see: SrsAcknowledgementPacket
+type AcknowledgementPacket struct {
+	SequenceNumber uint32
+}
+func NewAcknowledgementPacket() (*AcknowledgementPacket) {
+	return &AcknowledgementPacket{}
+}
+// Decoder
+func (r *AcknowledgementPacket) Decode(s *Buffer) (err error) {
+	if !s.Requires(4) {
+		err = Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:"decode acknowledgement failed."}
+		return
+	}
+	r.SequenceNumber = s.ReadUInt32()
+	return
+}
+// Encoder
+func (r *AcknowledgementPacket) GetPerferCid() (v int) {
+	return RTMP_CID_ProtocolControl
+}
+func (r *AcknowledgementPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_Acknowledgement
+}
+func (r *AcknowledgementPacket) GetSize() (v int) {
+	return 4
+}
+func (r *AcknowledgementPacket) Encode(s *Buffer) (err error) {
+	if !s.Requires(4) {
+		return Error{code:ERROR_RTMP_MESSAGE_ENCODE, desc:"encode acknowledgement packet failed."}
+	}
+	s.WriteUInt32(r.SequenceNumber)
+	return
+}
+
 /**
 * 7.1. Set Chunk Size
 * Protocol control message 1, Set Chunk Size, is used to notify the
@@ -580,6 +1098,12 @@ func (r *SetChunkSizePacket) Decode(s *Buffer) (err error) {
 	}
 	r.ChunkSize = s.ReadUInt32()
 
+	// 5.4.1. Set Chunk Size (1)
+	// The most significant bit of the chunk size MUST be zero.
+	if (r.ChunkSize & 0x80000000) != 0 {
+		return Error{code:ERROR_RTMP_CHUNK_SIZE, desc:fmt.Sprintf("the high bit of chunk size must be 0, chunk size=%#x", r.ChunkSize)}
+	}
+
 	if r.ChunkSize < RTMP_MIN_CHUNK_SIZE {
 		err = Error{code:ERROR_RTMP_CHUNK_SIZE, desc:"atleast min chunk size."}
 	}
@@ -616,6 +1140,19 @@ type SetPeerBandwidthPacket struct {
 	Bandwidth uint32
 	BandwidthType byte
 }
+func NewSetPeerBandwidthPacket() (*SetPeerBandwidthPacket) {
+	return &SetPeerBandwidthPacket{}
+}
+// Decoder
+func (r *SetPeerBandwidthPacket) Decode(s *Buffer) (err error) {
+	if !s.Requires(5) {
+		err = Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:"decode set bandwidth packet failed."}
+		return
+	}
+	r.Bandwidth = s.ReadUInt32()
+	r.BandwidthType = s.ReadByte()
+	return
+}
 // Encoder
 func (r *SetPeerBandwidthPacket) GetPerferCid() (v int) {
 	return RTMP_CID_ProtocolControl
@@ -644,6 +1181,12 @@ type OnBWDonePacket struct {
 	CommandName string
 	TransactionId float64
 	Args *Amf0Any // Null
+	/**
+	* the result of bandwidth check, optional, for example,
+	* the kbitDown, deltaDown(KB), deltaTime(ms) and latency(ms),
+	* where the CommandName is AMF0_COMMAND_ON_BW_CHECK_DONE.
+	*/
+	Arguments []*Amf0Any
 }
 func NewOnBWDonePacket() (*OnBWDonePacket) {
 	r := &OnBWDonePacket{}
@@ -659,9 +1202,80 @@ func (r *OnBWDonePacket) GetMessageType() (v byte) {
 	return RTMP_MSG_AMF0CommandMessage
 }
 func (r *OnBWDonePacket) GetSize() (v int) {
-	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + Amf0SizeNullOrUndefined()
+	v = Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.Args.Size()
+	for _, arg := range r.Arguments {
+		v += arg.Size()
+	}
+	return
 }
 func (r *OnBWDonePacket) Encode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+	if err = codec.WriteString(r.CommandName); err != nil {
+		return
+	}
+	if err = codec.WriteNumber(r.TransactionId); err != nil {
+		return
+	}
+	if err = r.Args.Write(codec); err != nil {
+		return
+	}
+	for _, arg := range r.Arguments {
+		if err = arg.Write(codec); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**
+* the FMS bandwidth check request, the client call _checkbw after connect,
+* the server response by Server.BandwidthCheck.
+*/
+type CheckBandwidthPacket struct {
+	CommandName string
+	TransactionId float64
+	Args *Amf0Any // Null
+}
+func NewCheckBandwidthPacket() (*CheckBandwidthPacket) {
+	r := &CheckBandwidthPacket{}
+	r.CommandName = AMF0_COMMAND_CHECK_BW
+	r.Args = NewAmf0Null()
+	return r
+}
+// Decoder
+func (r *CheckBandwidthPacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.CommandName, err = codec.ReadString(); err != nil {
+		return
+	}
+	if r.CommandName != AMF0_COMMAND_CHECK_BW {
+		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 decode check bandwidth command_name failed."}
+	}
+	if r.TransactionId, err = codec.ReadNumber(); err != nil {
+		return
+	}
+
+	// the args is optional.
+	if s.Empty() {
+		return
+	}
+	if r.Args, err = codec.ReadAny(); err != nil {
+		return
+	}
+	return
+}
+// Encoder
+func (r *CheckBandwidthPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverConnection
+}
+func (r *CheckBandwidthPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AMF0CommandMessage
+}
+func (r *CheckBandwidthPacket) GetSize() (v int) {
+	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.Args.Size()
+}
+func (r *CheckBandwidthPacket) Encode(s *Buffer) (err error) {
 	codec := NewAmf0Codec(s)
 	if err = codec.WriteString(r.CommandName); err != nil {
 		return
@@ -721,7 +1335,7 @@ func (r *CreateStreamPacket) GetMessageType() (v byte) {
 	return RTMP_MSG_AMF0CommandMessage
 }
 func (r *CreateStreamPacket) GetSize() (v int) {
-	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + Amf0SizeNullOrUndefined()
+	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.CommandObject.Size()
 }
 func (r *CreateStreamPacket) Encode(s *Buffer) (err error) {
 	codec := NewAmf0Codec(s)
@@ -784,7 +1398,7 @@ func (r *CreateStreamResPacket) GetMessageType() (v byte) {
 	return RTMP_MSG_AMF0CommandMessage
 }
 func (r *CreateStreamResPacket) GetSize() (v int) {
-	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + Amf0SizeNullOrUndefined() + Amf0SizeNumber()
+	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.CommandObject.Size() + Amf0SizeNumber()
 }
 func (r *CreateStreamResPacket) Encode(s *Buffer) (err error) {
 	codec := NewAmf0Codec(s)
@@ -814,8 +1428,12 @@ type PlayPacket struct {
 	TransactionId float64
 	CommandObject *Amf0Any // Null
 	StreamName string
+	// optional, the start in seconds, default -2, @see PlayMode
 	Start float64
+	// optional, the duration in seconds, default -1 to play util end.
 	Duration float64
+	// optional, whether to flush any previous playlist, default true,
+	// the number is also accepted, where non-zero is true.
 	Reset bool
 }
 func NewPlayPacket() (*PlayPacket) {
@@ -873,6 +1491,28 @@ func (r *PlayPacket) Decode(s *Buffer) (err error) {
 	}
 	return
 }
+/**
+* the play mode, specified by the start of play packet:
+* 		-2, try to play live stream, then recorded stream if not found.
+* 		-1, only play the live stream.
+* 		>=0, play the recorded stream from start in seconds.
+*/
+type PlayMode int
+const (
+	PlayModeLiveOrRecorded PlayMode = iota
+	PlayModeLive
+	PlayModeRecorded
+)
+func (r *PlayPacket) PlayMode() (PlayMode) {
+	if r.Start >= 0 {
+		return PlayModeRecorded
+	}
+	if r.Start == -1 {
+		return PlayModeLive
+	}
+	// the default -2, or any other negative value.
+	return PlayModeLiveOrRecorded
+}
 // Encoder
 func (r *PlayPacket) GetPerferCid() (v int) {
 	return RTMP_CID_OverStream
@@ -881,7 +1521,7 @@ func (r *PlayPacket) GetMessageType() (v byte) {
 	return RTMP_MSG_AMF0CommandMessage
 }
 func (r *PlayPacket) GetSize() (v int) {
-	v = Amf0SizeString(r.CommandName) + Amf0SizeNumber() + Amf0SizeNullOrUndefined() + Amf0SizeString(r.StreamName)
+	v = Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.CommandObject.Size() + Amf0SizeString(r.StreamName)
 	v += Amf0SizeNumber() + Amf0SizeNumber() + Amf0SizeBoolean()
 	return
 }
@@ -912,6 +1552,20 @@ func (r *PlayPacket) Encode(s *Buffer) (err error) {
 	return
 }
 
+/**
+* the type of publish, the StreamType of PublishPacket:
+* 		live, the live stream is published without recording.
+* 		record, the stream is published and recorded to a new file,
+* 			the file is truncated if exists.
+* 		append, the stream is published and appended to the file,
+* 			the file is created if not exists.
+*/
+const (
+	PUBLISH_TYPE_Live = "live"
+	PUBLISH_TYPE_Record = "record"
+	PUBLISH_TYPE_Append = "append"
+)
+
 /**
 * FMLE/flash publish
 * 4.2.6. Publish
@@ -925,14 +1579,14 @@ type PublishPacket struct {
 	TransactionId float64
 	CommandObject *Amf0Any // Null
 	StreamName string
-	// optional, default to live.
+	// optional, default to live, @see PUBLISH_TYPE_Live.
 	StreamType string
 }
 func NewPublishPacket() (*PublishPacket) {
 	r := &PublishPacket{}
 	r.CommandName = AMF0_COMMAND_PUBLISH
 	r.CommandObject = NewAmf0Null()
-	r.StreamType = "live"
+	r.StreamType = PUBLISH_TYPE_Live
 	return r
 }
 // Decoder
@@ -959,6 +1613,14 @@ func (r *PublishPacket) Decode(s *Buffer) (err error) {
 			return
 		}
 	}
+
+	switch r.StreamType {
+	case PUBLISH_TYPE_Live, PUBLISH_TYPE_Record, PUBLISH_TYPE_Append:
+	case "":
+		r.StreamType = PUBLISH_TYPE_Live
+	default:
+		return Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:fmt.Sprintf("publish type %v invalid", r.StreamType)}
+	}
 	return
 }
 // Encoder
@@ -969,7 +1631,7 @@ func (r *PublishPacket) GetMessageType() (v byte) {
 	return RTMP_MSG_AMF0CommandMessage
 }
 func (r *PublishPacket) GetSize() (v int) {
-	v = Amf0SizeString(r.CommandName) + Amf0SizeNumber() + Amf0SizeNullOrUndefined() + Amf0SizeString(r.StreamName)
+	v = Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.CommandObject.Size() + Amf0SizeString(r.StreamName)
 	v += Amf0SizeString(r.StreamType)
 	return
 }
@@ -1109,8 +1771,8 @@ func NewOnStatusCallPacket() (*OnStatusCallPacket) {
 	return r
 }
 func (r *OnStatusCallPacket) Set(k string, v interface {}) (*OnStatusCallPacket) {
-	// if empty or empty object, any value must has content.
-	if a := NewAmf0(v); a != nil && a.Size() > 0 {
+	// ignore the nil value and the empty object or ecma array.
+	if a := NewAmf0(v); a != nil && !a.is_empty() {
 		r.Data.Set(k, a)
 	}
 	return r
@@ -1123,7 +1785,7 @@ func (r *OnStatusCallPacket) GetMessageType() (v byte) {
 	return RTMP_MSG_AMF0CommandMessage
 }
 func (r *OnStatusCallPacket) GetSize() (v int) {
-	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + Amf0SizeNullOrUndefined() + r.Data.Size()
+	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.Args.Size() + r.Data.Size()
 }
 func (r *OnStatusCallPacket) Encode(s *Buffer) (err error) {
 	codec := NewAmf0Codec(s)
@@ -1143,6 +1805,205 @@ func (r *OnStatusCallPacket) Encode(s *Buffer) (err error) {
 	return
 }
 
+// create the onFCPublish(NetStream.Publish.Start), which server send
+// to the FMLE publisher before the onStatus, some encoders wait for it.
+func NewOnFCPublishPacket(stream_name string) (*OnStatusCallPacket) {
+	r := NewOnStatusCallPacket()
+	r.CommandName = AMF0_COMMAND_ON_FC_PUBLISH
+	r.Set(SCODE, SCODE_PublishStart).Set(SDESC, publish_start_desc(stream_name))
+	return r
+}
+
+/**
+* the stream metadata.
+* FMLE: @setDataFrame
+* others: onMetaData
+*/
+// @see: SrsOnMetaDataPacket
+type OnMetaDataPacket struct {
+	Name string
+	Metadata *Amf0EcmaArray
+}
+func NewOnMetaDataPacket() (*OnMetaDataPacket) {
+	r := &OnMetaDataPacket{}
+	r.Name = AMF0_DATA_ON_METADATA
+	r.Metadata = NewAmf0EcmaArray()
+	return r
+}
+// Decoder
+func (r *OnMetaDataPacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.Name, err = codec.ReadString(); err != nil {
+		return
+	}
+
+	// ignore the @setDataFrame
+	if r.Name == AMF0_DATA_SET_DATAFRAME {
+		if r.Name, err = codec.ReadString(); err != nil {
+			return
+		}
+	}
+
+	var any *Amf0Any
+	if any, err = codec.ReadAny(); err != nil {
+		return
+	}
+
+	// if ecma array, directly use it.
+	if v, ok := any.EcmaArray(); ok {
+		r.Metadata = v
+		return
+	}
+
+	// if object, convert to ecma array.
+	if v, ok := any.Object(); ok {
+		for _, k := range v.properties.property_index {
+			if err = r.Metadata.Set(k, v.properties.properties[k]); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	err = Error{code:ERROR_RTMP_AMF0_DECODE, desc:"decode metadata failed, requires object or ecma array"}
+	return
+}
+// Encoder
+func (r *OnMetaDataPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverConnection2
+}
+func (r *OnMetaDataPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AMF0DataMessage
+}
+func (r *OnMetaDataPacket) GetSize() (v int) {
+	return Amf0SizeString(r.Name) + Amf0SizeEcmaArray(r.Metadata)
+}
+func (r *OnMetaDataPacket) Encode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if err = codec.WriteString(r.Name); err != nil {
+		return
+	}
+	if err = codec.WriteEcmaArray(r.Metadata); err != nil {
+		return
+	}
+	return
+}
+
+/**
+* the size of @setDataFrame amf0 string, the wrapper of FMLE metadata.
+*/
+func set_data_frame_size() (int) {
+	return Amf0SizeString(AMF0_DATA_SET_DATAFRAME)
+}
+
+// whether the payload of amf0 data message starts with @setDataFrame
+func is_set_data_frame(msg *Message) (bool) {
+	if msg == nil || !msg.Header.IsAmf0Data() || len(msg.Payload) < set_data_frame_size() {
+		return false
+	}
+
+	codec := NewAmf0Codec(NewRtmpStream(msg.Payload))
+	if v, err := codec.ReadString(); err != nil || v != AMF0_DATA_SET_DATAFRAME {
+		return false
+	}
+	return true
+}
+
+/**
+* normalize the data message for player, strip the @setDataFrame,
+* for example, FMLE publish @setDataFrame("onMetaData", {...}),
+* the player requires the bare onMetaData({...}).
+* @return the msg itself when not wrapped, or a copy which share the payload.
+*/
+func StripSetDataFrame(msg *Message) (*Message) {
+	if !is_set_data_frame(msg) {
+		return msg
+	}
+
+	copy := msg.Copy()
+	copy.Payload = msg.Payload[set_data_frame_size():]
+	copy.Header.PayloadLength = uint32(len(copy.Payload))
+	copy.ReceivedPayloadLength = len(copy.Payload)
+	return copy
+}
+
+/**
+* normalize the data message for ingest, wrap with @setDataFrame,
+* for example, OBS publish the bare onMetaData({...}),
+* the FMLE form @setDataFrame("onMetaData", {...}) is required when
+* publish to the origin server.
+* @return the msg itself when already wrapped or not amf0 data, or a new message.
+*/
+func WrapSetDataFrame(msg *Message) (*Message) {
+	if msg == nil || !msg.Header.IsAmf0Data() || is_set_data_frame(msg) {
+		return msg
+	}
+
+	b := make([]byte, set_data_frame_size() + len(msg.Payload))
+	s := NewRtmpStream(b)
+	if err := NewAmf0Codec(s).WriteString(AMF0_DATA_SET_DATAFRAME); err != nil {
+		return msg
+	}
+	s.Write(msg.Payload)
+
+	copy := msg.Copy()
+	copy.Payload = b
+	copy.Header.PayloadLength = uint32(len(copy.Payload))
+	copy.ReceivedPayloadLength = len(copy.Payload)
+	return copy
+}
+
+/**
+* the general data message, for example, the onFI, onCaption and onCuePoint,
+* the name is decoded for filtering, the payload is kept for passthrough,
+* the relay should forward it byte-for-byte.
+* @remark the Payload references the message payload, never copy.
+*/
+type DataPacket struct {
+	Name string
+	// the message type, AMF0 or AMF3 data.
+	MessageType byte
+	// the whole payload of message, include the name.
+	Payload []byte
+}
+func NewDataPacket() (*DataPacket) {
+	r := &DataPacket{}
+	r.MessageType = RTMP_MSG_AMF0DataMessage
+	return r
+}
+// Decoder
+func (r *DataPacket) Decode(s *Buffer) (err error) {
+	r.Payload = s.Read(s.Left())
+
+	// the amf3 data starts with a byte 0, then the amf0 name.
+	b := r.Payload
+	if r.MessageType == RTMP_MSG_AMF3DataMessage && len(b) > 0 {
+		b = b[1:]
+	}
+
+	codec := NewAmf0Codec(NewRtmpStream(b))
+	if r.Name, err = codec.ReadString(); err != nil {
+		return
+	}
+	return
+}
+// Encoder
+func (r *DataPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverConnection2
+}
+func (r *DataPacket) GetMessageType() (v byte) {
+	return r.MessageType
+}
+func (r *DataPacket) GetSize() (v int) {
+	return len(r.Payload)
+}
+func (r *DataPacket) Encode(s *Buffer) (err error) {
+	s.Write(r.Payload)
+	return
+}
+
 /**
 * AMF0Data RtmpSampleAccess
 * @remark, user must set the stream_id by SrsMessage.set_packet().
@@ -1183,6 +2044,59 @@ func (r *SampleAccessPacket) Encode(s *Buffer) (err error) {
 	return
 }
 
+/**
+* the timecode data of FMLE, onFI, AMF0 Data
+* the encoder sends it with the system date and time of the frame,
+* some players wait for it to display the timecode.
+* @remark, user must set the stream_id by SrsMessage.set_packet().
+*/
+type OnFIPacket struct {
+	CommandName string
+	// the system date, format as dd-mm-yyyy, for example, 15-10-2026
+	SystemDate string
+	// the system time, format as hh:mm:ss.mmm, for example, 08:30:00.125
+	SystemTime string
+}
+func NewOnFIPacket() (*OnFIPacket) {
+	r := &OnFIPacket{}
+	r.CommandName = AMF0_DATA_ON_FI
+	return r
+}
+// set the sd and st timecode by the time t.
+func (r *OnFIPacket) SetTime(t time.Time) (*OnFIPacket) {
+	r.SystemDate = t.Format("02-01-2006")
+	r.SystemTime = t.Format("15:04:05.000")
+	return r
+}
+// the timecode is encoded as ecma array, {sd:SystemDate, st:SystemTime}
+func (r *OnFIPacket) timecode() (*Amf0EcmaArray) {
+	v := NewAmf0EcmaArray()
+	v.Set("sd", NewAmf0(r.SystemDate))
+	v.Set("st", NewAmf0(r.SystemTime))
+	return v
+}
+// Encoder
+func (r *OnFIPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverConnection2
+}
+func (r *OnFIPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AMF0DataMessage
+}
+func (r *OnFIPacket) GetSize() (v int) {
+	return Amf0SizeString(r.CommandName) + Amf0SizeEcmaArray(r.timecode())
+}
+func (r *OnFIPacket) Encode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if err = codec.WriteString(r.CommandName); err != nil {
+		return
+	}
+	if err = r.timecode().Write(codec); err != nil {
+		return
+	}
+	return
+}
+
 /**
 * onStatus data, AMF0 Data
 * @remark, user must set the stream_id by SrsMessage.set_packet().
@@ -1199,8 +2113,8 @@ func NewOnStatusDataPacket() (*OnStatusDataPacket) {
 	return r
 }
 func (r *OnStatusDataPacket) Set(k string, v interface {}) (*OnStatusDataPacket) {
-	// if empty or empty object, any value must has content.
-	if a := NewAmf0(v); a != nil && a.Size() > 0 {
+	// ignore the nil value and the empty object or ecma array.
+	if a := NewAmf0(v); a != nil && !a.is_empty() {
 		r.Data.Set(k, a)
 	}
 	return r
@@ -1227,6 +2141,112 @@ func (r *OnStatusDataPacket) Encode(s *Buffer) (err error) {
 	return
 }
 
+/**
+* 4.2.8. pause
+* The client sends the pause command to tell the server to pause or
+* start playing.
+* @remark some clients send it with transaction id 0 and null object.
+*/
+// @see: SrsPausePacket
+type PausePacket struct {
+	CommandName string
+	TransactionId float64
+	CommandObject *Amf0Any // Null
+	/**
+	* true or false, to indicate pausing or resuming play
+	*/
+	IsPause bool
+	/**
+	* Number of milliseconds at which the the stream is paused or play resumed.
+	* This is the current stream time at the Client when stream was paused. When the
+	* playback is resumed, the server will only send messages with timestamps
+	* greater than this value.
+	*/
+	MilliSeconds float64
+}
+func NewPausePacket() (*PausePacket) {
+	r := &PausePacket{}
+	r.CommandName = AMF0_COMMAND_PAUSE
+	r.CommandObject = NewAmf0Null()
+	return r
+}
+// Decoder
+func (r *PausePacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.CommandName, err = codec.ReadString(); err != nil {
+		return
+	}
+	if r.CommandName != AMF0_COMMAND_PAUSE {
+		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 decode name failed. expect=%v, actual=%v", AMF0_COMMAND_PAUSE, r.CommandName)}
+	}
+
+	if r.TransactionId, err = codec.ReadNumber(); err != nil {
+		return
+	}
+	if err = r.CommandObject.Read(codec); err != nil {
+		return
+	}
+	if r.IsPause, err = codec.ReadBoolean(); err != nil {
+		return
+	}
+	if r.MilliSeconds, err = codec.ReadNumber(); err != nil {
+		return
+	}
+
+	return
+}
+
+/**
+* 4.2.3. receiveAudio
+* 4.2.4. receiveVideo
+* NetStream sends the receiveAudio/receiveVideo message to inform the server
+* whether to send or not to send the audio/video to the client.
+*/
+type ReceiveAVPacket struct {
+	// receiveAudio or receiveVideo
+	CommandName string
+	TransactionId float64
+	CommandObject *Amf0Any // Null
+	/**
+	* true or false to indicate whether to receive audio/video or not.
+	*/
+	BoolFlag bool
+}
+func NewReceiveAVPacket() (*ReceiveAVPacket) {
+	r := &ReceiveAVPacket{}
+	r.CommandName = AMF0_COMMAND_RECEIVE_AUDIO
+	r.CommandObject = NewAmf0Null()
+	return r
+}
+// whether the packet is receiveAudio, or receiveVideo.
+func (r *ReceiveAVPacket) IsAudio() (bool) {
+	return r.CommandName == AMF0_COMMAND_RECEIVE_AUDIO
+}
+// Decoder
+func (r *ReceiveAVPacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.CommandName, err = codec.ReadString(); err != nil {
+		return
+	}
+	if r.CommandName != AMF0_COMMAND_RECEIVE_AUDIO && r.CommandName != AMF0_COMMAND_RECEIVE_VIDEO {
+		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 decode name failed. expect=%v or %v, actual=%v", AMF0_COMMAND_RECEIVE_AUDIO, AMF0_COMMAND_RECEIVE_VIDEO, r.CommandName)}
+	}
+
+	if r.TransactionId, err = codec.ReadNumber(); err != nil {
+		return
+	}
+	if err = r.CommandObject.Read(codec); err != nil {
+		return
+	}
+	if r.BoolFlag, err = codec.ReadBoolean(); err != nil {
+		return
+	}
+
+	return
+}
+
 /**
 * client close stream packet.
 */
@@ -1262,6 +2282,99 @@ func (r *CloseStreamPacket) Decode(s *Buffer) (err error) {
 
 	return
 }
+// Encoder
+func (r *CloseStreamPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverStream
+}
+func (r *CloseStreamPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AMF0CommandMessage
+}
+func (r *CloseStreamPacket) GetSize() (v int) {
+	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + Amf0SizeNullOrUndefined()
+}
+func (r *CloseStreamPacket) Encode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if err = codec.WriteString(r.CommandName); err != nil {
+		return
+	}
+	if err = codec.WriteNumber(r.TransactionId); err != nil {
+		return
+	}
+	if err = codec.WriteNull(); err != nil {
+		return
+	}
+	return
+}
+
+/**
+* client delete stream packet, to release the stream resource.
+* the stream id to delete is the number argument,
+* for the message is sent over the connection(stream id 0) by some clients.
+*/
+// @see: SrsDeleteStreamPacket
+type DeleteStreamPacket struct {
+	CommandName string
+	TransactionId float64
+	CommandObject *Amf0Any // Null
+	StreamId float64
+}
+func NewDeleteStreamPacket() (*DeleteStreamPacket) {
+	r := &DeleteStreamPacket{}
+	r.CommandName = AMF0_COMMAND_DELETE_STREAM
+	r.CommandObject = NewAmf0Null()
+	return r
+}
+// Decoder
+func (r *DeleteStreamPacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.CommandName, err = codec.ReadString(); err != nil {
+		return
+	}
+	if r.CommandName != AMF0_COMMAND_DELETE_STREAM {
+		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 decode name failed. expect=%v, actual=%v", AMF0_COMMAND_DELETE_STREAM, r.CommandName)}
+	}
+
+	if r.TransactionId, err = codec.ReadNumber(); err != nil {
+		return
+	}
+	if err = r.CommandObject.Read(codec); err != nil {
+		return
+	}
+	if r.StreamId, err = codec.ReadNumber(); err != nil {
+		return
+	}
+
+	return
+}
+// Encoder
+func (r *DeleteStreamPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverStream
+}
+func (r *DeleteStreamPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AMF0CommandMessage
+}
+func (r *DeleteStreamPacket) GetSize() (v int) {
+	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + Amf0SizeNullOrUndefined() + Amf0SizeNumber()
+}
+func (r *DeleteStreamPacket) Encode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if err = codec.WriteString(r.CommandName); err != nil {
+		return
+	}
+	if err = codec.WriteNumber(r.TransactionId); err != nil {
+		return
+	}
+	if err = codec.WriteNull(); err != nil {
+		return
+	}
+	if err = codec.WriteNumber(r.StreamId); err != nil {
+		return
+	}
+	return
+}
 
 /**
 * FMLE start publish: ReleaseStream/PublishStream
@@ -1303,6 +2416,62 @@ func (r *FMLEStartPacket) Decode(s *Buffer) (err error) {
 
 	return
 }
+// Encoder
+func (r *FMLEStartPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverConnection
+}
+func (r *FMLEStartPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AMF0CommandMessage
+}
+func (r *FMLEStartPacket) GetSize() (v int) {
+	return Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.CommandObject.Size() + Amf0SizeString(r.StreamName)
+}
+func (r *FMLEStartPacket) Encode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if err = codec.WriteString(r.CommandName); err != nil {
+		return
+	}
+	if err = codec.WriteNumber(r.TransactionId); err != nil {
+		return
+	}
+	if err = r.CommandObject.Write(codec); err != nil {
+		return
+	}
+	if err = codec.WriteString(r.StreamName); err != nil {
+		return
+	}
+	return
+}
+// create the releaseStream packet, which client send before FCPublish.
+// @param transaction_id the id alloc by Protocol.NextTransactionId
+// @see: SrsFMLEStartPacket::create_release_stream
+func NewReleaseStreamPacket(transaction_id float64, stream_name string) (*FMLEStartPacket) {
+	r := NewFMLEStartPacket()
+	r.CommandName = AMF0_COMMAND_RELEASE_STREAM
+	r.TransactionId = transaction_id
+	r.StreamName = stream_name
+	return r
+}
+// create the FCPublish packet, which client send before createStream.
+// @param transaction_id the id alloc by Protocol.NextTransactionId
+// @see: SrsFMLEStartPacket::create_FC_publish
+func NewFCPublishPacket(transaction_id float64, stream_name string) (*FMLEStartPacket) {
+	r := NewFMLEStartPacket()
+	r.CommandName = AMF0_COMMAND_FC_PUBLISH
+	r.TransactionId = transaction_id
+	r.StreamName = stream_name
+	return r
+}
+// create the FCUnpublish packet, which client send when stop publish.
+// @param transaction_id the id alloc by Protocol.NextTransactionId
+func NewFCUnpublishPacket(transaction_id float64, stream_name string) (*FMLEStartPacket) {
+	r := NewFMLEStartPacket()
+	r.CommandName = AMF0_COMMAND_UNPUBLISH
+	r.TransactionId = transaction_id
+	r.StreamName = stream_name
+	return r
+}
 
 /**
 * response for SrsFMLEStartPacket.
@@ -1323,6 +2492,32 @@ func NewFMLEStartResPacket(transaction_id float64) (*FMLEStartResPacket) {
 	r.Args = NewAmf0Undefined()
 	return r
 }
+// Decoder
+func (r *FMLEStartResPacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.CommandName, err = codec.ReadString(); err != nil {
+		return
+	}
+	if r.CommandName != AMF0_COMMAND_RESULT {
+		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 decode name failed. expect=%v, actual=%v", AMF0_COMMAND_RESULT, r.CommandName)}
+	}
+
+	if r.TransactionId, err = codec.ReadNumber(); err != nil {
+		return
+	}
+	if err = r.CommandObject.Read(codec); err != nil {
+		return
+	}
+	// some server donot send the undefined args.
+	if !s.Empty() {
+		if err = r.Args.Read(codec); err != nil {
+			return
+		}
+	}
+
+	return
+}
 // Encoder
 func (r *FMLEStartResPacket) GetPerferCid() (v int) {
 	return RTMP_CID_OverConnection
@@ -1350,3 +2545,295 @@ func (r *FMLEStartResPacket) Encode(s *Buffer) (err error) {
 	}
 	return
 }
+
+/**
+* the call method request or response,
+* for the command which has no dedicated packet, for example, the custom RPC,
+* the command object and arguments can be any amf0 value.
+* for example, FCPublish("streamKey"):
+* 		CommandName="FCPublish", TransactionId=3, CommandObject=null, Arguments=["streamKey"]
+*/
+// @see: SrsCallPacket
+type CallPacket struct {
+	CommandName string
+	TransactionId float64
+	// Null or Object
+	CommandObject *Amf0Any
+	// any amf0 values
+	Arguments []*Amf0Any
+}
+func NewCallPacket() (*CallPacket) {
+	r := &CallPacket{}
+	r.CommandObject = NewAmf0Null()
+	return r
+}
+// Decoder
+func (r *CallPacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.CommandName, err = codec.ReadString(); err != nil {
+		return
+	}
+	if r.CommandName == "" {
+		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:"amf0 decode call command_name failed."}
+	}
+	if r.TransactionId, err = codec.ReadNumber(); err != nil {
+		return
+	}
+
+	if s.Empty() {
+		return
+	}
+	if r.CommandObject, err = codec.ReadAny(); err != nil {
+		return
+	}
+
+	for !s.Empty() {
+		var arg *Amf0Any
+		if arg, err = codec.ReadAny(); err != nil {
+			return
+		}
+		r.Arguments = append(r.Arguments, arg)
+	}
+	return
+}
+// Encoder
+func (r *CallPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverConnection
+}
+func (r *CallPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AMF0CommandMessage
+}
+func (r *CallPacket) GetSize() (v int) {
+	v = Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.CommandObject.Size()
+	for _, arg := range r.Arguments {
+		v += arg.Size()
+	}
+	return
+}
+func (r *CallPacket) Encode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if err = codec.WriteString(r.CommandName); err != nil {
+		return
+	}
+	if err = codec.WriteNumber(r.TransactionId); err != nil {
+		return
+	}
+	if err = r.CommandObject.Write(codec); err != nil {
+		return
+	}
+	for _, arg := range r.Arguments {
+		if err = arg.Write(codec); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**
+* response for SrsCallPacket, the _result or _error.
+* for example, the _result of createStream:
+* 		CommandName="_result", TransactionId=2, CommandObject=null, Response=1(the stream id)
+* the _error of request:
+* 		CommandName="_error", TransactionId=2, CommandObject=null, Response={level:"error", code:...}
+*/
+// @see: SrsCallResPacket
+type CallResPacket struct {
+	CommandName string
+	TransactionId float64
+	// Null or Object
+	CommandObject *Amf0Any
+	// any amf0 value, nil if no response.
+	Response *Amf0Any
+}
+func NewCallResPacket() (*CallResPacket) {
+	r := &CallResPacket{}
+	r.CommandName = AMF0_COMMAND_RESULT
+	r.CommandObject = NewAmf0Null()
+	return r
+}
+// whether the response is _error.
+func (r *CallResPacket) IsError() (bool) {
+	return r.CommandName == AMF0_COMMAND_ERROR
+}
+// Decoder
+func (r *CallResPacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.CommandName, err = codec.ReadString(); err != nil {
+		return
+	}
+	if r.CommandName != AMF0_COMMAND_RESULT && r.CommandName != AMF0_COMMAND_ERROR {
+		names := []string {AMF0_COMMAND_RESULT, AMF0_COMMAND_ERROR}
+		return Error{code:ERROR_RTMP_AMF0_DECODE, desc:fmt.Sprintf("amf0 decode name failed. expect=(%v), actual=%v", strings.Join(names, ","), r.CommandName)}
+	}
+	if r.TransactionId, err = codec.ReadNumber(); err != nil {
+		return
+	}
+
+	if s.Empty() {
+		return
+	}
+	if r.CommandObject, err = codec.ReadAny(); err != nil {
+		return
+	}
+
+	if s.Empty() {
+		return
+	}
+	if r.Response, err = codec.ReadAny(); err != nil {
+		return
+	}
+	return
+}
+// Encoder
+func (r *CallResPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverConnection
+}
+func (r *CallResPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AMF0CommandMessage
+}
+func (r *CallResPacket) GetSize() (v int) {
+	v = Amf0SizeString(r.CommandName) + Amf0SizeNumber() + r.CommandObject.Size()
+	if r.Response != nil {
+		v += r.Response.Size()
+	}
+	return
+}
+func (r *CallResPacket) Encode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if err = codec.WriteString(r.CommandName); err != nil {
+		return
+	}
+	if err = codec.WriteNumber(r.TransactionId); err != nil {
+		return
+	}
+	if err = r.CommandObject.Write(codec); err != nil {
+		return
+	}
+	if r.Response != nil {
+		if err = r.Response.Write(codec); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**
+* the event type of shared object message.
+* @see: 3.4. Shared Object Message, rtmp_specification_1.0.pdf, page 25
+*/
+const (
+	SOEventUse = 1
+	SOEventRelease = 2
+	SOEventRequestChange = 3
+	SOEventChange = 4
+	SOEventSuccess = 5
+	SOEventSendMessage = 6
+	SOEventStatus = 7
+	SOEventClear = 8
+	SOEventRemove = 9
+	SOEventRequestRemove = 10
+	SOEventUseSuccess = 11
+)
+
+/**
+* the event of shared object message, for example, the Use to connect
+* to the shared object, the Change to set the properties.
+* the data is the raw bytes of event, for example, the pairs of
+* UTF-8 name and AMF0 value for Change.
+* @remark the Data references the message payload, never copy.
+*/
+type SharedObjectEvent struct {
+	// @see: SOEventUse
+	Type byte
+	Data []byte
+}
+
+/**
+* 3.4. Shared Object Message, the AMF0 shared object message(19).
+* the message contains the header of shared object and a list of events:
+* 		name(UTF-8) version(4B) flags(8B) *(event-type(1B) event-length(4B) event-data)
+* only decode the header and events, the persistence semantics is not supported.
+*/
+type SharedObjectPacket struct {
+	Name string
+	Version uint32
+	// the first 4bytes is the persistent flag, 2 is persistent.
+	Flags [8]byte
+	Events []*SharedObjectEvent
+}
+func NewSharedObjectPacket() (*SharedObjectPacket) {
+	return &SharedObjectPacket{}
+}
+// whether the shared object is persistent.
+func (r *SharedObjectPacket) IsPersistent() (bool) {
+	return r.Flags[3] == 0x02
+}
+// Decoder
+func (r *SharedObjectPacket) Decode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if r.Name, err = codec.ReadUtf8(); err != nil {
+		return
+	}
+
+	if !s.Requires(4 + 8) {
+		return Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:"decode shared object version and flags failed"}
+	}
+	r.Version = s.ReadUInt32()
+	copy(r.Flags[:], s.Read(8))
+
+	for !s.Empty() {
+		if !s.Requires(1 + 4) {
+			return Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:"decode shared object event header failed"}
+		}
+		event := &SharedObjectEvent{}
+		event.Type = s.ReadByte()
+		size := int(s.ReadUInt32())
+
+		if size < 0 || !s.Requires(size) {
+			return Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:fmt.Sprintf("decode shared object event data failed, size=%v", size)}
+		}
+		event.Data = s.Read(size)
+
+		r.Events = append(r.Events, event)
+	}
+	return
+}
+// Encoder
+func (r *SharedObjectPacket) GetPerferCid() (v int) {
+	return RTMP_CID_OverConnection2
+}
+func (r *SharedObjectPacket) GetMessageType() (v byte) {
+	return RTMP_MSG_AMF0SharedObject
+}
+func (r *SharedObjectPacket) GetSize() (v int) {
+	v = Amf0SizeUtf8(r.Name) + 4 + 8
+	for _, event := range r.Events {
+		v += 1 + 4 + len(event.Data)
+	}
+	return
+}
+func (r *SharedObjectPacket) Encode(s *Buffer) (err error) {
+	codec := NewAmf0Codec(s)
+
+	if err = codec.WriteUtf8(r.Name); err != nil {
+		return
+	}
+
+	if !s.Requires(4 + 8) {
+		return Error{code:ERROR_RTMP_MESSAGE_ENCODE, desc:"encode shared object version and flags failed"}
+	}
+	s.WriteUInt32(r.Version).Write(r.Flags[:])
+
+	for _, event := range r.Events {
+		if !s.Requires(1 + 4 + len(event.Data)) {
+			return Error{code:ERROR_RTMP_MESSAGE_ENCODE, desc:"encode shared object event failed"}
+		}
+		s.WriteByte(event.Type).WriteUInt32(uint32(len(event.Data))).Write(event.Data)
+	}
+	return
+}
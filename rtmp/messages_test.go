@@ -0,0 +1,655 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encode the packet to payload, check the GetSize is the bytes written.
+func encode_packet(t testing.TB, pkt Encoder) (b []byte) {
+	t.Helper()
+
+	b = make([]byte, pkt.GetSize())
+	s := NewRtmpStream(b)
+	if err := pkt.Encode(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Left() != 0 {
+		t.Fatalf("%T GetSize=%v, written=%v", pkt, pkt.GetSize(), pkt.GetSize() - s.Left())
+	}
+	return
+}
+
+func TestCallPacketFCPublish(t *testing.T) {
+	pkt := NewCallPacket()
+	pkt.CommandName = AMF0_COMMAND_FC_PUBLISH
+	pkt.TransactionId = 3
+	pkt.Arguments = append(pkt.Arguments, NewAmf0("streamKey"))
+
+	call := NewCallPacket()
+	if err := call.Decode(NewRtmpStream(encode_packet(t, pkt))); err != nil {
+		t.Fatal(err)
+	}
+	if call.CommandName != AMF0_COMMAND_FC_PUBLISH || call.TransactionId != 3 {
+		t.Errorf("decode %v tid=%v", call.CommandName, call.TransactionId)
+	}
+	if call.CommandObject.Marker != AMF0_Null {
+		t.Errorf("command object marker %v, expect null", call.CommandObject.Marker)
+	}
+	if len(call.Arguments) != 1 {
+		t.Fatalf("got %v arguments", len(call.Arguments))
+	}
+	if v, ok := call.Arguments[0].String(); !ok || v != "streamKey" {
+		t.Errorf("argument=%v, expect streamKey", v)
+	}
+}
+
+func TestUnknownCommandRoundTrip(t *testing.T) {
+	obj := NewAmf0Object()
+	obj.Set("vendor", NewAmf0("acme"))
+
+	pkt := NewCallPacket()
+	pkt.CommandName = "acmeSetBitrate"
+	pkt.TransactionId = 7
+	pkt.CommandObject = NewAmf0(obj)
+	pkt.Arguments = append(pkt.Arguments, NewAmf0("livestream"), NewAmf0(2500), NewAmf0(true))
+
+	// the made-up command is never dropped, decode as the generic call.
+	b := encode_packet(t, pkt)
+	h := &MessageHeader{MessageType:RTMP_MSG_AMF0CommandMessage, PayloadLength:uint32(len(b))}
+	v, err := DecodePacket(nil, h, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, ok := v.(*CallPacket)
+	if !ok {
+		t.Fatalf("decode %T, expect CallPacket", v)
+	}
+	if call.CommandName != "acmeSetBitrate" || call.TransactionId != 7 {
+		t.Errorf("decode %v tid=%v", call.CommandName, call.TransactionId)
+	}
+	if obj, ok := call.CommandObject.Object(); !ok {
+		t.Errorf("command object marker %v, expect object", call.CommandObject.Marker)
+	} else if v, _ := obj.GetPropertyString("vendor"); v != "acme" {
+		t.Errorf("vendor=%v, expect acme", v)
+	}
+	if len(call.Arguments) != 3 {
+		t.Fatalf("got %v arguments", len(call.Arguments))
+	}
+
+	// forward the call, the payload is byte-for-byte.
+	if string(encode_packet(t, call)) != string(b) {
+		t.Error("encode the decoded call changed")
+	}
+}
+
+// decode the payload of message type to packet.
+func decode_packet(t testing.TB, message_type byte, payload string) (pkt interface {}) {
+	t.Helper()
+
+	h := &MessageHeader{MessageType:message_type, PayloadLength:uint32(len(payload))}
+	pkt, err := DecodePacket(nil, h, []byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestConnectAmf3ObjectEncoding(t *testing.T) {
+	// the AMF3 command, 1byte 0 then the AMF0 connect, where the command
+	// object is AMF3 dynamic object {app:"live", objectEncoding:3}.
+	payload := "\x00\x02\x00\x07connect\x00\x3f\xf0\x00\x00\x00\x00\x00\x00" +
+		"\x11\x0a\x0b\x01" + "\x07app\x06\x09live" + "\x1dobjectEncoding\x04\x03" + "\x01"
+
+	pkt, ok := decode_packet(t, RTMP_MSG_AMF3CommandMessage, payload).(*ConnectAppPacket)
+	if !ok {
+		t.Fatalf("decode %T, expect connect", pkt)
+	}
+	if pkt.App() != "live" {
+		t.Errorf("app=%v", pkt.App())
+	}
+	if pkt.ObjectEncoding() != CodecAMF3 {
+		t.Errorf("objectEncoding=%v, expect %v", pkt.ObjectEncoding(), CodecAMF3)
+	}
+}
+
+func TestPlayMode(t *testing.T) {
+	for _, c := range []struct {
+		start float64
+		mode PlayMode
+	} {
+		{-2, PlayModeLiveOrRecorded},
+		{-1, PlayModeLive},
+		{0, PlayModeRecorded},
+	} {
+		pkt := NewPlayPacket()
+		pkt.StreamName = "livestream"
+		pkt.Start = c.start
+
+		play, ok := decode_packet(t, RTMP_MSG_AMF0CommandMessage, string(encode_packet(t, pkt))).(*PlayPacket)
+		if !ok {
+			t.Fatalf("decode %T, expect play", play)
+		}
+		if play.PlayMode() != c.mode {
+			t.Errorf("start=%v mode=%v, expect %v", c.start, play.PlayMode(), c.mode)
+		}
+	}
+}
+
+func TestSetDataFrame(t *testing.T) {
+	const bare = "\x02\x00\x0aonMetaData\x08\x00\x00\x00\x01\x00\x05width\x00\x40\x94\x00\x00\x00\x00\x00\x00\x00\x00\x09"
+	const wrapped = "\x02\x00\x0d@setDataFrame" + bare
+
+	new_data_message := func(payload string) (*Message) {
+		msg := new_av_message(RTMP_MSG_AMF0DataMessage, 0, []byte(payload))
+		msg.PerferCid = RTMP_CID_OverConnection2
+		return msg
+	}
+
+	// the FMLE and OBS forms, both are bare for player and wrapped for ingest.
+	for _, payload := range []string{wrapped, bare} {
+		msg := new_data_message(payload)
+		if v := StripSetDataFrame(msg); string(v.Payload) != bare || v.Header.PayloadLength != uint32(len(bare)) {
+			t.Errorf("strip %q got %q", payload, v.Payload)
+		}
+		if v := WrapSetDataFrame(msg); string(v.Payload) != wrapped || v.Header.PayloadLength != uint32(len(wrapped)) {
+			t.Errorf("wrap %q got %q", payload, v.Payload)
+		}
+		if string(msg.Payload) != payload {
+			t.Errorf("the message is modified to %q", msg.Payload)
+		}
+
+		// the player gets the bare metadata from caches.
+		gop, cache := NewGopCache(0), NewStreamCache()
+		gop.Cache(msg)
+		cache.Cache(msg)
+		if msgs := gop.Messages(); len(msgs) != 1 || string(msgs[0].Payload) != bare {
+			t.Errorf("gop cache %q got %v messages", payload, len(msgs))
+		}
+		if v := cache.Metadata(); v == nil || string(v.Payload) != bare {
+			t.Errorf("stream cache %q got %v", payload, v)
+		}
+
+		// the FLV script tag is bare, and wrapped when read to publish.
+		var b bytes.Buffer
+		w := NewFlvWriter(&b)
+		if err := w.WriteHeader(false, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(b.Bytes(), []byte(bare)) || bytes.Contains(b.Bytes(), []byte(wrapped)) {
+			t.Errorf("flv %q is not bare", payload)
+		}
+
+		r := NewFlvReader(&b)
+		if _, _, err := r.ReadHeader(); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := r.ReadMessage(); err != nil || string(v.Payload) != wrapped {
+			t.Errorf("flv read %q, err=%v", payload, err)
+		}
+	}
+}
+
+func TestPausePacket(t *testing.T) {
+	// pause(true, 1000) with transaction id 0 and null object.
+	pkt, ok := decode_packet(t, RTMP_MSG_AMF0CommandMessage, "\x02\x00\x05pause\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x01\x01\x00\x40\x8f\x40\x00\x00\x00\x00\x00").(*PausePacket)
+	if !ok {
+		t.Fatalf("decode %T, expect pause", pkt)
+	}
+	if !pkt.IsPause || pkt.MilliSeconds != 1000 {
+		t.Errorf("pause=%v, ms=%v", pkt.IsPause, pkt.MilliSeconds)
+	}
+}
+
+func TestReceiveVideoFalse(t *testing.T) {
+	pkt, ok := decode_packet(t, RTMP_MSG_AMF0CommandMessage, "\x02\x00\x0creceiveVideo\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x01\x00").(*ReceiveAVPacket)
+	if !ok {
+		t.Fatalf("decode %T, expect receiveVideo", pkt)
+	}
+	if pkt.IsAudio() || pkt.BoolFlag {
+		t.Errorf("audio=%v, flag=%v", pkt.IsAudio(), pkt.BoolFlag)
+	}
+}
+
+func TestDataPacketForward(t *testing.T) {
+	// onFI("sd", "2014-10-15", "st", "08:00:00.000") of AMF0 and AMF3 data.
+	const onfi = "\x02\x00\x04onFI\x08\x00\x00\x00\x02\x00\x02sd\x02\x00\x0a2014-10-15\x00\x02st\x02\x00\x0c08:00:00.000\x00\x00\x09"
+	for message_type, payload := range map[byte]string{RTMP_MSG_AMF0DataMessage:onfi, RTMP_MSG_AMF3DataMessage:"\x00" + onfi} {
+		msg := new_av_message(message_type, 40, []byte(payload))
+		msg.PerferCid = RTMP_CID_OverConnection2
+		p, _ := new_mock_protocol(encode_chunks(t, msg))
+
+		pkt, err := p.DecodeMessage(mock_recv_message(t, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := pkt.(*DataPacket)
+		if !ok || data.Name != "onFI" {
+			t.Fatalf("type %v decode %+v, expect onFI", message_type, pkt)
+		}
+
+		// forward the packet, the message is byte-for-byte.
+		fwd := new_packet_message(t, data)
+		if fwd.Header.MessageType != message_type || string(fwd.Payload) != payload {
+			t.Errorf("type %v forward as type %v, %q", message_type, fwd.Header.MessageType, fwd.Payload)
+		}
+	}
+}
+
+func TestConnectAmf3IntegerTransactionId(t *testing.T) {
+	// the transaction id is the AMF3 integer 1, switched by the avmplus marker.
+	payload := "\x00\x02\x00\x07connect\x11\x04\x01" +
+		"\x11\x0a\x0b\x01" + "\x07app\x06\x09live" + "\x01"
+
+	pkt, ok := decode_packet(t, RTMP_MSG_AMF3CommandMessage, payload).(*ConnectAppPacket)
+	if !ok {
+		t.Fatalf("decode %T, expect connect", pkt)
+	}
+	if pkt.TransactionId != 1 || pkt.App() != "live" {
+		t.Errorf("tid=%v, app=%v", pkt.TransactionId, pkt.App())
+	}
+
+	// the transaction id must be 1.
+	if err := NewConnectAppPacket().Decode(NewRtmpStream([]byte("\x02\x00\x07connect\x11\x04\x02\x05"))); err == nil {
+		t.Error("expect error for AMF3 integer transaction id 2")
+	}
+}
+
+func TestSetChunkSizeHighBit(t *testing.T) {
+	var err error
+	if err = NewSetChunkSizePacket().Decode(NewRtmpStream([]byte{0x80, 0x00, 0x10, 0x00})); err == nil {
+		t.Fatal("expect error for the high bit")
+	}
+	if err, ok := err.(Error); !ok || err.Code() != ERROR_RTMP_CHUNK_SIZE {
+		t.Errorf("expect chunk size error, got %v", err)
+	}
+
+	if err = NewSetChunkSizePacket().Decode(NewRtmpStream([]byte{0x00, 0x00, 0x10, 0x00})); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPlayReset(t *testing.T) {
+	// play(0, null, "livestream", -2, -1, reset)
+	const play = "\x02\x00\x04play\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x02\x00\x0alivestream" +
+		"\x00\xc0\x00\x00\x00\x00\x00\x00\x00\x00\xbf\xf0\x00\x00\x00\x00\x00\x00"
+	for _, c := range []struct {
+		reset string
+		want bool
+	}{
+		{"\x01\x01", true},
+		{"\x01\x00", false},
+		// the reset is optional, default to true.
+		{"", true},
+	} {
+		pkt, ok := decode_packet(t, RTMP_MSG_AMF0CommandMessage, play + c.reset).(*PlayPacket)
+		if !ok {
+			t.Fatalf("decode %T, expect play", pkt)
+		}
+		if pkt.StreamName != "livestream" || pkt.Start != -2 || pkt.Duration != -1 || pkt.Reset != c.want {
+			t.Errorf("reset %q got stream=%v, start=%v, duration=%v, reset=%v", c.reset, pkt.StreamName, pkt.Start, pkt.Duration, pkt.Reset)
+		}
+	}
+}
+
+func TestConnectTypeNonprivate(t *testing.T) {
+	// connect(1, {app:"live", type:"nonprivate", flashVer:"FMLE/3.0", tcUrl:"rtmp://127.0.0.1/live"})
+	const connect = "\x02\x00\x07connect\x00\x3f\xf0\x00\x00\x00\x00\x00\x00\x03" +
+		"\x00\x03app\x02\x00\x04live" +
+		"\x00\x04type\x02\x00\x0anonprivate" +
+		"\x00\x08flashVer\x02\x00\x08FMLE/3.0" +
+		"\x00\x05tcUrl\x02\x00\x15rtmp://127.0.0.1/live" +
+		"\x00\x00\x09"
+	pkt, ok := decode_packet(t, RTMP_MSG_AMF0CommandMessage, connect).(*ConnectAppPacket)
+	if !ok {
+		t.Fatalf("decode %T, expect connect", pkt)
+	}
+
+	v, ok := pkt.CommandObject.Get("type")
+	if !ok {
+		t.Fatal("no type property")
+	}
+	if s, ok := v.String(); !ok || s != "nonprivate" {
+		t.Errorf("type %v, want nonprivate", s)
+	}
+	if s, ok := pkt.CommandObject.GetPropertyString("type"); !ok || s != "nonprivate" {
+		t.Errorf("type %v, want nonprivate", s)
+	}
+	if pkt.TcUrl() != "rtmp://127.0.0.1/live" || pkt.FlashVer() != "FMLE/3.0" {
+		t.Errorf("tcUrl=%v, flashVer=%v", pkt.TcUrl(), pkt.FlashVer())
+	}
+	if _, ok := pkt.CommandObject.Get("swfUrl"); ok {
+		t.Error("swfUrl should not exists")
+	}
+}
+
+func TestConnectAppOBS(t *testing.T) {
+	// the connect object captured from OBS, without pageUrl and objectEncoding.
+	props := []struct {
+		k, v string
+	}{
+		{"app", "live"},
+		{"type", "nonprivate"},
+		{"flashVer", "FMLE/3.0 (compatible; FMSc/1.0)"},
+		{"swfUrl", "rtmp://127.0.0.1/live"},
+		{"tcUrl", "rtmp://127.0.0.1/live"},
+	}
+	payload := "\x02\x00\x07connect\x00\x3f\xf0\x00\x00\x00\x00\x00\x00\x03"
+	for _, p := range props {
+		payload += string([]byte{0, byte(len(p.k))}) + p.k + "\x02" + string([]byte{0, byte(len(p.v))}) + p.v
+	}
+	payload += "\x00\x00\x09"
+
+	pkt, ok := decode_packet(t, RTMP_MSG_AMF0CommandMessage, payload).(*ConnectAppPacket)
+	if !ok {
+		t.Fatalf("decode %T, expect connect", pkt)
+	}
+	for _, c := range []struct {
+		name, v, expect string
+	}{
+		{"app", pkt.App(), "live"},
+		{"tcUrl", pkt.TcUrl(), "rtmp://127.0.0.1/live"},
+		{"flashVer", pkt.FlashVer(), "FMLE/3.0 (compatible; FMSc/1.0)"},
+		{"swfUrl", pkt.SwfUrl(), "rtmp://127.0.0.1/live"},
+		{"pageUrl", pkt.PageUrl(), ""},
+	} {
+		if c.v != c.expect {
+			t.Errorf("%v=%q, expect %q", c.name, c.v, c.expect)
+		}
+	}
+	if pkt.ObjectEncoding() != CodecAMF0 {
+		t.Errorf("objectEncoding=%v, expect %v", pkt.ObjectEncoding(), CodecAMF0)
+	}
+}
+
+func TestStrictConnect(t *testing.T) {
+	const connect = "\x02\x00\x07connect\x00\x3f\xf0\x00\x00\x00\x00\x00\x00"
+	for _, c := range []struct {
+		name string
+		object string
+		strict bool
+		ok bool
+	}{
+		{"missing app", "\x03\x00\x05tcUrl\x02\x00\x15rtmp://127.0.0.1/live\x00\x00\x09", true, false},
+		{"present app", "\x03\x00\x03app\x02\x00\x04live\x00\x00\x09", true, true},
+		{"lenient missing app", "\x03\x00\x05tcUrl\x02\x00\x15rtmp://127.0.0.1/live\x00\x00\x09", false, true},
+	} {
+		p, _ := new_mock_protocol(nil)
+		p.SetStrictConnect(c.strict)
+
+		payload := connect + c.object
+		h := &MessageHeader{MessageType:RTMP_MSG_AMF0CommandMessage, PayloadLength:uint32(len(payload))}
+		pkt, err := DecodePacket(p, h, []byte(payload))
+		if c.ok {
+			if _, ok := pkt.(*ConnectAppPacket); err != nil || !ok {
+				t.Errorf("%v: decode %T, err is %v", c.name, pkt, err)
+			}
+			continue
+		}
+		if v, ok := err.(Error); !ok || v.code != ERROR_RTMP_REQ_CONNECT || !strings.Contains(v.desc, "app") {
+			t.Errorf("%v: got %v, expect missing app error", c.name, err)
+		}
+	}
+}
+
+func TestSharedObjectConnect(t *testing.T) {
+	// the shared object "chat", version 0, not persistent, with the Use event
+	// to connect to it and the Change event to set {msg:"hi"}.
+	payload := "\x00\x04chat" + "\x00\x00\x00\x00" + "\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x01\x00\x00\x00\x00" +
+		"\x04\x00\x00\x00\x0a" + "\x00\x03msg\x02\x00\x02hi"
+
+	pkt, ok := decode_packet(t, RTMP_MSG_AMF0SharedObject, payload).(*SharedObjectPacket)
+	if !ok {
+		t.Fatalf("decode %T, expect shared object", pkt)
+	}
+	if pkt.Name != "chat" || pkt.Version != 0 || pkt.IsPersistent() {
+		t.Errorf("name=%v, version=%v, persistent=%v", pkt.Name, pkt.Version, pkt.IsPersistent())
+	}
+	if len(pkt.Events) != 2 {
+		t.Fatalf("events is %v, expect 2", len(pkt.Events))
+	}
+	if e := pkt.Events[0]; e.Type != SOEventUse || len(e.Data) != 0 {
+		t.Errorf("event 0 type=%v, data=%x, expect use", e.Type, e.Data)
+	}
+	if e := pkt.Events[1]; e.Type != SOEventChange || string(e.Data) != "\x00\x03msg\x02\x00\x02hi" {
+		t.Errorf("event 1 type=%v, data=%x, expect change", e.Type, e.Data)
+	}
+
+	if b := encode_packet(t, pkt); string(b) != payload {
+		t.Errorf("encode %x, expect %x", b, payload)
+	}
+
+	// the event data exceed the payload.
+	h := &MessageHeader{MessageType:RTMP_MSG_AMF0SharedObject, PayloadLength:uint32(len(payload) - 1)}
+	if _, err := DecodePacket(nil, h, []byte(payload[:len(payload) - 1])); err == nil {
+		t.Error("decode truncated event should fail")
+	}
+}
+
+// the payloads of each message type, the seeds of fuzz.
+var decode_packet_seeds = []struct {
+	message_type byte
+	payload string
+}{
+	{RTMP_MSG_SetChunkSize, "\x00\x00\x10\x00"},
+	{RTMP_MSG_AbortMessage, "\x00\x00\x00\x03"},
+	{RTMP_MSG_Acknowledgement, "\x00\x00\x10\x00"},
+	{RTMP_MSG_UserControlMessage, "\x00\x00\x00\x00\x00\x01"},
+	{RTMP_MSG_UserControlMessage, "\x00\x03\x00\x00\x00\x01\x00\x00\x0b\xb8"},
+	{RTMP_MSG_WindowAcknowledgementSize, "\x00\x26\x25\xa0"},
+	{RTMP_MSG_SetPeerBandwidth, "\x00\x26\x25\xa0\x02"},
+	{RTMP_MSG_AudioMessage, "\xaf\x01\x21"},
+	{RTMP_MSG_VideoMessage, "\x17\x01\x00\x00\x00"},
+	{RTMP_MSG_AMF0CommandMessage, "\x02\x00\x07connect\x00\x3f\xf0\x00\x00\x00\x00\x00\x00\x03\x00\x03app\x02\x00\x04live\x00\x00\x09"},
+	{RTMP_MSG_AMF0CommandMessage, "\x02\x00\x07_result\x00\x3f\xf0\x00\x00\x00\x00\x00\x00\x03\x00\x06fmsVer\x02\x00\x0dFMS/3,5,3,888\x00\x00\x09\x03\x00\x04code\x02\x00\x04ok\x00\x00\x09"},
+	{RTMP_MSG_AMF0CommandMessage, "\x02\x00\x07_result\x00\x40\x00\x00\x00\x00\x00\x00\x00\x05\x00\x3f\xf0\x00\x00\x00\x00\x00\x00"},
+	{RTMP_MSG_AMF0CommandMessage, "\x02\x00\x0ccreateStream\x00\x40\x00\x00\x00\x00\x00\x00\x00\x05"},
+	{RTMP_MSG_AMF0CommandMessage, "\x02\x00\x04play\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x02\x00\x0alivestream\x00\xc0\x00\x00\x00\x00\x00\x00\x00"},
+	{RTMP_MSG_AMF0CommandMessage, "\x02\x00\x07publish\x00\x40\x14\x00\x00\x00\x00\x00\x00\x05\x02\x00\x0alivestream\x02\x00\x04live"},
+	{RTMP_MSG_AMF0CommandMessage, "\x02\x00\x05pause\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x01\x01\x00\x40\x8f\x40\x00\x00\x00\x00\x00"},
+	{RTMP_MSG_AMF0CommandMessage, "\x02\x00\x08onStatus\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05\x03\x00\x04code\x02\x00\x04ok\x00\x00\x09"},
+	{RTMP_MSG_AMF3CommandMessage, "\x00\x02\x00\x07connect\x00\x3f\xf0\x00\x00\x00\x00\x00\x00\x11\x0a\x0b\x01\x07app\x06\x09live\x01"},
+	{RTMP_MSG_AMF0DataMessage, "\x02\x00\x0d@setDataFrame\x02\x00\x0aonMetaData\x08\x00\x00\x00\x01\x00\x05width\x00\x40\x94\x00\x00\x00\x00\x00\x00\x00\x00\x09"},
+	{RTMP_MSG_AMF0DataMessage, "\x02\x00\x04onFI\x03\x00\x02sd\x02\x00\x0a2014-01-01\x00\x00\x09"},
+	{RTMP_MSG_AMF3DataMessage, "\x00\x02\x00\x0aonMetaData\x0a\x00\x00\x00\x00\x00\x00\x09"},
+	{RTMP_MSG_AMF0SharedObject, "\x00\x04chat\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00"},
+	{RTMP_MSG_AggregateMessage, "\x09\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\x17\x01\x00\x00\x00\x0d"},
+}
+
+/**
+* the crafted payload of peer should never panic, run it by:
+* 		go test -run=none -fuzz=FuzzDecodePacket
+*/
+func FuzzDecodePacket(f *testing.F) {
+	for _, seed := range decode_packet_seeds {
+		f.Add(seed.message_type, []byte(seed.payload))
+	}
+
+	f.Fuzz(func(t *testing.T, message_type byte, payload []byte) {
+		// the requests to decode the _result by the request.
+		p, _ := new_mock_protocol(nil)
+		p.SetStrictConnect(true)
+		p.on_send_request(1, AMF0_COMMAND_CONNECT)
+		p.on_send_request(2, AMF0_COMMAND_CREATE_STREAM)
+		p.on_send_request(3, AMF0_COMMAND_RELEASE_STREAM)
+		p.on_send_request(4, AMF0_COMMAND_PLAY)
+
+		h := &MessageHeader{MessageType:message_type, PayloadLength:uint32(len(payload))}
+		pkt, err := DecodePacket(p, h, payload)
+		if err == nil && pkt == nil && (h.IsCommand() || h.IsData()) {
+			t.Errorf("decode type %v got nil packet", message_type)
+		}
+	})
+}
+
+func TestPublishType(t *testing.T) {
+	for _, v := range []struct {
+		stream_type string
+		expect string
+	}{
+		{PUBLISH_TYPE_Live, PUBLISH_TYPE_Live},
+		{PUBLISH_TYPE_Record, PUBLISH_TYPE_Record},
+		{PUBLISH_TYPE_Append, PUBLISH_TYPE_Append},
+		{"", PUBLISH_TYPE_Live},
+	} {
+		pkt := NewPublishPacket()
+		pkt.StreamName = "livestream"
+		pkt.StreamType = v.stream_type
+
+		got, ok := decode_packet(t, RTMP_MSG_AMF0CommandMessage, string(encode_packet(t, pkt))).(*PublishPacket)
+		if !ok || got.StreamName != "livestream" || got.StreamType != v.expect {
+			t.Errorf("type %q decode to %+v, expect %q", v.stream_type, got, v.expect)
+		}
+	}
+
+	// the type is optional, default to live.
+	pkt := NewPublishPacket()
+	pkt.StreamName = "livestream"
+	b := encode_packet(t, pkt)
+	b = b[:len(b) - len("\x02\x00\x04live")]
+	if got := decode_packet(t, RTMP_MSG_AMF0CommandMessage, string(b)).(*PublishPacket); got.StreamType != PUBLISH_TYPE_Live {
+		t.Errorf("no type decode to %q", got.StreamType)
+	}
+
+	// the unknown type is rejected.
+	pkt.StreamType = "live2"
+	b = encode_packet(t, pkt)
+	h := &MessageHeader{MessageType:RTMP_MSG_AMF0CommandMessage, PayloadLength:uint32(len(b))}
+	_, err := DecodePacket(nil, h, b)
+	if v, ok := err.(Error); !ok || v.code != ERROR_RTMP_MESSAGE_DECODE {
+		t.Errorf("type live2 err=%v, expect decode error", err)
+	}
+}
+
+func TestGetSizePopulatedObject(t *testing.T) {
+	data := NewAmf0EcmaArray()
+	data.Set("version", NewAmf0("3,5,3,888"))
+	data.Set("srs_id", NewAmf0(100))
+
+	res := NewConnectAppResPacket()
+	res.Props.Set("fmsVer", NewAmf0("FMS/3,5,3,888"))
+	res.Props.Set("capabilities", NewAmf0(127))
+	res.Props.Set("mode", NewAmf0(1))
+	res.Info.Set("level", NewAmf0("status"))
+	res.Info.Set("code", NewAmf0("NetConnection.Connect.Success"))
+	res.Info.Set("description", NewAmf0(strings.Repeat("x", 0x10000)))
+	res.Info.Set("objectEncoding", NewAmf0(0))
+	res.Info.Set("data", NewAmf0(data))
+
+	status := NewOnStatusCallPacket()
+	status.Data.Set("level", NewAmf0("status"))
+	status.Data.Set("code", NewAmf0("NetStream.Publish.Start"))
+	status.Data.Set("nested", NewAmf0(NewAmf0Object()))
+
+	metadata := NewOnMetaDataPacket()
+	metadata.Metadata.Set("width", NewAmf0(1280))
+	metadata.Metadata.Set("encoder", NewAmf0("obs"))
+	metadata.Metadata.Set("stereo", NewAmf0(true))
+
+	// the encode_packet fails when GetSize is not the written bytes,
+	// both the populated and the empty objects.
+	for _, pkt := range []Encoder{res, NewConnectAppResPacket(), status, NewOnStatusCallPacket(), metadata, NewOnMetaDataPacket()} {
+		b := encode_packet(t, pkt)
+		if _, err := DecodePacket(nil, &MessageHeader{MessageType:pkt.GetMessageType(), PayloadLength:uint32(len(b))}, b); err != nil {
+			t.Errorf("%T decode failed, err is %v", pkt, err)
+		}
+	}
+}
+
+func TestSetIgnoreEmptyObject(t *testing.T) {
+	data := NewAmf0EcmaArray()
+	data.Set("srs_id", NewAmf0(100))
+
+	call := NewOnStatusCallPacket().Set(SCODE, "").Set("empty", NewAmf0Object()).Set("array", NewAmf0EcmaArray()).Set("data", data)
+	status := NewOnStatusDataPacket().Set(SCODE, "").Set("empty", NewAmf0Object()).Set("array", NewAmf0EcmaArray()).Set("data", data)
+	for _, v := range []*Amf0Object{call.Data, status.Data} {
+		// the empty string has content, the empty object and array not.
+		if _, ok := v.GetPropertyString(SCODE); !ok {
+			t.Error("empty string should be set")
+		}
+		if _, ok := v.Get("empty"); ok {
+			t.Error("empty object should be ignored")
+		}
+		if _, ok := v.Get("array"); ok {
+			t.Error("empty ecma array should be ignored")
+		}
+		if _, ok := v.Get("data"); !ok {
+			t.Error("ecma array should be set")
+		}
+	}
+}
+
+func TestOnFCPublishPacket(t *testing.T) {
+	msg := new_packet_message(t, NewOnFCPublishPacket("livestream"))
+	if msg.Header.MessageType != RTMP_MSG_AMF0CommandMessage {
+		t.Errorf("type=%v, expect command message", msg.Header.MessageType)
+	}
+
+	// onFCPublish(0, null, {code:NetStream.Publish.Start, description})
+	call := NewCallPacket()
+	if err := call.Decode(NewRtmpStream(msg.Payload)); err != nil {
+		t.Fatal(err)
+	}
+	if call.CommandName != AMF0_COMMAND_ON_FC_PUBLISH || call.TransactionId != 0 || call.CommandObject.Marker != AMF0_Null {
+		t.Errorf("decode %v tid=%v marker=%v", call.CommandName, call.TransactionId, call.CommandObject.Marker)
+	}
+	if len(call.Arguments) != 1 {
+		t.Fatalf("got %v arguments", len(call.Arguments))
+	}
+	info, ok := call.Arguments[0].Object()
+	if !ok {
+		t.Fatalf("argument marker=%v, expect object", call.Arguments[0].Marker)
+	}
+	if v, _ := info.GetPropertyString(SCODE); v != SCODE_PublishStart {
+		t.Errorf("code=%v, expect %v", v, SCODE_PublishStart)
+	}
+	if v, _ := info.GetPropertyString(SDESC); v != "Started publishing stream livestream." {
+		t.Errorf("description=%v", v)
+	}
+}
+
+func TestOnFIPacket(t *testing.T) {
+	pkt := NewOnFIPacket().SetTime(time.Date(2026, 10, 15, 8, 30, 0, 125 * int(time.Millisecond), time.UTC))
+	if pkt.SystemDate != "15-10-2026" || pkt.SystemTime != "08:30:00.125" {
+		t.Errorf("sd=%v, st=%v", pkt.SystemDate, pkt.SystemTime)
+	}
+
+	// onFI({sd, st}) of AMF0 data, the ecma array in order.
+	msg := new_packet_message(t, pkt)
+	const expect = "\x02\x00\x04onFI\x08\x00\x00\x00\x02\x00\x02sd\x02\x00\x0a15-10-2026\x00\x02st\x02\x00\x0c08:30:00.125\x00\x00\x09"
+	if msg.Header.MessageType != RTMP_MSG_AMF0DataMessage || string(msg.Payload) != expect {
+		t.Errorf("type=%v payload %q, expect %q", msg.Header.MessageType, msg.Payload, expect)
+	}
+
+	// the peer decode it as the general data.
+	if data, ok := decode_packet(t, RTMP_MSG_AMF0DataMessage, expect).(*DataPacket); !ok || data.Name != AMF0_DATA_ON_FI {
+		t.Errorf("decode %+v, expect onFI", data)
+	}
+}
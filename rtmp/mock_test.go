@@ -0,0 +1,194 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+/**
+* the mock conn for test, the written bytes are kept in w,
+* and the read consume the bytes of r, return EOF when empty.
+*/
+type mock_conn struct {
+	r *bytes.Buffer
+	w *bytes.Buffer
+	closed bool
+	// the max bytes of each read, <=0 to read all.
+	max_read int
+	// the number of write calls.
+	writes int
+}
+func new_mock_conn(b []byte) (*mock_conn) {
+	return &mock_conn{r:bytes.NewBuffer(b), w:&bytes.Buffer{}}
+}
+func (r *mock_conn) Read(b []byte) (int, error) {
+	if r.max_read > 0 && len(b) > r.max_read {
+		b = b[:r.max_read]
+	}
+	return r.r.Read(b)
+}
+func (r *mock_conn) Write(b []byte) (int, error) {
+	r.writes++
+	return r.w.Write(b)
+}
+func (r *mock_conn) Close() (error) {
+	r.closed = true
+	return nil
+}
+func (r *mock_conn) LocalAddr() (net.Addr) {
+	return &net.TCPAddr{IP:net.IPv4(127, 0, 0, 1), Port:1935}
+}
+func (r *mock_conn) RemoteAddr() (net.Addr) {
+	return &net.TCPAddr{IP:net.IPv4(127, 0, 0, 1), Port:50410}
+}
+func (r *mock_conn) SetDeadline(t time.Time) (error) {
+	return nil
+}
+func (r *mock_conn) SetReadDeadline(t time.Time) (error) {
+	return nil
+}
+func (r *mock_conn) SetWriteDeadline(t time.Time) (error) {
+	return nil
+}
+
+// the mock logger, keep the formatted logs.
+type mock_logger struct {
+	logs []string
+}
+func (r *mock_logger) Debugf(format string, v ...interface {}) {
+	r.logs = append(r.logs, fmt.Sprintf(format, v...))
+}
+func (r *mock_logger) Warnf(format string, v ...interface {}) {
+	r.logs = append(r.logs, fmt.Sprintf(format, v...))
+}
+func (r *mock_logger) Errorf(format string, v ...interface {}) {
+	r.logs = append(r.logs, fmt.Sprintf(format, v...))
+}
+
+// the mock file in memory, for the FlvWriter to finalize.
+type mock_file struct {
+	b []byte
+	off int64
+}
+func (r *mock_file) Read(p []byte) (n int, err error) {
+	if r.off >= int64(len(r.b)) {
+		return 0, io.EOF
+	}
+	n = copy(p, r.b[r.off:])
+	r.off += int64(n)
+	return
+}
+func (r *mock_file) Write(p []byte) (n int, err error) {
+	if end := r.off + int64(len(p)); end > int64(len(r.b)) {
+		r.b = append(r.b, make([]byte, end - int64(len(r.b)))...)
+	}
+	n = copy(r.b[r.off:], p)
+	r.off += int64(n)
+	return
+}
+func (r *mock_file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += int64(len(r.b))
+	}
+	r.off = offset
+	return offset, nil
+}
+func (r *mock_file) Truncate(size int64) (error) {
+	r.b = r.b[:size]
+	return nil
+}
+
+// create the protocol over the mock conn, never start the goroutines.
+func new_mock_protocol(b []byte) (*protocol, *mock_conn) {
+	conn := new_mock_conn(b)
+	p, _ := NewProtocol(conn)
+	return p.(*protocol), conn
+}
+
+// encode the packet to message.
+func new_packet_message(t testing.TB, pkt Encoder) (*Message) {
+	t.Helper()
+
+	p, _ := new_mock_protocol(nil)
+	_, msg, err := p.EncodeMessage(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// create the audio or video message.
+func new_av_message(message_type byte, timestamp uint64, payload []byte) (*Message) {
+	msg := NewMessage()
+	msg.Header.MessageType = message_type
+	msg.Header.Timestamp = timestamp
+	msg.Header.StreamId = 1
+	msg.Header.PayloadLength = uint32(len(payload))
+	msg.Payload = payload
+	msg.PerferCid = RTMP_CID_Video
+	if message_type == RTMP_MSG_AudioMessage {
+		msg.PerferCid = RTMP_CID_Audio
+	}
+	return msg
+}
+
+// encode the messages to chunks, in the default chunk size.
+func encode_chunks(t testing.TB, msgs ...*Message) ([]byte) {
+	t.Helper()
+
+	p, conn := new_mock_protocol(nil)
+	for _, msg := range msgs {
+		if err := p.do_send_msg_goroutine_job(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return conn.w.Bytes()
+}
+
+// get the messages queued to send, the send goroutine is not started.
+func queued_messages(p *protocol) ([]*Message) {
+	p.msg_out_queue.lock.Lock()
+	defer p.msg_out_queue.lock.Unlock()
+
+	return append([]*Message{}, p.msg_out_queue.msgs...)
+}
+
+// recv a message in the test goroutine, the recv goroutine is not started.
+func mock_recv_message(t testing.TB, p *protocol) (*Message) {
+	t.Helper()
+
+	for len(p.msg_in_queue) == 0 {
+		if err := p.do_recv_msg_goroutine_job(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return <-p.msg_in_queue
+}
@@ -0,0 +1,82 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"sync"
+)
+
+/**
+* the size classes of payload pool, power of 2 from 256B to 16MB,
+* larger payload is allocated without pool.
+*/
+const (
+	payload_pool_min_shift = 8
+	payload_pool_max_shift = 24
+)
+
+/**
+* the payload buffer pools, one pool for each size class,
+* to reduce the allocations of message payload for recv and encode.
+* @remark the buffer in pool is *[]byte, to avoid allocation of Put.
+*/
+var payload_pools [payload_pool_max_shift - payload_pool_min_shift + 1]sync.Pool
+
+// get the size class of size, -1 when not pooled.
+func payload_size_class(size int) (int) {
+	for i := 0; i < len(payload_pools); i++ {
+		if size <= 1 << uint(payload_pool_min_shift + i) {
+			return i
+		}
+	}
+	return -1
+}
+
+/**
+* alloc the payload of size from pool.
+* @return the payload and whether it's from pool.
+*/
+func alloc_payload(size int) (b []byte, pooled bool) {
+	class := payload_size_class(size)
+	if class < 0 {
+		return make([]byte, size), false
+	}
+
+	if v, ok := payload_pools[class].Get().(*[]byte); ok {
+		return (*v)[:size], true
+	}
+	return make([]byte, size, 1 << uint(payload_pool_min_shift + class)), true
+}
+
+/**
+* free the payload which alloc by alloc_payload to pool,
+* the payload must not be used after free.
+*/
+func free_payload(b []byte) {
+	class := payload_size_class(cap(b))
+	if class < 0 || cap(b) != 1 << uint(payload_pool_min_shift + class) {
+		return
+	}
+
+	b = b[:cap(b)]
+	payload_pools[class].Put(&b)
+}
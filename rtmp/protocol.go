@@ -22,12 +22,34 @@
 package rtmp
 
 import (
+	"context"
 	"math"
+	"fmt"
 	"reflect"
 	"sync"
-	"runtime"
+	"sync/atomic"
+	"time"
 )
 
+/**
+* the default max payload size of received message, the peer may advertise
+* a huge payload length to make us alloc memory for nothing,
+* @see Protocol.SetMaxMessageSize
+*/
+const RTMP_DEFAULT_MAX_MESSAGE_SIZE = 8 * 1024 * 1024
+
+// the max time to flush the pending messages when Close.
+const RTMP_CLOSE_FLUSH_TIMEOUT = 3 * time.Second
+
+/**
+* the default max chunk streams of received messages, the cid is 2 to 65599,
+* each chunk stream cache the header and the partial message,
+* the peer may use all cid to exhaust the memory, generally,
+* the peer only use a few cid, for example, SRS use cid 2 to 6.
+* @see Protocol.SetMaxChunkStreams
+*/
+const RTMP_DEFAULT_MAX_CHUNK_STREAMS = 256
+
 /**
 * the handshake data, 6146B = 6KB,
 * store in the protocol and never delete it for every connection.
@@ -36,6 +58,8 @@ type Handshake struct {
 	c0c1 []byte // 1537B
 	s0s1s2 []byte // 3073B
 	c2 []byte // 1536B
+	// the s1 in s0s1s2 from server, only for client.
+	s1 []byte // 1536B
 }
 
 type AckWindowSize struct {
@@ -58,6 +82,18 @@ func (r *AckWindowSize) ShouldAckRead(n uint64) (bool) {
 * and to send out RTMP message over RTMP chunk stream.
 */
 type protocol struct {
+	// the logger, nil to log nothing.
+	logger Logger
+	// the handler to stream the payload of message, nil to buffer it.
+	chunk_handler ChunkHandler
+	// the max payload size of received message, <=0 to disable.
+	max_message_size int
+	// the max chunk streams of received messages, <=0 to disable.
+	max_chunk_streams int
+	// the callback when handshake complete, nil to ignore.
+	on_handshake_complete func(remote_addr string, t time.Time)
+	// the statistic of connection.
+	stats *protocol_stats
 	// handshake
 	handshake *Handshake
 	// peer in/out
@@ -67,8 +103,13 @@ type protocol struct {
 	* requests sent out, used to build the response.
 	* key: a float64 indicates the transactionId
 	* value: a string indicates the request command name
+	* @remark the request is removed when its response decoded.
+	* @remark user can send packet in any goroutine, lock it.
 	*/
 	requests map[float64]string
+	requests_lock *sync.Mutex
+	// the max transaction id sent, to alloc the transaction id.
+	last_transaction_id float64
 	// peer in
 	chunkStreams map[int]*ChunkStream
 	// the bytes read from underlayer tcp connection,
@@ -78,24 +119,65 @@ type protocol struct {
 	inChunkSize uint32
 	// the acked size
 	inAckSize AckWindowSize
+	// whether disable the auto acknowledgement, 1 to disable,
+	// for the proxy forward the acknowledgement of endpoints. use atomic.
+	disable_auto_ack int32
+	// the peer bandwidth and limit type, set by peer.
+	// @remark user get it in other goroutine, lock it.
+	peer_bandwidth uint32
+	peer_bandwidth_type byte
+	peer_bandwidth_lock *sync.Mutex
+	// whether validate the connect strictly. use atomic.
+	strict_connect_enabled int32
+	// the object encoding negotiated by connect, CodecAMF0 or CodecAMF3,
+	// the command is sent as AMF3 command message for AMF3. use atomic.
+	object_encoding int32
+	// the context of user, for example, the auth info of connection.
+	// @remark user get it in other goroutine, lock it.
+	user_context interface {}
+	user_context_lock *sync.Mutex
 	// peer out
 	// output chunk stream chunk size.
 	outChunkSize uint32
+	/**
+	* the output chunk streams, key is the cid,
+	* cache the last message header sent over the cid.
+	* the messages of different streams maybe sent over the same cid,
+	* for instance, the video of multiple streams over RTMP_CID_Video,
+	* the stream id of the cached header identify which stream the
+	* timestamp base belongs to, so the delta never cross streams.
+	* @remark must be accessed in msg_out_lock.
+	*/
+	outChunkStreams map[int]*ChunkStream
 	// bytes cache, size is RTMP_MAX_FMT0_HEADER_SIZE
 	outHeaderFmt0 *Buffer
 	// bytes cache, size is RTMP_MAX_FMT3_HEADER_SIZE
 	outHeaderFmt3 *Buffer
+	// the chunk headers and the iovecs of message to writev,
+	// reused for all messages, grow for the large message.
+	outHeaders []byte
+	outIovs [][]byte
 	// use channel to store the decoded message, or messages to encode,
 	// for user can use select to determinate the event of message(incoming or outgoing)
 	// message channel lock, to stop protocol
 	msg_in_lock *sync.Mutex
 	msg_out_lock *sync.Mutex
-	// input/output error
+	// input/output error, set by the recv and send goroutine, lock it.
 	msg_io_err error
+	msg_io_err_lock *sync.Mutex
 	// message input queue, received message from connection.
 	msg_in_queue chan *Message
-	// message output queue, message to send over connection
-	msg_out_queue chan *Message
+	// message output queue, message to send over connection,
+	// bounded by max messages, @see SetOutQueuePolicy
+	msg_out_queue *message_queue
+	// the input queue is closed by recv goroutine or destroy.
+	msg_in_queue_once *sync.Once
+	// whether the input/output goroutines started, use atomic.
+	pumps_started int32
+	// closed when the send goroutine quit.
+	send_done chan bool
+	// whether closed, Close is idempotent, use atomic.
+	closed int32
 }
 
 /**
@@ -107,12 +189,69 @@ func (r *protocol) Destroy() {
 	defer r.msg_out_lock.Unlock()
 	defer r.msg_in_lock.Unlock()
 
+	r.set_io_err(Error{code:ERROR_GO_PROTOCOL_DESTROYED, desc:"protocol stack destroyed"})
+
+	r.close_msg_in_queue()
+	r.msg_out_queue.close()
+}
+
+/**
+* close the protocol gracefully, flush the pending messages,
+* then close the connection, the recv goroutine quit for closed,
+* and RecvMessage return error when the input queue is empty.
+* @remark it's idempotent, the second Close is ignored and return nil.
+ */
+func (r *protocol) Close() (err error) {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return
+	}
+
+	// flush the pending messages, util all sent or timeout.
+	r.msg_out_queue.drain()
+	if atomic.LoadInt32(&r.pumps_started) == 1 {
+		select {
+		case <- r.send_done:
+		case <- time.After(RTMP_CLOSE_FLUSH_TIMEOUT):
+			if r.logger != nil {
+				r.logger.Warnf("close flush timeout, drop %v messages", r.msg_out_queue.size())
+			}
+		}
+	}
+
+	// close the conn, to abort the recv goroutine.
+	err = r.conn.Close()
+	r.msg_out_queue.close()
+
+	// the recv goroutine close the input queue when quit.
+	if atomic.LoadInt32(&r.pumps_started) == 0 {
+		r.close_msg_in_queue()
+	}
+	return
+}
+
+// get the error of recv or send goroutine, nil if ok.
+func (r *protocol) io_err() (err error) {
+	r.msg_io_err_lock.Lock()
+	defer r.msg_io_err_lock.Unlock()
+
+	return r.msg_io_err
+}
+
+// set the error of recv or send goroutine, the first error is kept, ignore nil.
+func (r *protocol) set_io_err(err error) {
+	r.msg_io_err_lock.Lock()
+	defer r.msg_io_err_lock.Unlock()
+
 	if r.msg_io_err == nil {
-		r.msg_io_err = Error{code:ERROR_GO_PROTOCOL_DESTROYED, desc:"protocol stack destroyed"}
+		r.msg_io_err = err
 	}
+}
 
-	close(r.msg_in_queue)
-	close(r.msg_out_queue)
+// close the input queue, only close once by recv goroutine or destroy.
+func (r *protocol) close_msg_in_queue() {
+	r.msg_in_queue_once.Do(func() {
+		close(r.msg_in_queue)
+	})
 }
 
 func (r *protocol) MessageInputChannel() (chan *Message) {
@@ -125,38 +264,57 @@ func (r *protocol) MessageInputChannel() (chan *Message) {
 * send messages in msg_out_queue over connection
  */
 func (r *protocol) start_message_pump_goroutines() {
+	atomic.StoreInt32(&r.pumps_started, 1)
 	go r.recv_msg_goroutine()
 	go r.send_msg_goroutine()
 }
 func (r *protocol) recv_msg_goroutine() {
-	for r.msg_io_err == nil {
+	for r.io_err() == nil {
 		r.do_recv_msg_goroutine()
 	}
+
+	// notify the RecvMessage that no more message.
+	r.close_msg_in_queue()
 }
 func (r *protocol) send_msg_goroutine() {
-	for r.msg_io_err == nil {
+	for r.io_err() == nil {
 		r.do_send_msg_goroutine()
 	}
+
+	close(r.send_done)
 }
 func (r *protocol) do_recv_msg_goroutine() {
 	r.msg_in_lock.Lock()
 	defer r.msg_in_lock.Unlock()
 
-	if r.msg_io_err != nil {
+	if r.io_err() != nil {
 		return
 	}
 
-	r.msg_io_err = r.do_recv_msg_goroutine_job()
+	r.set_io_err(r.do_recv_msg_goroutine_job())
 }
 func (r *protocol) do_send_msg_goroutine() {
+	// donot lock when wait for message, for the
+	// amf0 stream writer to write to connection.
+	msg, ok := r.msg_out_queue.pop()
+
 	r.msg_out_lock.Lock()
 	defer r.msg_out_lock.Unlock()
 
-	if r.msg_io_err != nil {
+	if r.io_err() != nil {
+		return
+	}
+
+	if !ok {
+		r.set_io_err(Error{code:ERROR_GO_PROTOCOL_DESTROYED, desc:"protocol stack destroyed, cannot send"})
 		return
 	}
 
-	r.msg_io_err = r.do_send_msg_goroutine_job()
+	r.set_io_err(r.do_send_msg_goroutine_job(msg))
+
+	if msg.release_on_sent {
+		msg.Release()
+	}
 }
 func (r *protocol) do_recv_msg_goroutine_job() (err error) {
 	var msg *Message
@@ -173,97 +331,235 @@ func (r *protocol) do_recv_msg_goroutine_job() (err error) {
 		return
 	}
 
+	// bind to protocol, to decode the packet on demand.
+	msg.protocol = r
+
+	r.stats.on_recv_message(msg.Header.MessageType)
+
 	if err = r.on_recv_message(msg); err != nil {
 		return
 	}
 
+	// the streamed message is consumed by the chunk handler.
+	if msg.streamed {
+		return
+	}
+
 	r.msg_in_queue <- msg
 	return
 }
-func (r *protocol) do_send_msg_goroutine_job() (err error) {
-	var msg *Message
-	var ok bool
-	if msg, ok = <- r.msg_out_queue; !ok {
-		err = Error{code:ERROR_GO_PROTOCOL_DESTROYED, desc:"protocol stack destroyed, cannot send"}
-		return
+/**
+* send the message in chunks, the chunk header is encoded to the
+* preallocated outHeaderFmt0 or outHeaderFmt3, then copied to outHeaders,
+* and the chunk payload is sliced from the message payload, so there is
+* no allocation for each chunk, the payload is never copied.
+* all chunks of message are sent in one writev, to reduce the syscalls.
+* @remark must be called in msg_out_lock.
+ */
+func (r *protocol) do_send_msg_goroutine_job(msg *Message) (err error) {
+	// the headers must never grow when build the iovecs, which reference it.
+	nb_chunks := 1 + len(msg.Payload) / int(r.outChunkSize)
+	if cap(r.outHeaders) < nb_chunks * RTMP_MAX_FMT0_HEADER_SIZE {
+		r.outHeaders = make([]byte, 0, nb_chunks * RTMP_MAX_FMT0_HEADER_SIZE)
 	}
+	headers := r.outHeaders[:0]
+	iovs := r.outIovs[:0]
 
-	// always write the header event payload is empty.
-	msg.SentPayloadLength = -1
-	for len(msg.Payload) > msg.SentPayloadLength {
-		msg.SentPayloadLength = int(math.Max(0, float64(msg.SentPayloadLength)))
+	// use the received fmt to forward verbatim.
+	perfer_format := -1
+	if msg.verbatim && msg.recv_chunk_size == r.outChunkSize && msg.recv_fmt <= RTMP_FMT_TYPE2 {
+		perfer_format = int(msg.recv_fmt)
+	}
 
+	// always write the header even payload is empty.
+	msg.SentPayloadLength = 0
+	for first_chunk := true; first_chunk || msg.SentPayloadLength < len(msg.Payload); first_chunk = false {
 		// generate the header.
-		var real_header []byte
-
-		if msg.SentPayloadLength <= 0 {
-			// write new chunk stream header, fmt is 0
-			var pheader *Buffer = r.outHeaderFmt0.Reset()
-			pheader.WriteByte(0x00 | byte(msg.PerferCid & 0x3F))
-
-			// chunk message header, 11 bytes
-			// timestamp, 3bytes, big-endian
-			if msg.Header.Timestamp > RTMP_EXTENDED_TIMESTAMP {
-				pheader.WriteUInt24(uint32(0xFFFFFF))
-			} else {
-				pheader.WriteUInt24(uint32(msg.Header.Timestamp))
-			}
+		real_header := r.encode_chunk_header(msg.Header, msg.PerferCid, first_chunk, perfer_format)
 
-			// message_length, 3bytes, big-endian
-			// message_type, 1bytes
-			// message_length, 3bytes, little-endian
-			pheader.WriteUInt24(msg.Header.PayloadLength).WriteByte(msg.Header.MessageType).WriteUInt32Le(msg.Header.StreamId)
+		headers = append(headers, real_header...)
+		iovs = append(iovs, headers[len(headers)-len(real_header):])
 
-			// chunk extended timestamp header, 0 or 4 bytes, big-endian
-			if msg.Header.Timestamp > RTMP_EXTENDED_TIMESTAMP {
-				pheader.WriteUInt32(uint32(msg.Header.Timestamp))
+		// the payload of chunk
+		if len(msg.Payload) > 0 {
+			payload_size := len(msg.Payload) - msg.SentPayloadLength
+			if payload_size > int(r.outChunkSize) {
+				payload_size = int(r.outChunkSize)
 			}
 
-			real_header = r.outHeaderFmt0.WrittenBytes()
+			data := msg.Payload[msg.SentPayloadLength:msg.SentPayloadLength+payload_size]
+			iovs = append(iovs, data)
+
+			// consume sendout bytes when not empty packet.
+			msg.SentPayloadLength += payload_size
+		}
+	}
+
+	// the iovecs is consumed by writev, reset the slices for gc.
+	_, err = r.conn.Writev(iovs)
+	for i := range iovs {
+		iovs[i] = nil
+	}
+	r.outIovs = iovs[:0]
+	if err != nil {
+		return
+	}
+
+	r.stats.on_send_message(msg.Header.MessageType)
+
+	// the peer use the new chunk size after the Set Chunk Size,
+	// so the messages before it must use the old chunk size.
+	if msg.Header.IsSetChunkSize() && len(msg.Payload) >= 4 {
+		chunk_size := NewRtmpStream(msg.Payload).ReadUInt32() & 0x7fffffff
+		if r.logger != nil {
+			r.logger.Debugf("output chunk size changed %v=>%v", r.outChunkSize, chunk_size)
+		}
+		r.outChunkSize = chunk_size
+		r.stats.on_out_chunk_size(r.outChunkSize)
+	}
+
+	return
+}
+
+/**
+* encode the chunk header of message over cid, for the first chunk,
+* select the fmt by the last message sent over the cid:
+* 		fmt1 when the stream id is the same,
+* 		fmt2 when the length and type is also the same,
+* 		fmt0 for others, for example, the first message of cid.
+* the continuation chunks always use fmt3.
+* the first chunk update the output chunk stream cache of cid.
+* @param perfer_format the fmt to use when it's valid, -1 to ignore.
+* @remark the returned bytes is the cache of protocol,
+* 		only valid before next call, must be called in msg_out_lock.
+ */
+func (r *protocol) encode_chunk_header(header *MessageHeader, cid int, first_chunk bool, perfer_format int) (real_header []byte) {
+	if first_chunk {
+		format := r.update_out_chunk_stream(header, cid, perfer_format)
+		chunk := r.outChunkStreams[cid]
+		if r.logger != nil {
+			r.logger.Debugf("send chunk fmt=%v, cid=%v, type=%v, size=%v, timestamp=%v, stream=%v",
+				format, cid, header.MessageType, header.PayloadLength, header.Timestamp, header.StreamId)
+		}
+
+		// write new chunk stream header, fmt is 0, 1 or 2
+		var pheader *Buffer = r.outHeaderFmt0.Reset()
+		write_basic_header(pheader, format, cid)
+
+		// chunk message header, 11, 7 or 3 bytes
+		// timestamp for fmt0, or timestamp delta for fmt1 and fmt2, 3bytes, big-endian
+		if chunk.ExtendedTimestamp {
+			pheader.WriteUInt24(uint32(0xFFFFFF))
 		} else {
-			// write no message header chunk stream, fmt is 3
-			var pheader *Buffer = r.outHeaderFmt3.Reset()
-			pheader.WriteByte(0xC0 | byte(msg.PerferCid & 0x3F))
-
-			// chunk extended timestamp header, 0 or 4 bytes, big-endian
-			// 6.1.3. Extended Timestamp
-			// This field is transmitted only when the normal time stamp in the
-			// chunk message header is set to 0x00ffffff. If normal time stamp is
-			// set to any value less than 0x00ffffff, this field MUST NOT be
-			// present. This field MUST NOT be present if the timestamp field is not
-			// present. Type 3 chunks MUST NOT have this field.
-			// adobe changed for Type3 chunk:
-			//		FMLE always sendout the extended-timestamp,
-			// 		must send the extended-timestamp to FMS,
-			//		must send the extended-timestamp to flash-player.
-			// @see: ngx_rtmp_prepare_message
-			// @see: http://blog.csdn.net/win_lin/article/details/13363699
-			if msg.Header.Timestamp > RTMP_EXTENDED_TIMESTAMP {
-				pheader.WriteUInt32(uint32(msg.Header.Timestamp))
-			}
+			pheader.WriteUInt24(chunk.Header.TimestampDelta)
+		}
 
-			real_header = r.outHeaderFmt3.WrittenBytes()
+		// message_length, 3bytes, big-endian
+		// message_type, 1bytes
+		if format <= RTMP_FMT_TYPE1 {
+			pheader.WriteUInt24(header.PayloadLength).WriteByte(header.MessageType)
+		}
+		// message_length, 3bytes, little-endian
+		if format == RTMP_FMT_TYPE0 {
+			pheader.WriteUInt32Le(header.StreamId)
 		}
 
-		// sendout header
-		if _, err = r.conn.Write(real_header); err != nil {
-			return
+		// chunk extended timestamp header, 0 or 4 bytes, big-endian
+		if chunk.ExtendedTimestamp {
+			pheader.WriteUInt32(chunk.Header.TimestampDelta)
 		}
 
-		// sendout payload
-		if len(msg.Payload) > 0 {
-			payload_size := len(msg.Payload) - msg.SentPayloadLength
-			payload_size = int(math.Min(float64(r.outChunkSize), float64(payload_size)))
+		return r.outHeaderFmt0.WrittenBytes()
+	}
 
-			data := msg.Payload[msg.SentPayloadLength:msg.SentPayloadLength+payload_size]
-			if _, err = r.conn.Write(data); err != nil {
-				return
-			}
+	// write no message header chunk stream, fmt is 3
+	var pheader *Buffer = r.outHeaderFmt3.Reset()
+	write_basic_header(pheader, RTMP_FMT_TYPE3, cid)
+
+	// chunk extended timestamp header, 0 or 4 bytes, big-endian
+	// 6.1.3. Extended Timestamp
+	// This field is transmitted only when the normal time stamp in the
+	// chunk message header is set to 0x00ffffff. If normal time stamp is
+	// set to any value less than 0x00ffffff, this field MUST NOT be
+	// present. This field MUST NOT be present if the timestamp field is not
+	// present. Type 3 chunks MUST NOT have this field.
+	// adobe changed for Type3 chunk:
+	//		FMLE always sendout the extended-timestamp,
+	// 		must send the extended-timestamp to FMS,
+	//		must send the extended-timestamp to flash-player.
+	// the value is the same as the first chunk, the timestamp or delta.
+	// @see: ngx_rtmp_prepare_message
+	// @see: http://blog.csdn.net/win_lin/article/details/13363699
+	if chunk, ok := r.outChunkStreams[cid]; ok && chunk.ExtendedTimestamp {
+		pheader.WriteUInt32(chunk.Header.TimestampDelta)
+	}
 
-			// consume sendout bytes when not empty packet.
-			msg.SentPayloadLength += payload_size
+	return r.outHeaderFmt3.WrittenBytes()
+}
+
+/**
+* write the basic header, 1, 2 or 3 bytes, depends on the cid:
+* 		cid 2-63, 1byte, the cid in the low 6bits.
+* 		cid 64-319, 2bytes, the low 6bits is 0, then cid-64.
+* 		cid 320-65599, 3bytes, the low 6bits is 1, then cid-64 in little-endian.
+ */
+func write_basic_header(pheader *Buffer, format byte, cid int) {
+	if cid < 64 {
+		pheader.WriteByte(format << 6 | byte(cid & 0x3F))
+	} else if cid < 320 {
+		pheader.WriteByte(format << 6 | 0x00)
+		pheader.WriteByte(byte(cid - 64))
+	} else {
+		pheader.WriteByte(format << 6 | 0x01)
+		pheader.WriteByte(byte((cid - 64) & 0xff))
+		pheader.WriteByte(byte((cid - 64) >> 8))
+	}
+}
+
+/**
+* cache the header of message sent over cid, return the fmt to send it.
+* the fmt0 header always carry the absolute timestamp and stream id,
+* so when the stream id of cid changed, the timestamp base is reset
+* to the message of the new stream, never use the delta of other stream.
+* the timestamp delta is never negative, use fmt0 when timestamp jumps back.
+* the perfer_format is used when it carry more fields than the selected fmt,
+* for example, fmt0 is always ok, -1 to ignore.
+* @remark must be called in msg_out_lock.
+ */
+func (r *protocol) update_out_chunk_stream(header *MessageHeader, cid int, perfer_format int) (format byte) {
+	chunk, ok := r.outChunkStreams[cid]
+	if !ok {
+		chunk = NewChunkStream(cid)
+		r.outChunkStreams[cid] = chunk
+	}
+
+	format = RTMP_FMT_TYPE0
+	if chunk.MsgCount > 0 && chunk.Header.StreamId == header.StreamId && header.Timestamp >= chunk.Header.Timestamp {
+		format = RTMP_FMT_TYPE1
+		if chunk.Header.PayloadLength == header.PayloadLength && chunk.Header.MessageType == header.MessageType {
+			format = RTMP_FMT_TYPE2
 		}
 	}
+	if perfer_format >= 0 && byte(perfer_format) < format {
+		format = byte(perfer_format)
+	}
+
+	// the timestamp for fmt0, the delta for fmt1 and fmt2.
+	var delta uint64 = header.Timestamp
+	if format != RTMP_FMT_TYPE0 {
+		delta = header.Timestamp - chunk.Header.Timestamp
+	}
+
+	// the delta exceed 32bits, use the fmt0 and absolute timestamp.
+	if delta > math.MaxUint32 {
+		format, delta = RTMP_FMT_TYPE0, header.Timestamp
+	}
+
+	chunk.FMT = format
+	*chunk.Header = *header
+	chunk.Header.TimestampDelta = uint32(delta)
+	chunk.ExtendedTimestamp = delta >= RTMP_EXTENDED_TIMESTAMP
+	chunk.MsgCount++
 
 	return
 }
@@ -274,18 +570,31 @@ func (r *protocol) do_send_msg_goroutine_job() (err error) {
 * specifies message.
 */
 func (r *protocol) RecvMessage() (msg *Message, err error) {
+	return r.RecvMessageContext(context.Background())
+}
+
+func (r *protocol) RecvMessageContext(ctx context.Context) (msg *Message, err error) {
 	var ok bool
-	if msg, ok = <- r.msg_in_queue; ok {
-		return
+	select {
+	case msg, ok = <- r.msg_in_queue:
+		if ok {
+			return
+		}
+	case <- ctx.Done():
+		// unblock the read of recv goroutine, the protocol is broken.
+		r.conn.AbortRead()
+		return nil, ctx.Err()
 	}
 
-	if r.msg_io_err != nil {
-		err = r.msg_io_err
+	return nil, r.recv_closed_err()
+}
+
+// the error when the msg_in_queue is closed, the io error or destroyed.
+func (r *protocol) recv_closed_err() (err error) {
+	if err = r.io_err(); err != nil {
 		return
 	}
-
-	err = Error{code:ERROR_GO_PROTOCOL_DESTROYED, desc:"recv msg from destroyed stack"}
-	return
+	return Error{code:ERROR_GO_PROTOCOL_DESTROYED, desc:"recv msg from destroyed stack"}
 }
 
 /**
@@ -297,14 +606,22 @@ func (r *protocol) DecodeMessage(msg *Message) (pkt interface {}, err error) {
 		return
 	}
 
-	pkt, err = DecodePacket(r, msg.Header, msg.Payload)
-	return
+	// decode by the message, which cache the packet.
+	if msg.protocol == nil {
+		msg.protocol = r
+	}
+	return msg.Packet()
 }
 
 /**
 * expect a specified message by v, drop others util got specified one.
 */
 func (r *protocol) ExpectPacket(v interface {}) (msg *Message, err error) {
+	msg, _, err = r.ExpectPacketLimit(v, 0, 0)
+	return
+}
+
+func (r *protocol) ExpectPacketLimit(v interface {}, timeout time.Duration, max_drops int) (msg *Message, dropped int, err error) {
 	rv := reflect.ValueOf(v)
 	rt := reflect.TypeOf(v)
 	if rv.Kind() != reflect.Ptr {
@@ -320,8 +637,30 @@ func (r *protocol) ExpectPacket(v interface {}) (msg *Message, err error) {
 		return
 	}
 
-	for {
-		if msg, err = r.RecvMessage(); err != nil {
+	// the timer never abort the read, the protocol is ok when timeout.
+	var timeout_c <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeout_c = timer.C
+	}
+
+	for ; ; dropped++ {
+		if max_drops > 0 && dropped >= max_drops {
+			msg = nil
+			err = Error{code:ERROR_GO_EXPECT_EXCEEDED, desc:fmt.Sprintf("expect packet exceed max drops %v", max_drops)}
+			return
+		}
+
+		var ok bool
+		select {
+		case msg, ok = <- r.msg_in_queue:
+		case <- timeout_c:
+			err = Error{code:ERROR_GO_EXPECT_EXCEEDED, desc:fmt.Sprintf("expect packet timeout %v, drops %v", timeout, dropped)}
+			return
+		}
+		if !ok {
+			err = r.recv_closed_err()
 			return
 		}
 		var pkt interface {}
@@ -356,21 +695,37 @@ func (r *protocol) EncodeMessage(pkt Encoder) (cid int, msg *Message, err error)
 	msg = NewMessage()
 
 	cid = pkt.GetPerferCid()
+	msg.PerferCid = cid
+	msg.Header.MessageType = pkt.GetMessageType()
 
+	// the empty packet, only send the header.
 	size := pkt.GetSize()
 	if size <= 0 {
 		return
 	}
 
-	b := make([]byte, size)
-	s := NewRtmpStream(b)
+	// the AMF3 command message starts with 1byte 0, then the AMF0 command.
+	var prefix int
+	if msg.Header.IsAmf0Command() && r.ObjectEncoding() == CodecAMF3 {
+		msg.Header.MessageType = RTMP_MSG_AMF3CommandMessage
+		prefix = 1
+	}
+
+	b, pooled := alloc_payload(prefix + size)
+	if prefix > 0 {
+		b[0] = 0
+	}
+	s := NewRtmpStream(b[prefix:])
 	if err = pkt.Encode(s); err != nil {
+		if pooled {
+			free_payload(b)
+		}
 		return
 	}
 
-	msg.Header.MessageType = pkt.GetMessageType()
-	msg.Header.PayloadLength = uint32(size)
+	msg.Header.PayloadLength = uint32(prefix + size)
 	msg.Payload = b
+	msg.pooled = pooled
 
 	return
 }
@@ -384,6 +739,8 @@ func (r *protocol) SendPacket(pkt Encoder, stream_id uint32) (err error) {
 		return
 	}
 	msg.PerferCid = cid
+	// the message is owned by protocol, release it when sent.
+	msg.release_on_sent = true
 
 	if err = r.SendMessage(msg, stream_id); err != nil {
 		return
@@ -396,6 +753,10 @@ func (r *protocol) SendPacket(pkt Encoder, stream_id uint32) (err error) {
 }
 
 func (r *protocol) SendMessage(pkt *Message, stream_id uint32) (err error) {
+	return r.SendMessageContext(context.Background(), pkt, stream_id)
+}
+
+func (r *protocol) SendMessageContext(ctx context.Context, pkt *Message, stream_id uint32) (err error) {
 	var msg *Message = pkt
 
 	if msg == nil {
@@ -405,57 +766,138 @@ func (r *protocol) SendMessage(pkt *Message, stream_id uint32) (err error) {
 		msg.Header.StreamId = stream_id
 	}
 
-	defer func(){
-		if re := recover(); re != nil {
-			if _, ok := re.(runtime.Error); ok {
-				// write to closed channel
-				if err == nil {
-					err = r.msg_io_err
-				}
-				return
-			}
-			panic(re)
-		}
-	}()
-
-	r.msg_out_queue <- msg
+	// unblock the write of send goroutine, when the queue is full and ctx is done.
+	if err = r.msg_out_queue.push(ctx, msg); err != nil && err == ctx.Err() {
+		r.conn.AbortWrite()
+	}
 	return
 }
 
-func (r *protocol) on_send_message(pkt Encoder) (err error) {
-	if pkt, ok := pkt.(*SetChunkSizePacket); ok {
-		r.outChunkSize = pkt.ChunkSize
-		return
+func (r *protocol) SendSharedMessage(msg *Message, stream_id uint32) (err error) {
+	if msg == nil {
+		return Error{code:ERROR_GO_RTMP_NOT_SUPPORT_MSG, desc:"message not support send"}
+	}
+
+	// the header and sent size is owned by the copy,
+	// the payload is shared by all protocols.
+	return r.SendMessage(msg.Copy(), stream_id)
+}
+
+func (r *protocol) SendVerbatimMessage(msg *Message, stream_id uint32) (err error) {
+	if msg == nil {
+		return Error{code:ERROR_GO_RTMP_NOT_SUPPORT_MSG, desc:"message not support send"}
 	}
 
+	copy := msg.Copy()
+	copy.verbatim = true
+	return r.SendMessage(copy, stream_id)
+}
+
+func (r *protocol) SetRecvTimeout(timeout time.Duration) {
+	r.conn.SetRecvTimeout(timeout)
+}
+
+func (r *protocol) SetSendTimeout(timeout time.Duration) {
+	r.conn.SetSendTimeout(timeout)
+}
+
+func (r *protocol) SetRecvBuffer(size int) (err error) {
+	return r.conn.SetRecvBuffer(size)
+}
+
+func (r *protocol) SetSendBuffer(size int) (err error) {
+	return r.conn.SetSendBuffer(size)
+}
+
+func (r *protocol) SetOutQueuePolicy(max_messages int, policy int) {
+	r.msg_out_queue.set_policy(max_messages, policy)
+}
+
+func (r *protocol) on_send_message(pkt Encoder) (err error) {
 	if pkt, ok := pkt.(*ConnectAppPacket); ok {
-		r.requests[pkt.TransactionId] = pkt.CommandName
+		r.on_send_request(pkt.TransactionId, pkt.CommandName)
 		return
 	}
 
 	if pkt, ok := pkt.(*CreateStreamPacket); ok {
-		r.requests[pkt.TransactionId] = pkt.CommandName
+		r.on_send_request(pkt.TransactionId, pkt.CommandName)
+		return
+	}
+
+	if pkt, ok := pkt.(*FMLEStartPacket); ok {
+		r.on_send_request(pkt.TransactionId, pkt.CommandName)
+		return
+	}
+
+	if pkt, ok := pkt.(*CallPacket); ok {
+		r.on_send_request(pkt.TransactionId, pkt.CommandName)
 		return
 	}
 	return
 }
+// record the request, to decode the response by transaction id.
+func (r *protocol) on_send_request(transaction_id float64, command_name string) {
+	// the transaction id 0 means no response.
+	if transaction_id <= 0 {
+		return
+	}
+
+	r.requests_lock.Lock()
+	defer r.requests_lock.Unlock()
+
+	r.requests[transaction_id] = command_name
+	if transaction_id > r.last_transaction_id {
+		r.last_transaction_id = transaction_id
+	}
+}
+// the response of request received, remove the request, for
+// the requests should never grow with the calls.
+func (r *protocol) on_recv_response(transaction_id float64) (request_name string) {
+	r.requests_lock.Lock()
+	defer r.requests_lock.Unlock()
+
+	if request_name = r.requests[transaction_id]; request_name != "" {
+		delete(r.requests, transaction_id)
+	}
+	return
+}
+
+func (r *protocol) SendCall(pkt *CallPacket, stream_id uint32) (err error) {
+	pkt.TransactionId = r.NextTransactionId()
+	return r.SendPacket(pkt, stream_id)
+}
+
+func (r *protocol) NextTransactionId() (transaction_id float64) {
+	r.requests_lock.Lock()
+	defer r.requests_lock.Unlock()
+
+	// the requests sent also update the last transaction id.
+	r.last_transaction_id++
+	return r.last_transaction_id
+}
 
 func (r *protocol) on_recv_message(msg *Message) (err error) {
 	// acknowledgement
-	if r.inAckSize.ShouldAckRead(r.conn.RecvBytes()) {
-		return r.response_acknowledgement_message()
+	if r.should_ack() {
+		if err = r.response_acknowledgement_message(); err != nil {
+			return
+		}
 	}
 
 	// decode the msg if needed
 	var pkt interface {}
-	if msg.Header.IsSetChunkSize() || msg.Header.IsUserControlMessage() || msg.Header.IsWindowAcknowledgementSize() {
+	if msg.Header.IsSetChunkSize() || msg.Header.IsUserControlMessage() || msg.Header.IsWindowAcknowledgementSize() || msg.Header.IsSetPeerBandwidth() {
 		if pkt, err = r.DecodeMessage(msg); err != nil {
 			return
 		}
 	}
 
 	if pkt, ok := pkt.(*SetChunkSizePacket); ok {
+		if r.logger != nil {
+			r.logger.Debugf("input chunk size changed %v=>%v", r.inChunkSize, pkt.ChunkSize)
+		}
 		r.inChunkSize = pkt.ChunkSize
+		r.stats.on_in_chunk_size(r.inChunkSize)
 		return
 	}
 
@@ -463,6 +905,21 @@ func (r *protocol) on_recv_message(msg *Message) (err error) {
 		if pkt.AcknowledgementWindowSize > 0 {
 			r.inAckSize.ack_window_size = pkt.AcknowledgementWindowSize
 		}
+
+		// when window shrink, maybe already exceed the new window,
+		// ack immediately, or peer may think we donot ack.
+		if r.should_ack() {
+			return r.response_acknowledgement_message()
+		}
+		return
+	}
+
+	if pkt, ok := pkt.(*SetPeerBandwidthPacket); ok {
+		r.peer_bandwidth_lock.Lock()
+		defer r.peer_bandwidth_lock.Unlock()
+
+		r.peer_bandwidth = pkt.Bandwidth
+		r.peer_bandwidth_type = pkt.BandwidthType
 		return
 	}
 
@@ -471,7 +928,17 @@ func (r *protocol) on_recv_message(msg *Message) (err error) {
 	return
 }
 
+func (r *protocol) PeerBandwidth() (bandwidth uint32, bw_type byte) {
+	r.peer_bandwidth_lock.Lock()
+	defer r.peer_bandwidth_lock.Unlock()
+
+	return r.peer_bandwidth, r.peer_bandwidth_type
+}
+
 func (r *protocol) HistoryRequestName(transaction_id float64) (request_name string) {
+	r.requests_lock.Lock()
+	defer r.requests_lock.Unlock()
+
 	request_name, _ = r.requests[transaction_id]
 	return
 }
@@ -488,14 +955,25 @@ func (r *protocol) recv_interlaced_message() (msg *Message, err error) {
 	// get the cached chunk stream.
 	chunk, ok := r.chunkStreams[cid]
 	if !ok {
+		if r.max_chunk_streams > 0 && len(r.chunkStreams) >= r.max_chunk_streams {
+			err = Error{code:ERROR_GO_CHUNK_STREAMS_OVERFLOW, desc:fmt.Sprintf("chunk streams exceed max %v, cid=%v", r.max_chunk_streams, cid)}
+			return
+		}
 		chunk = NewChunkStream(cid)
 		r.chunkStreams[cid] = chunk
 	}
 
 	// chunk stream message header
 	if mh_size, err = r.read_message_header(chunk, format); err != nil {
+		if r.logger != nil {
+			r.logger.Errorf("read chunk message header failed, fmt=%v, cid=%v, err=%v", format, cid, err)
+		}
 		return
 	}
+	if r.logger != nil && format != RTMP_FMT_TYPE3 {
+		r.logger.Debugf("recv chunk fmt=%v, cid=%v, type=%v, size=%v, timestamp=%v, stream=%v",
+			format, cid, chunk.Header.MessageType, chunk.Header.PayloadLength, chunk.Header.Timestamp, chunk.Header.StreamId)
+	}
 
 	// read msg payload from chunk stream.
 	if msg, err = r.read_message_payload(chunk, bh_size, mh_size); err != nil {
@@ -510,6 +988,13 @@ func (r *protocol) recv_interlaced_message() (msg *Message, err error) {
 	return
 }
 
+/**
+* read the basic header, 1, 2 or 3 bytes, depends on the cid.
+* the header maybe splitted in multiple tcp reads, so we always
+* EnsureBufferBytes the exact size before read any field,
+* the buffer accumulate the bytes util the header is complete.
+* @remark the bytes is not consumed, consume when message payload read.
+ */
 func (r *protocol) read_basic_header() (format byte, cid int, bh_size int, err error) {
 	if err = r.buffer.EnsureBufferBytes(1); err != nil {
 		return
@@ -541,6 +1026,13 @@ func (r *protocol) read_basic_header() (format byte, cid int, bh_size int, err e
 	return
 }
 
+/**
+* read the message header, the size depends on the fmt:
+* 		fmt0: 11bytes, fmt1: 7bytes, fmt2: 3bytes, fmt3: 0bytes,
+* 		and 4bytes extended timestamp when the timestamp is 0xffffff.
+* like the basic header, EnsureBufferBytes the exact size of the
+* message header, then the extended timestamp, before read them.
+ */
 func (r *protocol) read_message_header(chunk *ChunkStream, format byte) (mh_size int, err error) {
 	/**
 	* we should not assert anything about fmt, for the first packet.
@@ -586,6 +1078,8 @@ func (r *protocol) read_message_header(chunk *ChunkStream, format byte) (mh_size
 	// create msg when new chunk stream start
 	if chunk.Msg == nil {
 		chunk.Msg = NewMessage()
+		chunk.Msg.recv_fmt = format
+		chunk.Msg.recv_chunk_size = r.inChunkSize
 	}
 
 	// read message header from socket to buffer.
@@ -613,41 +1107,16 @@ func (r *protocol) read_message_header(chunk *ChunkStream, format byte) (mh_size
 		// 0x00ffffff), this value MUST be 16777215, and the ‘extended
 		// timestamp header’ MUST be present. Otherwise, this value SHOULD be
 		// the entire delta.
-		if chunk.ExtendedTimestamp = false; chunk.Header.TimestampDelta >= RTMP_EXTENDED_TIMESTAMP {
-			chunk.ExtendedTimestamp = true
-		}
-		if chunk.ExtendedTimestamp {
-			// Extended timestamp: 0 or 4 bytes
-			// This field MUST be sent when the normal timsestamp is set to
-			// 0xffffff, it MUST NOT be sent if the normal timestamp is set to
-			// anything else. So for values less than 0xffffff the normal
-			// timestamp field SHOULD be used in which case the extended timestamp
-			// MUST NOT be present. For values greater than or equal to 0xffffff
-			// the normal timestamp field MUST NOT be used and MUST be set to
-			// 0xffffff and the extended timestamp MUST be sent.
-			//
-			// if extended timestamp, the timestamp must >= RTMP_EXTENDED_TIMESTAMP
-			// we set the timestamp to RTMP_EXTENDED_TIMESTAMP to identify we
-			// got an extended timestamp.
-			chunk.Header.Timestamp = RTMP_EXTENDED_TIMESTAMP
-		} else {
-			if format == RTMP_FMT_TYPE0 {
-				// 6.1.2.1. Type 0
-				// For a type-0 chunk, the absolute timestamp of the message is sent
-				// here.
-				chunk.Header.Timestamp = uint64(chunk.Header.TimestampDelta)
-			} else {
-				// 6.1.2.2. Type 1
-				// 6.1.2.3. Type 2
-				// For a type-1 or type-2 chunk, the difference between the previous
-				// chunk's timestamp and the current chunk's timestamp is sent here.
-				chunk.Header.Timestamp += uint64(chunk.Header.TimestampDelta)
-			}
-		}
+		chunk.ExtendedTimestamp = chunk.Header.TimestampDelta >= RTMP_EXTENDED_TIMESTAMP
 
 		if format <= RTMP_FMT_TYPE1 {
 			chunk.Header.PayloadLength = r.buffer.ReadUInt24()
 
+			// never alloc the huge payload for malicious peer.
+			if _, err = safe_payload_size(chunk.Header.PayloadLength, r.max_message_size); err != nil {
+				return
+			}
+
 			// if msg exists in cache, the size must not changed.
 			if chunk.Msg.Payload != nil && len(chunk.Msg.Payload) != int(chunk.Header.PayloadLength) {
 				err = Error{code:ERROR_RTMP_PACKET_SIZE, desc:"cached message size should never change"}
@@ -660,33 +1129,54 @@ func (r *protocol) read_message_header(chunk *ChunkStream, format byte) (mh_size
 				chunk.Header.StreamId = r.buffer.ReadUInt32Le()
 			}
 		}
-	} else {
-		// update the timestamp even fmt=3 for first stream
-		if is_fresh_packet && !chunk.ExtendedTimestamp {
-			chunk.Header.Timestamp += uint64(chunk.Header.TimestampDelta)
-		}
 	}
 
 	if chunk.ExtendedTimestamp {
+		// Extended timestamp: 0 or 4 bytes
+		// This field MUST be sent when the normal timsestamp is set to
+		// 0xffffff, it MUST NOT be sent if the normal timestamp is set to
+		// anything else. So for values less than 0xffffff the normal
+		// timestamp field SHOULD be used in which case the extended timestamp
+		// MUST NOT be present. For values greater than or equal to 0xffffff
+		// the normal timestamp field MUST NOT be used and MUST be set to
+		// 0xffffff and the extended timestamp MUST be sent.
 		mh_size += 4
 		if err = r.buffer.EnsureBufferBytes(4); err != nil {
 			return
 		}
+		timestamp := r.buffer.ReadUInt32()
 
+		// for fmt3, the extended timestamp is the same as the previous chunk,
 		// ffmpeg/librtmp may donot send this filed, need to detect the value.
 		// @see also: http://blog.csdn.net/win_lin/article/details/13363699
-		timestamp := r.buffer.ReadUInt32()
-
-		// compare to the chunk timestamp, which is set by chunk message header
-		// type 0,1 or 2.
-		if chunk.Header.Timestamp > RTMP_EXTENDED_TIMESTAMP && chunk.Header.Timestamp != uint64(timestamp) {
+		if format == RTMP_FMT_TYPE3 && chunk.Header.TimestampDelta != timestamp {
 			mh_size -= 4
 			r.buffer.Skip(-4)
 		} else {
-			chunk.Header.Timestamp = uint64(timestamp)
+			// the extended timestamp is the timestamp for fmt0,
+			// or the timestamp delta for fmt1 and fmt2.
+			chunk.Header.TimestampDelta = timestamp
 		}
 	}
 
+	if format == RTMP_FMT_TYPE0 {
+		// 6.1.2.1. Type 0
+		// For a type-0 chunk, the absolute timestamp of the message is sent
+		// here.
+		chunk.Header.Timestamp = uint64(chunk.Header.TimestampDelta)
+	} else if format <= RTMP_FMT_TYPE2 {
+		// 6.1.2.2. Type 1
+		// 6.1.2.3. Type 2
+		// For a type-1 or type-2 chunk, the difference between the previous
+		// chunk's timestamp and the current chunk's timestamp is sent here.
+		chunk.Header.Timestamp += uint64(chunk.Header.TimestampDelta)
+	} else if is_fresh_packet {
+		// 6.1.2.4. Type 3
+		// the fmt3 of a new message reuse the delta of previous chunk,
+		// while the fmt3 of the partial message is the same message.
+		chunk.Header.Timestamp += uint64(chunk.Header.TimestampDelta)
+	}
+
 	// valid message
 	if int32(chunk.Header.PayloadLength) < 0 {
 		err = Error{code:ERROR_RTMP_MSG_INVLIAD_SIZE, desc:"chunk packet should never be negative"}
@@ -703,6 +1193,23 @@ func (r *protocol) read_message_header(chunk *ChunkStream, format byte) (mh_size
 	return
 }
 
+/**
+* convert the payload length to int to alloc the payload,
+* for 32bits platform, the uint32 maybe overflow to negative int,
+* reject the length which overflow int or exceed the max_size.
+* @param max_size the max payload size, <=0 to disable.
+ */
+func safe_payload_size(length uint32, max_size int) (size int, err error) {
+	if uint64(length) > uint64(math.MaxInt) {
+		return 0, Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:fmt.Sprintf("message size %v overflow int", length)}
+	}
+
+	if size = int(length); max_size > 0 && size > max_size {
+		return 0, Error{code:ERROR_RTMP_MESSAGE_DECODE, desc:fmt.Sprintf("message size %v exceed max %v", length, max_size)}
+	}
+	return
+}
+
 func (r *protocol) read_message_payload(chunk *ChunkStream, bh_size int, mh_size int) (msg *Message, err error) {
 	// empty message
 	if int32(chunk.Header.PayloadLength) <= 0 {
@@ -712,27 +1219,43 @@ func (r *protocol) read_message_payload(chunk *ChunkStream, bh_size int, mh_size
 		return
 	}
 
+	var size int
+	if size, err = safe_payload_size(chunk.Header.PayloadLength, r.max_message_size); err != nil {
+		return
+	}
+
 	// the chunk payload size.
-	payload_size := int(chunk.Header.PayloadLength) - chunk.Msg.ReceivedPayloadLength
+	payload_size := size - chunk.Msg.ReceivedPayloadLength
 	payload_size = int(math.Min(float64(payload_size), float64(r.inChunkSize)))
 
+	// whether to stream the message to handler, when got the first chunk.
+	if chunk.Msg.ReceivedPayloadLength == 0 && r.chunk_handler != nil && !chunk.Msg.Header.IsProtocolControl() {
+		chunk.Msg.streamed = r.chunk_handler.OnMessageStart(chunk.Msg.Header)
+	}
+
 	// create msg payload if not initialized
-	if chunk.Msg.Payload == nil {
-		chunk.Msg.Payload = make([]byte, chunk.Msg.Header.PayloadLength)
+	if chunk.Msg.Payload == nil && !chunk.Msg.streamed {
+		chunk.Msg.Payload, chunk.Msg.pooled = alloc_payload(size)
 	}
 
 	// read payload to buffer
 	if err = r.buffer.EnsureBufferBytes(payload_size); err != nil {
 		return
 	}
-	copy(chunk.Msg.Payload[chunk.Msg.ReceivedPayloadLength:chunk.Msg.ReceivedPayloadLength+payload_size], r.buffer.Read(payload_size))
+	if chunk.Msg.streamed {
+		if err = r.chunk_handler.OnChunk(chunk.Msg.Header, chunk.Msg.ReceivedPayloadLength, r.buffer.Read(payload_size)); err != nil {
+			return
+		}
+	} else {
+		copy(chunk.Msg.Payload[chunk.Msg.ReceivedPayloadLength:chunk.Msg.ReceivedPayloadLength+payload_size], r.buffer.Read(payload_size))
+	}
 	chunk.Msg.ReceivedPayloadLength += payload_size
 	if err = r.buffer.Consume(mh_size + bh_size + payload_size); err != nil {
 		return
 	}
 
 	// got entire RTMP message?
-	if chunk.Msg.ReceivedPayloadLength == len(chunk.Msg.Payload) {
+	if chunk.Msg.ReceivedPayloadLength == size {
 		msg = chunk.Msg
 		chunk.Msg = nil
 		return
@@ -741,8 +1264,113 @@ func (r *protocol) read_message_payload(chunk *ChunkStream, bh_size int, mh_size
 	return
 }
 
+// whether should send the acknowledgement, when auto ack and exceed the window.
+func (r *protocol) should_ack() (bool) {
+	if atomic.LoadInt32(&r.disable_auto_ack) != 0 {
+		return false
+	}
+	return r.inAckSize.ShouldAckRead(r.conn.RecvBytes())
+}
+
+func (r *protocol) SetLogger(logger Logger) {
+	r.logger = logger
+}
+
+func (r *protocol) SetChunkHandler(handler ChunkHandler) {
+	r.chunk_handler = handler
+}
+
+func (r *protocol) SetMaxMessageSize(size int) {
+	r.max_message_size = size
+}
+
+func (r *protocol) SetMaxChunkStreams(v int) {
+	r.max_chunk_streams = v
+}
+
+func (r *protocol) SetRecvBufferSize(size int, max_size int) {
+	r.buffer.SetSize(size, max_size)
+}
+
+func (r *protocol) SetOnHandshakeComplete(handler func(remote_addr string, t time.Time)) {
+	r.on_handshake_complete = handler
+}
+
+func (r *protocol) SetContext(v interface {}) {
+	r.user_context_lock.Lock()
+	defer r.user_context_lock.Unlock()
+
+	r.user_context = v
+}
+
+func (r *protocol) Context() (v interface {}) {
+	r.user_context_lock.Lock()
+	defer r.user_context_lock.Unlock()
+
+	return r.user_context
+}
+
+func (r *protocol) SetStrictConnect(v bool) {
+	if v {
+		atomic.StoreInt32(&r.strict_connect_enabled, 1)
+	} else {
+		atomic.StoreInt32(&r.strict_connect_enabled, 0)
+	}
+}
+
+func (r *protocol) strict_connect() (bool) {
+	return atomic.LoadInt32(&r.strict_connect_enabled) != 0
+}
+
+func (r *protocol) SetObjectEncoding(v int) {
+	atomic.StoreInt32(&r.object_encoding, int32(v))
+}
+
+func (r *protocol) ObjectEncoding() (v int) {
+	return int(atomic.LoadInt32(&r.object_encoding))
+}
+
+func (r *protocol) SetOutChunkSize(chunk_size uint32) (err error) {
+	if chunk_size < RTMP_MIN_CHUNK_SIZE || chunk_size > RTMP_MAX_CHUNK_SIZE {
+		return Error{code:ERROR_RTMP_CHUNK_SIZE, desc:fmt.Sprintf("out chunk size %v not in [%v, %v]", chunk_size, RTMP_MIN_CHUNK_SIZE, RTMP_MAX_CHUNK_SIZE)}
+	}
+
+	// the send goroutine apply the outChunkSize after the packet sent.
+	pkt := NewSetChunkSizePacket()
+	pkt.ChunkSize = chunk_size
+	return r.SendPacket(pkt, uint32(0))
+}
+
+func (r *protocol) SetAutoAck(v bool) {
+	if v {
+		atomic.StoreInt32(&r.disable_auto_ack, 0)
+	} else {
+		atomic.StoreInt32(&r.disable_auto_ack, 1)
+	}
+}
+
 func (r *protocol) response_acknowledgement_message() (err error) {
-	// TODO: FIXME: implements it
+	recv_bytes := r.conn.RecvBytes()
+
+	pkt := NewAcknowledgementPacket()
+	pkt.SequenceNumber = uint32(recv_bytes)
+	if err = r.SendPacket(pkt, uint32(0)); err != nil {
+		return
+	}
+
+	if r.logger != nil {
+		r.logger.Debugf("send acknowledgement, sequence=%v, window=%v", pkt.SequenceNumber, r.inAckSize.ack_window_size)
+	}
+
+	r.inAckSize.acked_size = recv_bytes
+	r.stats.on_acked(recv_bytes)
+	return
+}
+
+func (r *protocol) Stats() (v Stats) {
+	v = r.stats.snapshot()
+	v.RecvBytes = r.conn.RecvBytes()
+	v.SendBytes = r.conn.SendBytes()
 	return
 }
 
@@ -761,6 +1389,12 @@ func (r *MessageHeader) IsAmf3Data() (bool) {
 func (r *MessageHeader) IsWindowAcknowledgementSize() (bool) {
 	return r.MessageType == RTMP_MSG_WindowAcknowledgementSize
 }
+func (r *MessageHeader) IsAcknowledgement() (bool) {
+	return r.MessageType == RTMP_MSG_Acknowledgement
+}
+func (r *MessageHeader) IsSetPeerBandwidth() (bool) {
+	return r.MessageType == RTMP_MSG_SetPeerBandwidth
+}
 func (r *MessageHeader) IsSetChunkSize() (bool) {
 	return r.MessageType == RTMP_MSG_SetChunkSize
 }
@@ -776,3 +1410,21 @@ func (r *MessageHeader) IsAudio() (bool) {
 func (r *MessageHeader) IsAggregate() (bool) {
 	return r.MessageType == RTMP_MSG_AggregateMessage
 }
+func (r *MessageHeader) IsAmf0SharedObject() (bool) {
+	return r.MessageType == RTMP_MSG_AMF0SharedObject
+}
+func (r *MessageHeader) IsAmf3SharedObject() (bool) {
+	return r.MessageType == RTMP_MSG_AMF3SharedObject
+}
+// the AMF0 or AMF3 command.
+func (r *MessageHeader) IsCommand() (bool) {
+	return r.IsAmf0Command() || r.IsAmf3Command()
+}
+// the AMF0 or AMF3 data, for example, the metadata.
+func (r *MessageHeader) IsData() (bool) {
+	return r.IsAmf0Data() || r.IsAmf3Data()
+}
+// the protocol control messages, 1-6, include the user control message.
+func (r *MessageHeader) IsProtocolControl() (bool) {
+	return r.MessageType >= RTMP_MSG_SetChunkSize && r.MessageType <= RTMP_MSG_SetPeerBandwidth
+}
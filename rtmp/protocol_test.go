@@ -0,0 +1,1164 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextTransactionId(t *testing.T) {
+	client, server := loopback(t)
+
+	// the connect use the transaction id 1.
+	if err := client.SendPacket(NewConnectAppPacket(), 0); err != nil {
+		t.Fatal(err)
+	}
+	var connect *ConnectAppPacket
+	if _, err := server.ExpectPacket(&connect); err != nil {
+		t.Fatal(err)
+	}
+
+	release := NewReleaseStreamPacket(client.NextTransactionId(), "livestream")
+	fc_publish := NewFCPublishPacket(client.NextTransactionId(), "livestream")
+	call := NewCallPacket()
+	call.CommandName = "custom"
+	if err := client.SendCall(call, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tids := map[float64]bool{1:true}
+	for _, tid := range []float64{release.TransactionId, fc_publish.TransactionId, call.TransactionId} {
+		if tids[tid] {
+			t.Fatalf("transaction id %v collide", tid)
+		}
+		tids[tid] = true
+	}
+}
+
+func TestWindowShrinkAck(t *testing.T) {
+	video := new_av_message(RTMP_MSG_VideoMessage, 0, make([]byte, 1000))
+	ack_size := new_packet_message(t, &SetWindowAckSizePacket{AcknowledgementWindowSize:500})
+	p, _ := new_mock_protocol(encode_chunks(t, video, ack_size))
+
+	// the video is received without window, never ack.
+	mock_recv_message(t, p)
+	if msgs := queued_messages(p); len(msgs) != 0 {
+		t.Fatalf("ack %v messages without window", len(msgs))
+	}
+
+	// the window shrink below the received bytes, ack immediately.
+	mock_recv_message(t, p)
+	msgs := queued_messages(p)
+	if len(msgs) != 1 || !msgs[0].Header.IsAcknowledgement() {
+		t.Fatalf("expect an acknowledgement, got %v messages", len(msgs))
+	}
+	if sequence := NewRtmpStream(msgs[0].Payload).ReadUInt32(); uint64(sequence) != p.conn.RecvBytes() {
+		t.Errorf("ack sequence %v, expect %v", sequence, p.conn.RecvBytes())
+	}
+}
+
+func TestMultipleStreamsTimestamp(t *testing.T) {
+	// the video of two streams over the same cid, the timestamps differ a lot.
+	var msgs []*Message
+	for i := 0; i < 5; i++ {
+		for stream_id, base := range map[uint32]uint64{1:0, 2:90000} {
+			msg := new_av_message(RTMP_MSG_VideoMessage, base + uint64(i) * 40, []byte{0x27, 0x01})
+			msg.Header.StreamId = stream_id
+			msgs = append(msgs, msg)
+		}
+	}
+	expect := make([]MessageHeader, len(msgs))
+	for i, msg := range msgs {
+		expect[i] = *msg.Header
+	}
+
+	p, _ := new_mock_protocol(encode_chunks(t, msgs...))
+	for i := range msgs {
+		msg := mock_recv_message(t, p)
+		if msg.Header.StreamId != expect[i].StreamId || msg.Header.Timestamp != expect[i].Timestamp {
+			t.Errorf("message %v stream=%v timestamp=%v, expect stream=%v timestamp=%v",
+				i, msg.Header.StreamId, msg.Header.Timestamp, expect[i].StreamId, expect[i].Timestamp)
+		}
+	}
+}
+
+func TestPeerBandwidth(t *testing.T) {
+	bw := new_packet_message(t, &SetPeerBandwidthPacket{Bandwidth:2500000, BandwidthType:PeerBandwidthDynamic})
+	p, _ := new_mock_protocol(encode_chunks(t, bw))
+
+	if bandwidth, _ := p.PeerBandwidth(); bandwidth != 0 {
+		t.Errorf("bandwidth %v before received", bandwidth)
+	}
+	mock_recv_message(t, p)
+	if bandwidth, bw_type := p.PeerBandwidth(); bandwidth != 2500000 || bw_type != PeerBandwidthDynamic {
+		t.Errorf("bandwidth=%v type=%v", bandwidth, bw_type)
+	}
+}
+
+func TestMessageDecodeOnce(t *testing.T) {
+	p, _ := new_mock_protocol(encode_chunks(t, new_packet_message(t, NewCreateStreamPacket())))
+	msg := mock_recv_message(t, p)
+
+	// the recv never decode the packet.
+	if msg.decoded {
+		t.Fatal("decoded before Packet called")
+	}
+
+	pkt, err := msg.Packet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pkt.(*CreateStreamPacket); !ok {
+		t.Fatalf("decode %T, expect createStream", pkt)
+	}
+
+	// corrupt the payload, the cached packet is returned without decode.
+	msg.Payload[3] = 'x'
+	if cached, err := msg.Packet(); err != nil || cached != pkt {
+		t.Errorf("decode again, err=%v", err)
+	}
+	if cached, err := p.DecodeMessage(msg); err != nil || cached != pkt {
+		t.Errorf("protocol decode again, err=%v", err)
+	}
+}
+
+func TestResultWithoutRequest(t *testing.T) {
+	// the _result of tid 5, which is not requested by us.
+	res := NewCallResPacket()
+	res.CommandName, res.TransactionId, res.Response = AMF0_COMMAND_RESULT, 5, NewAmf0(float64(1))
+	p, _ := new_mock_protocol(encode_chunks(t, new_packet_message(t, res)))
+	logger := &mock_logger{}
+	p.SetLogger(logger)
+
+	pkt, err := p.DecodeMessage(mock_recv_message(t, p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt, ok := pkt.(*CallResPacket); !ok || pkt.TransactionId != 5 {
+		t.Fatalf("expect _result of tid 5, got %+v", pkt)
+	}
+
+	var logged bool
+	for _, v := range logger.logs {
+		logged = logged || strings.Contains(v, "transaction_id=5")
+	}
+	if !logged {
+		t.Errorf("no log for tid 5, logs %v", logger.logs)
+	}
+}
+
+func TestChunkSizeNeverExceed(t *testing.T) {
+	sizes := []int{1, 127, 128, 129, 255, 256, 257, 1000, 4096}
+	var msgs []*Message
+	for _, size := range sizes {
+		// the zero payload is parsed as fmt0 header when chunk exceed.
+		msgs = append(msgs, new_av_message(RTMP_MSG_VideoMessage, 0, make([]byte, size)))
+	}
+	b := encode_chunks(t, msgs...)
+
+	// parse the chunks in chunk size 128, each chunk of message
+	// must start with the basic header of cid, fmt3 for continuation.
+	const chunk_size = 128
+	for _, size := range sizes {
+		if len(b) == 0 || b[0] & 0x3f != RTMP_CID_Video {
+			t.Fatalf("size %v, expect the first chunk of cid %v", size, RTMP_CID_Video)
+		}
+		b = b[1 + []int{11, 7, 3, 0}[b[0] >> 6]:]
+
+		for left := size; left > 0; {
+			n := left
+			if n > chunk_size {
+				n = chunk_size
+			}
+			if len(b) < n {
+				t.Fatalf("size %v, expect %v bytes, left %v", size, n, len(b))
+			}
+			b, left = b[n:], left - n
+
+			if left > 0 {
+				if len(b) == 0 || b[0] != 0xc0 | RTMP_CID_Video {
+					t.Fatalf("size %v, chunk exceed %v bytes, left %v", size, chunk_size, left)
+				}
+				b = b[1:]
+			}
+		}
+	}
+	if len(b) != 0 {
+		t.Errorf("left %v bytes", len(b))
+	}
+}
+
+func TestRecvTimeout(t *testing.T) {
+	_, server := loopback(t)
+	server.SetRecvTimeout(50 * time.Millisecond)
+
+	// the client never send, the read timeout.
+	starttime := time.Now()
+	_, err := server.RecvMessage()
+	if !IsTimeout(err) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expect timeout, got %v", err)
+	}
+	if elapsed := time.Since(starttime); elapsed > 5 * time.Second {
+		t.Errorf("timeout after %v", elapsed)
+	}
+}
+
+func TestErrorsIsIO(t *testing.T) {
+	client, server := loopback(t)
+
+	// the peer closed, the read failed.
+	client.Close()
+	if _, err := server.RecvMessage(); !errors.Is(err, ErrIO) || errors.Is(err, ErrTimeout) {
+		t.Errorf("expect io error for read, got %v", err)
+	}
+
+	// the peer closed, the write and writev failed.
+	conn, peer := net.Pipe()
+	peer.Close()
+	s := NewSocket(conn)
+	if _, err := s.Write([]byte{0x01}); !errors.Is(err, ErrIO) {
+		t.Errorf("expect io error for write, got %v", err)
+	}
+	if _, err := s.Writev([][]byte{{0x01}, {0x02}}); !errors.Is(err, ErrIO) {
+		t.Errorf("expect io error for writev, got %v", err)
+	}
+}
+
+func TestErrorsIsCategory(t *testing.T) {
+	codec := NewAmf0Codec(NewRtmpStream([]byte{AMF0_Number, 0x00}))
+	if _, err := codec.ReadAny(); !errors.Is(err, ErrAmf0Decode) {
+		t.Errorf("expect amf0 decode error, got %v", err)
+	}
+
+	cases := []struct {
+		code int
+		target error
+	}{
+		{ERROR_SOCKET_TIMEOUT, ErrTimeout},
+		{ERROR_SOCKET_READ, ErrIO},
+		{ERROR_SOCKET_WRITE, ErrIO},
+		{ERROR_SOCKET_CLOSED, ErrIO},
+		{ERROR_RTMP_AMF0_DECODE, ErrAmf0Decode},
+		{ERROR_RTMP_AMF3_DECODE, ErrAmf0Decode},
+		{ERROR_RTMP_MESSAGE_DECODE, ErrAmf0Decode},
+		{ERROR_RTMP_CHUNK_SIZE, ErrChunkSize},
+		{ERROR_RTMP_HANDSHAKE, ErrHandshake},
+		{ERROR_RTMP_CHUNK_START, ErrProtocol},
+		{ERROR_RTMP_MSG_INVLIAD_SIZE, ErrProtocol},
+		{ERROR_RTMP_PACKET_SIZE, ErrProtocol},
+		{ERROR_GO_CHUNK_STREAMS_OVERFLOW, ErrProtocol},
+		{ERROR_GO_BUFFER_OVERFLOW, ErrProtocol},
+	}
+	for _, c := range cases {
+		err := error(Error{code:c.code, desc:"test"})
+		if !errors.Is(err, c.target) {
+			t.Errorf("code=%v expect %v", c.code, c.target)
+		}
+		if !errors.Is(fmt.Errorf("wrap: %w", err), c.target) {
+			t.Errorf("code=%v wrapped expect %v", c.code, c.target)
+		}
+	}
+
+	// the expect exceeded is not the socket timeout, the protocol is ok.
+	if err := error(Error{code:ERROR_GO_EXPECT_EXCEEDED, desc:"test"}); errors.Is(err, ErrTimeout) {
+		t.Errorf("expect exceeded should not be timeout")
+	}
+}
+
+func TestRequestsRemovedByResponse(t *testing.T) {
+	p, _ := new_mock_protocol(nil)
+	p.on_send_request(1, AMF0_COMMAND_CONNECT)
+	p.on_send_request(2, AMF0_COMMAND_CREATE_STREAM)
+	p.on_send_request(3, "getStreamLength")
+
+	decode := func(res Encoder) (pkt interface {}) {
+		h := &MessageHeader{MessageType:RTMP_MSG_AMF0CommandMessage}
+		pkt, err := DecodePacket(p, h, encode_packet(t, res))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// the _result of connect, remove the request.
+	if pkt, ok := decode(NewConnectAppResPacket()).(*ConnectAppResPacket); !ok {
+		t.Errorf("decode %T, expect connect response", pkt)
+	}
+	if len(p.requests) != 2 || p.HistoryRequestName(1) != "" {
+		t.Errorf("requests %v, expect connect removed", p.requests)
+	}
+
+	// the _error of create stream, remove the request.
+	res := NewCallResPacket()
+	res.CommandName = AMF0_COMMAND_ERROR
+	res.TransactionId = 2
+	decode(res)
+	if len(p.requests) != 1 || p.HistoryRequestName(3) != "getStreamLength" {
+		t.Errorf("requests %v, expect only getStreamLength", p.requests)
+	}
+
+	// the response again, no request, decode as call response.
+	if pkt, ok := decode(NewConnectAppResPacket()).(*CallResPacket); !ok {
+		t.Errorf("decode %T, expect call response", pkt)
+	}
+	if len(p.requests) != 1 {
+		t.Errorf("requests %v, expect 1", p.requests)
+	}
+}
+
+func TestSendPacketStreamId(t *testing.T) {
+	client, server := loopback(t)
+
+	pkt := NewOnStatusCallPacket()
+	pkt.Set(SLEVEL, SLEVEL_Status).Set(SCODE, SCODE_StreamStart)
+	if err := server.SendPacket(pkt, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := client.RecvMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.StreamId != 5 || msg.Header.MessageType != RTMP_MSG_AMF0CommandMessage {
+		t.Errorf("stream=%v type=%v", msg.Header.StreamId, msg.Header.MessageType)
+	}
+	if v, err := client.DecodeMessage(msg); err != nil {
+		t.Fatal(err)
+	} else if v, ok := v.(*CallPacket); !ok || v.CommandName != AMF0_COMMAND_ON_STATUS {
+		t.Errorf("expect onStatus, got %+v", v)
+	}
+
+	// the cid and message type of encoder.
+	if cid, msg, err := server.EncodeMessage(pkt); err != nil || cid != RTMP_CID_OverStream || msg.Header.MessageType != RTMP_MSG_AMF0CommandMessage {
+		t.Errorf("cid=%v, err=%v", cid, err)
+	}
+}
+
+func TestRecvMessageContextCancel(t *testing.T) {
+	_, server := loopback(t)
+
+	// the client never send, cancel the blocked read.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50 * time.Millisecond, cancel)
+	if _, err := server.RecvMessageContext(ctx); err != context.Canceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+
+	// the read of recv goroutine is aborted, the input queue is closed.
+	select {
+	case _, ok := <-server.MessageInputChannel():
+		if ok {
+			t.Error("expect closed input queue")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the read is not aborted")
+	}
+}
+
+func TestSendMessageContextCancel(t *testing.T) {
+	_, server := loopback(t)
+	server.SetOutQueuePolicy(1, QueuePolicyBlock)
+
+	// the client never read, the send goroutine block at write, then
+	// the queue is full, cancel the blocked send.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50 * time.Millisecond, cancel)
+	var err error
+	for err == nil {
+		err = server.SendMessageContext(ctx, new_av_message(RTMP_MSG_VideoMessage, 0, make([]byte, 1024)), 1)
+	}
+	if err != context.Canceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+
+	// the write of send goroutine is aborted.
+	select {
+	case <-server.(*protocol).send_done:
+	case <-time.After(5 * time.Second):
+		t.Error("the write is not aborted")
+	}
+}
+
+func TestRecvHeaderByteByByte(t *testing.T) {
+	// the fmt0 header, and the extended timestamp.
+	msgs := []*Message{
+		new_av_message(RTMP_MSG_VideoMessage, 40, []byte{0x17, 0x01, 0x00}),
+		new_av_message(RTMP_MSG_AudioMessage, 0x1000000, []byte{0xaf, 0x01, 0x21}),
+	}
+	p, conn := new_mock_protocol(encode_chunks(t, msgs...))
+	conn.max_read = 1
+
+	for _, expect := range msgs {
+		msg := mock_recv_message(t, p)
+		if msg.Header.MessageType != expect.Header.MessageType || msg.Header.Timestamp != expect.Header.Timestamp {
+			t.Errorf("type=%v timestamp=%v, expect %v", msg.Header.MessageType, msg.Header.Timestamp, expect.Header.Timestamp)
+		}
+		if !bytes.Equal(msg.Payload, expect.Payload) {
+			t.Errorf("payload %x, expect %x", msg.Payload, expect.Payload)
+		}
+	}
+}
+
+func TestRecvBufferCompact(t *testing.T) {
+	// the 1000 bytes message in 128 bytes chunks, the buffer never exceed a chunk.
+	var msgs []*Message
+	for i := 0; i < 100; i++ {
+		msgs = append(msgs, new_av_message(RTMP_MSG_VideoMessage, uint64(i * 40), bytes.Repeat([]byte{byte(i)}, 1000)))
+	}
+	b := encode_chunks(t, msgs...)
+
+	for _, max_read := range []int{1, 100} {
+		p, conn := new_mock_protocol(b)
+		conn.max_read = max_read
+		p.SetRecvBufferSize(256, 4096)
+		array := &p.buffer.buf.buffer.buf[:1][0]
+
+		for _, expect := range msgs {
+			msg := mock_recv_message(t, p)
+			if !bytes.Equal(msg.Payload, expect.Payload) {
+				t.Fatalf("max_read=%v, payload %x, expect %x", max_read, msg.Payload[:8], expect.Payload[:8])
+			}
+		}
+
+		buf := p.buffer.buf.buffer.buf
+		if cap(buf) != 256 || &buf[:1][0] != array {
+			t.Errorf("max_read=%v, buffer is realloc to cap %v", max_read, cap(buf))
+		}
+	}
+}
+
+func TestRecvBufferOverflow(t *testing.T) {
+	// the 4096 bytes chunk exceed the max size of buffer.
+	set_chunk_size := new_packet_message(t, &SetChunkSizePacket{ChunkSize:4096})
+	video := new_av_message(RTMP_MSG_VideoMessage, 0, make([]byte, 4096))
+	p, _ := new_mock_protocol(encode_chunks(t, set_chunk_size, video))
+	p.SetRecvBufferSize(256, 1024)
+	mock_recv_message(t, p)
+
+	var err error
+	for err == nil && len(p.msg_in_queue) == 0 {
+		err = p.do_recv_msg_goroutine_job()
+	}
+	if v, ok := err.(Error); !ok || v.code != ERROR_GO_BUFFER_OVERFLOW {
+		t.Errorf("err=%v, expect buffer overflow", err)
+	}
+}
+
+// stream the video, buffer others, record the chunks.
+type mock_chunk_handler struct {
+	offsets []int
+	payload []byte
+}
+func (r *mock_chunk_handler) OnMessageStart(header *MessageHeader) (bool) {
+	return header.IsVideo()
+}
+func (r *mock_chunk_handler) OnChunk(header *MessageHeader, offset int, payload []byte) (error) {
+	r.offsets = append(r.offsets, offset)
+	r.payload = append(r.payload, payload...)
+	return nil
+}
+
+func TestChunkHandlerIncremental(t *testing.T) {
+	video := new_av_message(RTMP_MSG_VideoMessage, 0, make([]byte, 1000))
+	for i := range video.Payload {
+		video.Payload[i] = byte(i)
+	}
+	audio := new_av_message(RTMP_MSG_AudioMessage, 0, []byte{0xaf, 0x01, 0x21})
+	b := encode_chunks(t, video, audio)
+
+	// only the first 3 chunks arrive, the fmt0 chunk and 2 fmt3 chunks.
+	first := 12 + 128 + 2 * (1 + 128)
+	p, conn := new_mock_protocol(b[:first])
+	handler := &mock_chunk_handler{}
+	p.SetChunkHandler(handler)
+
+	if err := p.do_recv_msg_goroutine_job(); err != nil {
+		t.Fatal(err)
+	}
+	for err := error(nil); err == nil; {
+		err = p.do_recv_msg_goroutine_job()
+	}
+	if !reflect.DeepEqual(handler.offsets, []int{0, 128, 256}) {
+		t.Errorf("offsets %v before the message complete", handler.offsets)
+	}
+	if len(p.msg_in_queue) != 0 {
+		t.Errorf("got %v messages before complete", len(p.msg_in_queue))
+	}
+
+	// the left chunks arrive, the streamed video never got by recv.
+	conn.r.Write(b[first:])
+	msg := mock_recv_message(t, p)
+	if !msg.Header.IsAudio() || !bytes.Equal(msg.Payload, audio.Payload) {
+		t.Errorf("got type=%v payload=%x, expect audio", msg.Header.MessageType, msg.Payload)
+	}
+	if !reflect.DeepEqual(handler.offsets, []int{0, 128, 256, 384, 512, 640, 768, 896}) {
+		t.Errorf("offsets %v", handler.offsets)
+	}
+	if !bytes.Equal(handler.payload, video.Payload) {
+		t.Error("the streamed payload is corrupt")
+	}
+}
+
+func TestDisableAutoAck(t *testing.T) {
+	ack_size := new_packet_message(t, &SetWindowAckSizePacket{AcknowledgementWindowSize:500})
+	video := new_av_message(RTMP_MSG_VideoMessage, 0, make([]byte, 1000))
+	p, _ := new_mock_protocol(encode_chunks(t, ack_size, video, video))
+	p.SetAutoAck(false)
+
+	// the received bytes exceed the window, never ack.
+	for i := 0; i < 3; i++ {
+		mock_recv_message(t, p)
+	}
+	if p.conn.RecvBytes() < 2000 {
+		t.Fatalf("received %v bytes", p.conn.RecvBytes())
+	}
+	if msgs := queued_messages(p); len(msgs) != 0 {
+		t.Errorf("ack %v messages when disabled", len(msgs))
+	}
+}
+
+func TestExtendedTimestamp(t *testing.T) {
+	// 5 hours, exceed the 24bits timestamp which is about 4.66 hours.
+	var ts uint64 = 5 * 3600 * 1000
+	payload := bytes.Repeat([]byte{0x27}, 300)
+	b := encode_chunks(t,
+		new_av_message(RTMP_MSG_VideoMessage, ts, payload),
+		new_av_message(RTMP_MSG_VideoMessage, ts + 40, payload[:10]),
+	)
+
+	ext := []byte{byte(ts >> 24), byte(ts >> 16), byte(ts >> 8), byte(ts)}
+	// fmt0: 1bytes basic header, 11bytes message header, 4bytes extended timestamp.
+	if !bytes.Equal(b[1:4], []byte{0xff, 0xff, 0xff}) || !bytes.Equal(b[12:16], ext) {
+		t.Fatalf("fmt0 header %x", b[:16])
+	}
+	// the fmt3 chunks of message must re-include the extended timestamp.
+	for _, pos := range []int{16 + 128, 16 + 128 + 5 + 128} {
+		if b[pos] != 0xc0 | RTMP_CID_Video || !bytes.Equal(b[pos + 1:pos + 5], ext) {
+			t.Errorf("fmt3 header at %v %x", pos, b[pos:pos + 5])
+		}
+	}
+
+	p, _ := new_mock_protocol(b)
+	for _, want := range []uint64{ts, ts + 40} {
+		msg := mock_recv_message(t, p)
+		if msg.Header.Timestamp != want {
+			t.Errorf("timestamp %v, want %v", msg.Header.Timestamp, want)
+		}
+	}
+}
+
+func TestTimestampDeltaAccumulate(t *testing.T) {
+	b := []byte{
+		// fmt0, cid=4, timestamp=1000, length=2, video, stream_id=1
+		0x04, 0x00, 0x03, 0xe8, 0x00, 0x00, 0x02, 0x09, 0x01, 0x00, 0x00, 0x00, 0x27, 0x01,
+		// fmt1, delta=40, length=2, video
+		0x44, 0x00, 0x00, 0x28, 0x00, 0x00, 0x02, 0x09, 0x27, 0x01,
+		// fmt2, delta=33
+		0x84, 0x00, 0x00, 0x21, 0x27, 0x01,
+		// fmt3, the new message reuse the delta=33
+		0xc4, 0x27, 0x01,
+		0xc4, 0x27, 0x01,
+	}
+
+	p, _ := new_mock_protocol(b)
+	var last uint64
+	for i, want := range []uint64{1000, 1040, 1073, 1106, 1139} {
+		msg := mock_recv_message(t, p)
+		if msg.Header.Timestamp != want {
+			t.Errorf("#%v timestamp %v, want %v", i, msg.Header.Timestamp, want)
+		}
+		if i > 0 && msg.Header.Timestamp <= last {
+			t.Errorf("#%v timestamp %v not increase from %v", i, msg.Header.Timestamp, last)
+		}
+		if msg.Header.StreamId != 1 {
+			t.Errorf("#%v stream_id %v, want 1", i, msg.Header.StreamId)
+		}
+		last = msg.Header.Timestamp
+	}
+}
+
+func TestSetOutChunkSizeOrder(t *testing.T) {
+	c, sc := net.Pipe()
+	v, _ := NewProtocol(sc)
+	p := v.(*protocol)
+	p.start_message_pump_goroutines()
+
+	raw := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(c)
+		raw <- b
+	}()
+
+	small := new_av_message(RTMP_MSG_VideoMessage, 0, bytes.Repeat([]byte{0x27}, 300))
+	large := new_av_message(RTMP_MSG_VideoMessage, 40, bytes.Repeat([]byte{0x27}, 3000))
+	if err := p.SendMessage(small, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetOutChunkSize(4096); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendMessage(large, 1); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	b := <-raw
+
+	// the Set Chunk Size, fmt0 of cid 2, length 4, type 1, stream 0, size 4096.
+	set_chunk_size := []byte("\x02\x00\x00\x00\x00\x00\x04\x01\x00\x00\x00\x00\x00\x00\x10\x00")
+	index := bytes.Index(b, set_chunk_size)
+	if index < 0 {
+		t.Fatalf("no Set Chunk Size in %v bytes", len(b))
+	}
+	// the small message in 128bytes chunks: fmt0 header then 2 fmt3 chunks.
+	if index != 12 + 300 + 2 {
+		t.Errorf("Set Chunk Size at %v, expect after the small message", index)
+	}
+
+	// the large message in one chunk, fmt1 header then the whole payload.
+	b = b[index + len(set_chunk_size):]
+	if len(b) != 8 + len(large.Payload) || b[0] != RTMP_FMT_TYPE1 << 6 | RTMP_CID_Video {
+		t.Fatalf("large message is %v bytes, basic header %#x", len(b), b[0])
+	}
+	if !bytes.Equal(b[8:], large.Payload) {
+		t.Error("large message is not in one chunk")
+	}
+}
+
+func TestSendChunkFormat(t *testing.T) {
+	stream2 := new_av_message(RTMP_MSG_VideoMessage, 1080, []byte{0x27, 0x01, 0x02})
+	stream2.Header.StreamId = 2
+	cases := []struct {
+		msg *Message
+		fmt byte
+	}{
+		// the first message of cid.
+		{new_av_message(RTMP_MSG_VideoMessage, 1000, []byte{0x17, 0x01}), RTMP_FMT_TYPE0},
+		// the same stream, length changed.
+		{new_av_message(RTMP_MSG_VideoMessage, 1040, []byte{0x27, 0x01, 0x00}), RTMP_FMT_TYPE1},
+		// the same stream, length and type.
+		{new_av_message(RTMP_MSG_VideoMessage, 1080, []byte{0x27, 0x01, 0x01}), RTMP_FMT_TYPE2},
+		{new_av_message(RTMP_MSG_VideoMessage, 1120, []byte{0x27, 0x01, 0x02}), RTMP_FMT_TYPE2},
+		// the stream changed.
+		{stream2, RTMP_FMT_TYPE0},
+		// the timestamp jump backwards.
+		{new_av_message(RTMP_MSG_VideoMessage, 500, []byte{0x27, 0x01, 0x02}), RTMP_FMT_TYPE0},
+	}
+
+	p, conn := new_mock_protocol(nil)
+	for i, c := range cases {
+		conn.w.Reset()
+		if err := p.do_send_msg_goroutine_job(c.msg); err != nil {
+			t.Fatal(err)
+		}
+		if b := conn.w.Bytes(); b[0] != c.fmt << 6 | RTMP_CID_Video {
+			t.Errorf("#%v basic header %#x, want fmt%v", i, b[0], c.fmt)
+		}
+	}
+
+	// the continuation chunks always use fmt3.
+	conn.w.Reset()
+	if err := p.do_send_msg_goroutine_job(new_av_message(RTMP_MSG_VideoMessage, 540, bytes.Repeat([]byte{0x27}, 200))); err != nil {
+		t.Fatal(err)
+	}
+	b := conn.w.Bytes()
+	// fmt1, 1bytes basic header, 7bytes message header.
+	if b[0] != RTMP_FMT_TYPE1 << 6 | RTMP_CID_Video || b[8 + 128] != RTMP_FMT_TYPE3 << 6 | RTMP_CID_Video {
+		t.Errorf("basic header %#x and %#x, want fmt1 and fmt3", b[0], b[8 + 128])
+	}
+
+	// the receiver decode the same timestamps.
+	r, _ := new_mock_protocol(encode_chunks(t, cases[0].msg, cases[1].msg, cases[2].msg, cases[3].msg))
+	for _, want := range []uint64{1000, 1040, 1080, 1120} {
+		if msg := mock_recv_message(t, r); msg.Header.Timestamp != want {
+			t.Errorf("timestamp %v, want %v", msg.Header.Timestamp, want)
+		}
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	// the 24bits payload length is at most 16MB, the 100MB only from FLV or chunk handler.
+	if _, err := safe_payload_size(100 * 1024 * 1024, RTMP_DEFAULT_MAX_MESSAGE_SIZE); err == nil {
+		t.Error("100MB message should fail")
+	}
+
+	// fmt0, cid=3, the max payload length 0xffffff, without payload.
+	huge := []byte{0x03, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0x09, 0x01, 0x00, 0x00, 0x00}
+	p, _ := new_mock_protocol(huge)
+	var err error
+	for err == nil && len(p.msg_in_queue) == 0 {
+		err = p.do_recv_msg_goroutine_job()
+	}
+	if v, ok := err.(Error); !ok || v.code != ERROR_RTMP_MESSAGE_DECODE {
+		t.Errorf("huge message got %v, want message decode error", err)
+	}
+
+	// the max message size is per protocol.
+	b := encode_chunks(t, new_av_message(RTMP_MSG_VideoMessage, 0, make([]byte, 2048)))
+	p, _ = new_mock_protocol(b)
+	if msg := mock_recv_message(t, p); len(msg.Payload) != 2048 {
+		t.Errorf("got %v bytes, want 2048", len(msg.Payload))
+	}
+
+	p, _ = new_mock_protocol(b)
+	p.SetMaxMessageSize(1024)
+	for err = nil; err == nil && len(p.msg_in_queue) == 0; {
+		err = p.do_recv_msg_goroutine_job()
+	}
+	if v, ok := err.(Error); !ok || v.code != ERROR_RTMP_MESSAGE_DECODE {
+		t.Errorf("2KB message got %v, want message decode error", err)
+	}
+}
+
+func TestChunkStreamIdExtended(t *testing.T) {
+	// fmt0, timestamp=1000, length=2, video, stream_id=1, after the basic header.
+	const header = "\x00\x03\xe8\x00\x00\x02\x09\x01\x00\x00\x00\x27\x01"
+	for _, c := range []struct {
+		cid int
+		basic_header string
+	}{
+		{64, "\x00\x00"},
+		{319, "\x00\xff"},
+		{320, "\x01\x00\x01"},
+		{65599, "\x01\xff\xff"},
+	} {
+		p, _ := new_mock_protocol([]byte(c.basic_header + header))
+		msg := mock_recv_message(t, p)
+		if msg.Header.Timestamp != 1000 || msg.Header.StreamId != 1 || !bytes.Equal(msg.Payload, []byte{0x27, 0x01}) {
+			t.Errorf("cid=%v got %+v", c.cid, msg.Header)
+		}
+		if _, ok := p.chunkStreams[c.cid]; !ok {
+			t.Errorf("cid=%v not parsed", c.cid)
+		}
+	}
+}
+
+func TestMaxChunkStreams(t *testing.T) {
+	var b []byte
+	for cid := 3; cid < 6; cid++ {
+		msg := new_av_message(RTMP_MSG_VideoMessage, 0, []byte{0x27, 0x01})
+		msg.PerferCid = cid
+		b = append(b, encode_chunks(t, msg)...)
+	}
+
+	p, _ := new_mock_protocol(b)
+	p.SetMaxChunkStreams(2)
+	mock_recv_message(t, p)
+	mock_recv_message(t, p)
+
+	err := p.do_recv_msg_goroutine_job()
+	if v, ok := err.(Error); !ok || v.code != ERROR_GO_CHUNK_STREAMS_OVERFLOW {
+		t.Errorf("the third chunk stream got %v, want overflow", err)
+	}
+	if len(p.chunkStreams) != 2 {
+		t.Errorf("got %v chunk streams, want 2", len(p.chunkStreams))
+	}
+}
+
+func TestBasicHeaderRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		cid int
+		size int
+	}{
+		// 1byte form, cid 2-63.
+		{2, 1}, {63, 1},
+		// 2bytes form, cid 64-319.
+		{64, 2}, {200, 2}, {319, 2},
+		// 3bytes form, cid 320-65599.
+		{320, 3}, {1000, 3}, {65599, 3},
+	} {
+		// write and read the basic header of all fmt.
+		for format := byte(0); format < 4; format++ {
+			b := NewRtmpStream(make([]byte, 3))
+			write_basic_header(b, format, c.cid)
+			if v := b.WrittenBytes(); len(v) != c.size {
+				t.Errorf("cid=%v fmt=%v basic header %x, want %v bytes", c.cid, format, v, c.size)
+			}
+		}
+
+		// the continuation chunk use fmt3 with the same basic header.
+		msg := new_av_message(RTMP_MSG_VideoMessage, 1000, bytes.Repeat([]byte{0x27}, 200))
+		msg.PerferCid = c.cid
+		b := encode_chunks(t, msg)
+		pos := c.size + 11 + 128
+		if b[pos] != RTMP_FMT_TYPE3 << 6 | b[0] & 0x3f || !bytes.Equal(b[1:c.size], b[pos + 1:pos + c.size]) {
+			t.Errorf("cid=%v fmt3 basic header %x, fmt0 %x", c.cid, b[pos:pos + c.size], b[:c.size])
+		}
+
+		p, _ := new_mock_protocol(b)
+		v := mock_recv_message(t, p)
+		if v.Header.Timestamp != 1000 || !bytes.Equal(v.Payload, msg.Payload) {
+			t.Errorf("cid=%v got %+v", c.cid, v.Header)
+		}
+		if _, ok := p.chunkStreams[c.cid]; !ok || len(p.chunkStreams) != 1 {
+			t.Errorf("cid=%v not parsed", c.cid)
+		}
+	}
+}
+
+func TestProtocolContext(t *testing.T) {
+	p, _ := new_mock_protocol(nil)
+	if v := p.Context(); v != nil {
+		t.Errorf("context %v, want nil", v)
+	}
+
+	type auth struct {
+		user string
+	}
+	p.SetContext(&auth{user:"winlin"})
+	if v, ok := p.Context().(*auth); !ok || v.user != "winlin" {
+		t.Errorf("context %v, want the auth", p.Context())
+	}
+
+	// set and get in other goroutines.
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		p.SetContext("stream")
+	}()
+	<-done
+	if v := p.Context(); v != "stream" {
+		t.Errorf("context %v, want stream", v)
+	}
+
+	p.SetContext(nil)
+	if v := p.Context(); v != nil {
+		t.Errorf("context %v, want nil", v)
+	}
+}
+
+func TestConcurrentSendMessage(t *testing.T) {
+	client, server := loopback(t)
+
+	// the goroutines send the messages over the same cid, with the chunk size changed.
+	const senders, messages = 8, 50
+	for i := 0; i < senders; i++ {
+		go func(i int) {
+			for j := 0; j < messages; j++ {
+				payload := bytes.Repeat([]byte{byte(i)}, 300 + j)
+				msg := new_av_message(RTMP_MSG_VideoMessage, uint64(j * 40), payload)
+				msg.Header.StreamId = uint32(i + 1)
+				if err := client.SendMessage(msg, 0); err != nil {
+					t.Error(err)
+					return
+				}
+				if j == messages / 2 {
+					if err := client.SetOutChunkSize(uint32(128 + i)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+
+	// each stream receive the messages in order, the chunks never interlace.
+	next := make([]int, senders)
+	for n := 0; n < senders * messages; {
+		msg, err := server.RecvMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !msg.Header.IsVideo() {
+			continue
+		}
+		n++
+
+		i := int(msg.Header.StreamId) - 1
+		j := next[i]
+		next[i]++
+		if want := bytes.Repeat([]byte{byte(i)}, 300 + j); !bytes.Equal(msg.Payload, want) || msg.Header.Timestamp != uint64(j * 40) {
+			t.Fatalf("stream %v message #%v corrupt, ts=%v, size=%v", i + 1, j, msg.Header.Timestamp, len(msg.Payload))
+		}
+	}
+}
+
+func TestSafePayloadSize(t *testing.T) {
+	var length uint32 = math.MaxUint32 - 1
+
+	// the length near uint32 max is rejected by the max message size.
+	if size, err := safe_payload_size(length, RTMP_DEFAULT_MAX_MESSAGE_SIZE); err == nil || size != 0 {
+		t.Errorf("size=%v, err=%v, want error", size, err)
+	}
+
+	// without the max, it's ok only when the int is 64bits.
+	size, err := safe_payload_size(length, 0)
+	if strconv.IntSize == 32 && (err == nil || size != 0) {
+		t.Errorf("size=%v, err=%v, want overflow error", size, err)
+	}
+	if strconv.IntSize == 64 && (err != nil || uint32(size) != length) {
+		t.Errorf("size=%v, err=%v, want %v", size, err, length)
+	}
+
+	if size, err := safe_payload_size(4096, RTMP_DEFAULT_MAX_MESSAGE_SIZE); err != nil || size != 4096 {
+		t.Errorf("size=%v, err=%v, want 4096", size, err)
+	}
+}
+
+func BenchmarkRecvMessage(b *testing.B) {
+	// the first message is fmt0, the next messages with the same header are fmt2.
+	msg := new_av_message(RTMP_MSG_VideoMessage, 0, bytes.Repeat([]byte{0x27}, 4096))
+	first := encode_chunks(b, msg)
+	next := encode_chunks(b, msg, msg.Copy())[len(first):]
+
+	for _, release := range []bool{false, true} {
+		name := "NoRelease"
+		if release {
+			name = "Release"
+		}
+		b.Run(name, func(b *testing.B) {
+			p, conn := new_mock_protocol(first)
+			conn.r.Grow(len(next))
+			b.ReportAllocs()
+			b.SetBytes(int64(len(next)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if i > 0 {
+					conn.r.Write(next)
+				}
+				for len(p.msg_in_queue) == 0 {
+					if err := p.do_recv_msg_goroutine_job(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if msg := <-p.msg_in_queue; release {
+					msg.Release()
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRecvByteAtATime(b *testing.B) {
+	// the peer send bytes slowly, the buffer compacts rather than reallocs.
+	msg := new_av_message(RTMP_MSG_VideoMessage, 0, bytes.Repeat([]byte{0x27}, 1000))
+	first := encode_chunks(b, msg)
+	next := encode_chunks(b, msg, msg.Copy())[len(first):]
+
+	p, conn := new_mock_protocol(first)
+	conn.max_read = 1
+	conn.r.Grow(len(next))
+	p.SetRecvBufferSize(256, 4096)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(next)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if i > 0 {
+			conn.r.Write(next)
+		}
+		for len(p.msg_in_queue) == 0 {
+			if err := p.do_recv_msg_goroutine_job(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		(<-p.msg_in_queue).Release()
+	}
+}
+
+func BenchmarkSendSharedMessage(b *testing.B) {
+	// the players over net.Pipe, the peer discard all bytes.
+	const players = 1000
+	var protocols []*protocol
+	for i := 0; i < players; i++ {
+		c, s := net.Pipe()
+		go io.Copy(io.Discard, c)
+		v, _ := NewProtocol(s)
+		p := v.(*protocol)
+		p.start_message_pump_goroutines()
+		if err := p.SetOutChunkSize(4096); err != nil {
+			b.Fatal(err)
+		}
+		protocols = append(protocols, p)
+	}
+
+	// the video frame is encoded once and shared by all players.
+	msg := new_av_message(RTMP_MSG_VideoMessage, 0, bytes.Repeat([]byte{0x27}, 16 * 1024))
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg.Payload) * players))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		msg.Header.Timestamp = uint64(i * 40)
+		for _, p := range protocols {
+			if err := p.SendSharedMessage(msg, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	// flush the queued messages to all players.
+	for _, p := range protocols {
+		p.Close()
+	}
+}
+
+// send the 256KB video message at chunk size 4096.
+func new_chunk_loop_protocol(tb testing.TB) (*protocol, *mock_conn, *Message) {
+	tb.Helper()
+
+	p, conn := new_mock_protocol(nil)
+	if err := p.do_send_msg_goroutine_job(new_packet_message(tb, &SetChunkSizePacket{ChunkSize:4096})); err != nil {
+		tb.Fatal(err)
+	}
+	msg := new_av_message(RTMP_MSG_VideoMessage, 0, bytes.Repeat([]byte{0x27}, 256 * 1024))
+
+	// the first message alloc the header and iovecs cache.
+	if err := p.do_send_msg_goroutine_job(msg); err != nil {
+		tb.Fatal(err)
+	}
+	return p, conn, msg
+}
+
+func TestChunkLoopAllocs(t *testing.T) {
+	p, conn, msg := new_chunk_loop_protocol(t)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		conn.w.Reset()
+		msg.Header.Timestamp += 40
+		if err := p.do_send_msg_goroutine_job(msg); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocs per message of %v chunks, want 0", allocs, len(msg.Payload) / 4096)
+	}
+}
+
+func BenchmarkChunkLoop(b *testing.B) {
+	p, conn, msg := new_chunk_loop_protocol(b)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(msg.Payload)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		conn.w.Reset()
+		msg.Header.Timestamp += 40
+		if err := p.do_send_msg_goroutine_job(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExpectPacketLimit(t *testing.T) {
+	client, server := loopback(t)
+	for i := 0; i < 5; i++ {
+		if i == 4 {
+			client.SendPacket(NewConnectAppPacket(), 0)
+		} else {
+			client.SendPacket(NewCreateStreamPacket(), 0)
+		}
+	}
+
+	// give up after 3 wrong messages.
+	var pkt *ConnectAppPacket
+	msg, dropped, err := server.ExpectPacketLimit(&pkt, 0, 3)
+	if v, ok := err.(Error); !ok || v.code != ERROR_GO_EXPECT_EXCEEDED || msg != nil || dropped != 3 {
+		t.Errorf("got msg=%v, dropped=%v, err=%v, want exceeded after 3 drops", msg, dropped, err)
+	}
+
+	// the expected packet after 1 wrong message.
+	if msg, dropped, err = server.ExpectPacketLimit(&pkt, 0, 3); err != nil || msg == nil || pkt == nil || dropped != 1 {
+		t.Errorf("got msg=%v, dropped=%v, err=%v, want connect after 1 drop", msg, dropped, err)
+	}
+
+	// give up when timeout.
+	client, server = loopback(t)
+	start := time.Now()
+	if _, _, err = server.ExpectPacketLimit(&pkt, 50 * time.Millisecond, 0); err == nil {
+		t.Fatal("want timeout")
+	}
+	if v, ok := err.(Error); !ok || v.code != ERROR_GO_EXPECT_EXCEEDED {
+		t.Errorf("got %v, want exceeded", err)
+	}
+	if d := time.Since(start); d < 50 * time.Millisecond || d > 3 * time.Second {
+		t.Errorf("timeout after %v", d)
+	}
+
+	// the protocol is ok after timeout.
+	if err = client.SendPacket(NewConnectAppPacket(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err = server.RecvMessage(); err != nil || msg.Header.MessageType != RTMP_MSG_AMF0CommandMessage {
+		t.Errorf("recv after timeout got msg=%v, err=%v", msg, err)
+	}
+}
+
+func TestSendVerbatimMessage(t *testing.T) {
+	// the encoder send fmt0 for all messages, which is different from our encoder.
+	var b []byte
+	for i := 0; i < 3; i++ {
+		payload := bytes.Repeat([]byte{byte(i)}, 300)
+		b = append(b, 0x06, 0x00, 0x00, byte(i * 40), 0x00, 0x01, 0x2c, 0x09, 0x01, 0x00, 0x00, 0x00)
+		b = append(b, payload[:128]...)
+		b = append(b, 0xc6)
+		b = append(b, payload[128:256]...)
+		b = append(b, 0xc6)
+		b = append(b, payload[256:]...)
+	}
+
+	in, _ := new_mock_protocol(b)
+	var msgs []*Message
+	for i := 0; i < 3; i++ {
+		msgs = append(msgs, mock_recv_message(t, in))
+	}
+
+	// forward at the same chunk size, the bytes are exactly the same.
+	send := func(verbatim bool) ([]byte) {
+		out, conn := new_mock_protocol(nil)
+		for _, msg := range msgs {
+			var err error
+			if verbatim {
+				err = out.SendVerbatimMessage(msg, 0)
+			} else {
+				err = out.SendSharedMessage(msg, 0)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, msg := range queued_messages(out) {
+			if err := out.do_send_msg_goroutine_job(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return conn.w.Bytes()
+	}
+	if v := send(true); !bytes.Equal(v, b) {
+		t.Errorf("verbatim forward %v bytes not exact, want %v bytes", len(v), len(b))
+	}
+	// the normal send use fmt2 for the later messages.
+	if v := send(false); bytes.Equal(v, b) || len(v) >= len(b) {
+		t.Errorf("normal send %v bytes should use smaller header than %v bytes", len(v), len(b))
+	}
+}
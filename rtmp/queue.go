@@ -0,0 +1,269 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"context"
+	"sync"
+	"fmt"
+)
+
+/**
+* the policy when the message queue is full.
+*/
+const (
+	// block the sender util the queue is writable, the default policy.
+	QueuePolicyBlock = iota
+	// drop the audio/video messages before the last video keyframe,
+	// for the slow client to skip to the latest gop, the sequence headers
+	// are kept, and error when the queue is full of control messages.
+	QueuePolicyDropToKeyframe
+	// return error, the user should disconnect the slow client.
+	QueuePolicyDisconnect
+)
+
+/**
+* the bounded message queue, for the output messages.
+* the fan-out server dispatch message to each connection,
+* the queue of slow client is full, use the policy to avoid OOM.
+* @remark the queue is goroutine safe.
+*/
+type message_queue struct {
+	lock *sync.Mutex
+	msgs []*Message
+	// the max messages in queue.
+	max_messages int
+	// the policy when queue is full.
+	policy int
+	// whether the queue is closed, never push or pop.
+	closed bool
+	// whether the queue is draining, never push, pop util empty.
+	draining bool
+	// whether all audio/video are dropped, drop the pushed util keyframe.
+	wait_keyframe bool
+	// the signal of queue not empty, or closed.
+	readable chan bool
+	// the signal of queue not full, or closed.
+	writable chan bool
+}
+func new_message_queue(max_messages int) (*message_queue) {
+	r := &message_queue{}
+	r.lock = &sync.Mutex{}
+	r.max_messages = max_messages
+	r.policy = QueuePolicyBlock
+	r.readable = make(chan bool, 1)
+	r.writable = make(chan bool, 1)
+	return r
+}
+
+// set the max messages and policy, <=0 max_messages to ignore.
+func (r *message_queue) set_policy(max_messages int, policy int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if max_messages > 0 {
+		r.max_messages = max_messages
+	}
+	r.policy = policy
+	r.wait_keyframe = false
+
+	// the queue maybe writable when max changed.
+	if !r.closed {
+		signal(r.writable)
+	}
+}
+
+/**
+* push the message to queue, when full, use the policy.
+* @param ctx to cancel the push when block for queue is full.
+* @return error when closed, or full with disconnect policy,
+* 		or the ctx.Err() when ctx is done.
+*/
+func (r *message_queue) push(ctx context.Context, msg *Message) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// the gop is dropped, the media is useless util the next keyframe.
+	if !r.closed && !r.draining && r.wait_keyframe && is_droppable(msg) && !is_video_keyframe(msg) {
+		return
+	}
+
+	for !r.closed && !r.draining && len(r.msgs) >= r.max_messages {
+		switch r.policy {
+		case QueuePolicyDisconnect:
+			return Error{code:ERROR_GO_QUEUE_OVERFLOW, desc:fmt.Sprintf("message queue overflow, max=%v", r.max_messages)}
+		case QueuePolicyDropToKeyframe:
+			// drop all audio/video when the keyframe is too old.
+			if r.shrink(true); len(r.msgs) >= r.max_messages {
+				r.shrink(false)
+			}
+			// the queue is full of control messages and sequence headers.
+			if len(r.msgs) >= r.max_messages {
+				return Error{code:ERROR_GO_QUEUE_OVERFLOW, desc:fmt.Sprintf("message queue overflow, max=%v, no media to drop", r.max_messages)}
+			}
+			if r.wait_keyframe && is_droppable(msg) && !is_video_keyframe(msg) {
+				return
+			}
+		default:
+			// wait for writable, unlock to allow the pop.
+			r.lock.Unlock()
+			select {
+			case <- r.writable:
+			case <- ctx.Done():
+				r.lock.Lock()
+				return ctx.Err()
+			}
+			r.lock.Lock()
+		}
+	}
+
+	if r.closed || r.draining {
+		return Error{code:ERROR_GO_PROTOCOL_DESTROYED, desc:"message queue closed"}
+	}
+
+	if is_video_keyframe(msg) {
+		r.wait_keyframe = false
+	}
+	r.msgs = append(r.msgs, msg)
+	signal(r.readable)
+	return
+}
+
+/**
+* pop the message from queue, block util queue is not empty.
+* @return ok is false when queue closed, or drained.
+*/
+func (r *message_queue) pop() (msg *Message, ok bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for !r.closed && !r.draining && len(r.msgs) == 0 {
+		// wait for readable, unlock to allow the push.
+		r.lock.Unlock()
+		<- r.readable
+		r.lock.Lock()
+	}
+
+	if r.closed || len(r.msgs) == 0 {
+		return nil, false
+	}
+
+	msg = r.msgs[0]
+	r.msgs[0] = nil
+	r.msgs = r.msgs[1:]
+
+	signal(r.writable)
+	if len(r.msgs) > 0 {
+		signal(r.readable)
+	}
+	return msg, true
+}
+
+// close the queue, notify all waiting goroutines.
+func (r *message_queue) close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.closed = true
+
+	close(r.readable)
+	close(r.writable)
+}
+
+// drain the queue, reject the push, the pop return false when empty.
+func (r *message_queue) drain() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed || r.draining {
+		return
+	}
+	r.draining = true
+
+	// notify the waiting pop and push.
+	signal(r.readable)
+	signal(r.writable)
+}
+
+// the messages in queue.
+func (r *message_queue) size() (int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return len(r.msgs)
+}
+
+/**
+* drop the audio/video messages before the last video keyframe,
+* drop all audio/video messages if no keyframe or not to_keyframe,
+* then wait for the next keyframe, @see wait_keyframe.
+* the other messages, for instance, the commands and the sequence
+* headers, are never dropped.
+* @remark must be called in lock.
+*/
+func (r *message_queue) shrink(to_keyframe bool) {
+	iframe := -1
+	for i := len(r.msgs) - 1; to_keyframe && i >= 0; i-- {
+		if is_video_keyframe(r.msgs[i]) {
+			iframe = i
+			break
+		}
+	}
+	r.wait_keyframe = iframe < 0
+
+	msgs := make([]*Message, 0, len(r.msgs))
+	for i, msg := range r.msgs {
+		if is_droppable(msg) && (iframe < 0 || i < iframe) {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	r.msgs = msgs
+}
+
+// whether the message is audio/video which can be dropped, except the sequence headers.
+func is_droppable(msg *Message) (bool) {
+	if !msg.Header.IsAudio() && !msg.Header.IsVideo() {
+		return false
+	}
+	return !is_video_sequence_header(msg) && !is_audio_sequence_header(msg)
+}
+
+// whether the message is video keyframe, by the FLV video tag header,
+// the AVC sequence header is not keyframe, @see is_video_sequence_header.
+func is_video_keyframe(msg *Message) (bool) {
+	if !msg.Header.IsVideo() || len(msg.Payload) < 1 {
+		return false
+	}
+	return (msg.Payload[0] >> 4) & 0x0f == CodecVideoAVCFrameKeyFrame && !is_video_sequence_header(msg)
+}
+
+// notify the signal chan, ignore when already notified.
+func signal(c chan bool) {
+	select {
+	case c <- true:
+	default:
+	}
+}
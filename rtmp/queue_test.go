@@ -0,0 +1,103 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQueueDropToKeyframe(t *testing.T) {
+	vsh := new_av_message(RTMP_MSG_VideoMessage, 0, []byte{0x17, 0x00})
+	ash := new_av_message(RTMP_MSG_AudioMessage, 0, []byte{0xaf, 0x00})
+	new_frame := func(name string, payload ...byte) (*Message) {
+		msg := new_av_message(RTMP_MSG_VideoMessage, 0, payload)
+		if payload[0] == 0xaf {
+			msg = new_av_message(RTMP_MSG_AudioMessage, 0, payload)
+		}
+		msg.Payload = append(msg.Payload, name...)
+		return msg
+	}
+	k1, p1, k2, p2, p3 := new_frame("k1", 0x17, 0x01), new_frame("p1", 0x27, 0x01), new_frame("k2", 0x17, 0x01), new_frame("p2", 0x27, 0x01), new_frame("p3", 0x27, 0x01)
+
+	q := new_message_queue(5)
+	q.set_policy(0, QueuePolicyDropToKeyframe)
+	push := func(msgs ...*Message) {
+		t.Helper()
+		for _, msg := range msgs {
+			if err := q.push(context.Background(), msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expect := func(msgs ...*Message) {
+		t.Helper()
+		if len(q.msgs) != len(msgs) {
+			t.Fatalf("expect %v messages, got %v", len(msgs), len(q.msgs))
+		}
+		for i, msg := range msgs {
+			if q.msgs[i] != msg {
+				t.Errorf("message #%v is %q, expect %q", i, q.msgs[i].Payload, msg.Payload)
+			}
+		}
+	}
+
+	// drop to the newest keyframe, keep the sequence headers.
+	push(vsh, ash, k1, p1, k2, p2)
+	expect(vsh, ash, k2, p2)
+
+	// the gop is too large, drop all media, reject util next keyframe.
+	push(p3, new_frame("p4", 0x27, 0x01))
+	expect(vsh, ash)
+	ctl := new_packet_message(t, &SetChunkSizePacket{ChunkSize:4096})
+	push(new_frame("a1", 0xaf, 0x01), new_frame("p5", 0x27, 0x01), ctl)
+	expect(vsh, ash, ctl)
+
+	k3, p6 := new_frame("k3", 0x17, 0x01), new_frame("p6", 0x27, 0x01)
+	push(k3, p6)
+	expect(vsh, ash, ctl, k3, p6)
+}
+
+func TestQueueControlOverflow(t *testing.T) {
+	q := new_message_queue(2)
+	q.set_policy(0, QueuePolicyDropToKeyframe)
+
+	// the control messages are never dropped, but count to max.
+	for i := 0; i < 3; i++ {
+		err := q.push(context.Background(), new_packet_message(t, &SetChunkSizePacket{ChunkSize:4096}))
+		if i < 2 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 && err == nil {
+			t.Errorf("expect overflow, got %v messages", q.size())
+		}
+	}
+}
+
+func TestKeyframeNotSequenceHeader(t *testing.T) {
+	if is_video_keyframe(new_av_message(RTMP_MSG_VideoMessage, 0, []byte{0x17, 0x00})) {
+		t.Error("the sequence header is not keyframe")
+	}
+	if !is_video_keyframe(new_av_message(RTMP_MSG_VideoMessage, 0, []byte{0x17, 0x01})) {
+		t.Error("expect keyframe")
+	}
+}
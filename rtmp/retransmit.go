@@ -0,0 +1,106 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"sync"
+)
+
+/**
+* the ring buffer of recently sent messages, keyed by the sequence,
+* for the relay over flaky link, the recovery scheme can resync
+* from the last acked sequence, to replay the last few frames.
+* the sequence starts from 1, increase by 1 for each message.
+*/
+type RetransmitBuffer struct {
+	lock *sync.Mutex
+	// the ring of messages, the msgs[seq % len(msgs)] is the seq.
+	msgs []*Message
+	// the sequence of the next pushed message.
+	next_seq uint64
+}
+/**
+* create the retransmit buffer.
+* @param capacity the max messages to buffer, the oldest is overwritten.
+*/
+func NewRetransmitBuffer(capacity int) (*RetransmitBuffer) {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	r := &RetransmitBuffer{}
+	r.lock = &sync.Mutex{}
+	r.msgs = make([]*Message, capacity)
+	r.next_seq = 1
+	return r
+}
+
+/**
+* buffer the sent message, return the sequence of it.
+* @remark the message is shared, never modify it after pushed.
+*/
+func (r *RetransmitBuffer) Push(msg *Message) (seq uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	seq = r.next_seq
+	r.next_seq++
+	r.msgs[seq % uint64(len(r.msgs))] = msg
+	return
+}
+
+/**
+* get the buffered messages from seq, in order of sequence,
+* the messages before the oldest buffered one are lost.
+*/
+func (r *RetransmitBuffer) Messages(seq uint64) (msgs []*Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// the oldest sequence in buffer.
+	oldest := uint64(1)
+	if r.next_seq > uint64(len(r.msgs)) {
+		oldest = r.next_seq - uint64(len(r.msgs))
+	}
+	if seq < oldest {
+		seq = oldest
+	}
+
+	for ; seq < r.next_seq; seq++ {
+		msgs = append(msgs, r.msgs[seq % uint64(len(r.msgs))])
+	}
+	return
+}
+
+/**
+* replay the buffered messages from seq, send to the stream id of message.
+* the buffered message is shared, which maybe already sent or replayed,
+* so it's sent by SendSharedMessage and never changed.
+* @param protocol the protocol to resend the messages.
+*/
+func (r *RetransmitBuffer) ResyncFrom(seq uint64, protocol Protocol) (err error) {
+	for _, msg := range r.Messages(seq) {
+		if err = protocol.SendSharedMessage(msg, uint32(0)); err != nil {
+			return
+		}
+	}
+	return
+}
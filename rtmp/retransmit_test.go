@@ -0,0 +1,68 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRetransmitResyncFrom(t *testing.T) {
+	client, server := loopback(t)
+
+	rb := NewRetransmitBuffer(3)
+	var msgs []*Message
+	for i := 0; i < 5; i++ {
+		msg := new_av_message(RTMP_MSG_VideoMessage, uint64(1000 + 40 * i), []byte{0x27, 0x01, byte(i)})
+		msgs = append(msgs, msg)
+		if seq := rb.Push(msg); seq != uint64(i + 1) {
+			t.Fatalf("seq %v, want %v", seq, i + 1)
+		}
+	}
+
+	// the oldest two messages are overwritten.
+	if v := rb.Messages(1); len(v) != 3 || v[0] != msgs[2] || v[2] != msgs[4] {
+		t.Fatalf("messages %v, want the last 3", v)
+	}
+
+	// replay twice, the shared message is never changed.
+	for i := 0; i < 2; i++ {
+		if err := rb.ResyncFrom(4, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		want := msgs[3 + i % 2]
+		msg, err := server.RecvMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Header.Timestamp != want.Header.Timestamp || !bytes.Equal(msg.Payload, want.Payload) {
+			t.Errorf("#%v got ts=%v %x, want ts=%v %x", i, msg.Header.Timestamp, msg.Payload, want.Header.Timestamp, want.Payload)
+		}
+	}
+	for i, msg := range msgs {
+		if msg.Header.Timestamp != uint64(1000 + 40 * i) || msg.Header.StreamId != 1 {
+			t.Errorf("#%v buffered message changed, %+v", i, msg.Header)
+		}
+	}
+}
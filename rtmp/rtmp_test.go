@@ -0,0 +1,517 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// send the raw command payload, for example, captured from encoder.
+// @remark it's ok to call in other goroutine, the error is reported by t.Error.
+func send_raw_command(t testing.TB, p Protocol, payload string, stream_id uint32) {
+	t.Helper()
+
+	msg := NewMessage()
+	msg.PerferCid = RTMP_CID_OverConnection
+	msg.Header.MessageType = RTMP_MSG_AMF0CommandMessage
+	msg.Header.PayloadLength = uint32(len(payload))
+	msg.Payload = []byte(payload)
+	if err := p.SendMessage(msg, stream_id); err != nil {
+		t.Error(err)
+	}
+}
+
+// recv the message and decode it to packet.
+func recv_packet(t testing.TB, p Protocol) (pkt interface {}) {
+	t.Helper()
+
+	msg, err := p.RecvMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt, err = p.DecodeMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestFMLEPublishSequence(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	// the commands captured from FMLE 3.2 after connect.
+	go func() {
+		send_raw_command(t, client, "\x02\x00\x0dreleaseStream\x00\x40\x00\x00\x00\x00\x00\x00\x00\x05\x02\x00\x0alivestream", 0)
+		send_raw_command(t, client, "\x02\x00\x09FCPublish\x00\x40\x08\x00\x00\x00\x00\x00\x00\x05\x02\x00\x0alivestream", 0)
+		send_raw_command(t, client, "\x02\x00\x0ccreateStream\x00\x40\x10\x00\x00\x00\x00\x00\x00\x05", 0)
+		send_raw_command(t, client, "\x02\x00\x07publish\x00\x40\x14\x00\x00\x00\x00\x00\x00\x05\x02\x00\x0alivestream\x02\x00\x04live", 1)
+	}()
+
+	client_type, stream_name, err := srv.IdentifyClient(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client_type != CLIENT_TYPE_FMLEPublish || stream_name != "livestream" {
+		t.Fatalf("identify %v %v", client_type, stream_name)
+	}
+	if err = srv.StartFMLEPublish(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the _result of releaseStream, FCPublish and createStream.
+	for _, tid := range []float64{2, 3} {
+		if pkt, ok := recv_packet(t, client).(*CallResPacket); !ok || pkt.TransactionId != tid {
+			t.Fatalf("expect _result of tid %v, got %+v", tid, pkt)
+		}
+	}
+	if pkt, ok := recv_packet(t, client).(*CallResPacket); !ok || pkt.TransactionId != 4 {
+		t.Fatalf("expect _result of createStream, got %+v", pkt)
+	} else if v, _ := pkt.Response.Number(); v != 1 {
+		t.Errorf("stream id %v, expect 1", v)
+	}
+
+	// onFCPublish and onStatus(NetStream.Publish.Start)
+	for _, command := range []string{AMF0_COMMAND_ON_FC_PUBLISH, AMF0_COMMAND_ON_STATUS} {
+		pkt, ok := recv_packet(t, client).(*CallPacket)
+		if !ok || pkt.CommandName != command {
+			t.Fatalf("expect %v, got %+v", command, pkt)
+		}
+		info, _ := pkt.Arguments[0].Object()
+		if code, _ := info.GetPropertyString(SCODE); code != SCODE_PublishStart {
+			t.Errorf("%v code=%v", command, code)
+		}
+	}
+}
+
+func TestFlashPublishStreamName(t *testing.T) {
+	for stream_name, desc := range map[string]string{"livestream":"Started publishing stream livestream.", "":"Started publishing stream."} {
+		p, _ := new_mock_protocol(nil)
+		srv := new_server(p)
+		if err := srv.StartFlashPublishWithName(1, stream_name); err != nil {
+			t.Fatal(err)
+		}
+
+		msgs := queued_messages(p)
+		if len(msgs) != 1 {
+			t.Fatalf("expect onStatus, got %v messages", len(msgs))
+		}
+		pkt, ok := decode_packet(t, msgs[0].Header.MessageType, string(msgs[0].Payload)).(*CallPacket)
+		if !ok || pkt.CommandName != AMF0_COMMAND_ON_STATUS {
+			t.Fatalf("expect onStatus, got %+v", pkt)
+		}
+
+		info, _ := pkt.Arguments[0].Object()
+		if v, _ := info.GetPropertyString(SDESC); v != desc {
+			t.Errorf("description %q, expect %q", v, desc)
+		}
+		if v, _ := info.GetPropertyString(SDETAILS); v != stream_name {
+			t.Errorf("details %q, expect %q", v, stream_name)
+		}
+	}
+}
+
+func TestSendSequenceHeaders(t *testing.T) {
+	cache := NewStreamCache()
+	vsh := new_av_message(RTMP_MSG_VideoMessage, 0, []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01})
+	ash := new_av_message(RTMP_MSG_AudioMessage, 0, []byte{0xaf, 0x00, 0x12, 0x10})
+	frame := new_av_message(RTMP_MSG_VideoMessage, 40, []byte{0x17, 0x01, 0x00, 0x00, 0x00})
+	for _, msg := range []*Message{ash, vsh, frame} {
+		cache.Cache(msg)
+	}
+
+	// the player of stream 5, send the headers then the first frame.
+	p, _ := new_mock_protocol(nil)
+	srv := new_server(p)
+	if err := srv.SendSequenceHeaders(5, cache); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SendSharedMessage(frame, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := queued_messages(p)
+	if len(msgs) != 3 {
+		t.Fatalf("expect 3 messages, got %v", len(msgs))
+	}
+	for i, expect := range []*Message{vsh, ash, frame} {
+		if !bytes.Equal(msgs[i].Payload, expect.Payload) || msgs[i].Header.StreamId != 5 {
+			t.Errorf("#%v is %x of stream %v, expect %x", i, msgs[i].Payload, msgs[i].Header.StreamId, expect.Payload)
+		}
+	}
+}
+
+func TestResponseConnectAppWithBandwidth(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	go func() {
+		if err := srv.ReponseConnectAppWithBandwidth(NewRequest(), "", nil, 2500000, PeerBandwidthSoft); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the Set Peer Bandwidth is sent before the connect response.
+	bw, ok := recv_packet(t, client).(*SetPeerBandwidthPacket)
+	if !ok {
+		t.Fatalf("recv %T, want set peer bandwidth", bw)
+	}
+	if bw.Bandwidth != 2500000 || bw.BandwidthType != PeerBandwidthSoft {
+		t.Errorf("bandwidth=%v type=%v", bw.Bandwidth, bw.BandwidthType)
+	}
+	if bandwidth, bw_type := client.PeerBandwidth(); bandwidth != 2500000 || bw_type != PeerBandwidthSoft {
+		t.Errorf("protocol bandwidth=%v type=%v", bandwidth, bw_type)
+	}
+
+	msg, err := client.RecvMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.Header.IsAmf0Command() {
+		t.Errorf("recv message type %v, want connect response", msg.Header.MessageType)
+	}
+}
+
+// response the connect by server, return the props of _result.
+func recv_connect_props(t *testing.T, client Protocol, srv Server) (props *Amf0Object) {
+	t.Helper()
+
+	go func() {
+		if err := srv.ReponseConnectApp(NewRequest(), "", nil); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, ok := recv_packet(t, client).(*CallResPacket)
+	if !ok {
+		t.Fatalf("recv %T, want connect response", res)
+	}
+	if props, ok = res.CommandObject.Object(); !ok {
+		t.Fatal("no props object")
+	}
+	return
+}
+
+func TestSetFmsVersion(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	props := recv_connect_props(t, client, srv)
+	if v, _ := props.GetPropertyString("fmsVer"); v != "FMS/" + SIG_FMS_VER {
+		t.Errorf("default fmsVer=%v", v)
+	}
+	if v, _ := props.GetPropertyNumber("capabilities"); v != SIG_FMS_CAPABILITIES {
+		t.Errorf("default capabilities=%v", v)
+	}
+
+	srv.SetFmsVersion("5,0,15,5004", 255)
+	props = recv_connect_props(t, client, srv)
+	if v, _ := props.GetPropertyString("fmsVer"); v != "FMS/5,0,15,5004" {
+		t.Errorf("fmsVer=%v", v)
+	}
+	if v, _ := props.GetPropertyNumber("capabilities"); v != 255 {
+		t.Errorf("capabilities=%v", v)
+	}
+
+	// the other server is not changed.
+	client, sp = loopback(t)
+	props = recv_connect_props(t, client, new_server(sp))
+	if v, _ := props.GetPropertyString("fmsVer"); v != "FMS/" + SIG_FMS_VER {
+		t.Errorf("other server fmsVer=%v", v)
+	}
+}
+
+func TestConnectAppEmptyObject(t *testing.T) {
+	const connect = "\x02\x00\x07connect\x00\x3f\xf0\x00\x00\x00\x00\x00\x00"
+	for _, c := range []struct {
+		name string
+		object string
+		ok bool
+	}{
+		{"empty", "\x03\x00\x00\x09", true},
+		{"null", "\x05", true},
+		{"no object", "", true},
+		{"no tcUrl", "\x03\x00\x03app\x02\x00\x04live\x00\x00\x09", false},
+	} {
+		client, sp := loopback(t)
+		srv := new_server(sp)
+		go send_raw_command(t, client, connect + c.object, 0)
+
+		req := NewRequest()
+		err := srv.ConnectApp(req)
+		if c.ok && (err != nil || req.TcUrl != "" || req.App != "") {
+			t.Errorf("%v: err=%v, tcUrl=%q, app=%q", c.name, err, req.TcUrl, req.App)
+		}
+		if v, ok := err.(Error); !c.ok && (!ok || v.code != ERROR_RTMP_REQ_CONNECT) {
+			t.Errorf("%v: got %v, want connect error", c.name, err)
+		}
+	}
+}
+
+func TestConnectAppObjectEncodingAmf3(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	// the AMF0 connect with {tcUrl:"rtmp://127.0.0.1/live", objectEncoding:3}.
+	go send_raw_command(t, client, "\x02\x00\x07connect\x00\x3f\xf0\x00\x00\x00\x00\x00\x00" +
+		"\x03\x00\x05tcUrl\x02\x00\x15rtmp://127.0.0.1/live" +
+		"\x00\x0eobjectEncoding\x00\x40\x08\x00\x00\x00\x00\x00\x00" + "\x00\x00\x09", 0)
+
+	req := NewRequest()
+	if err := srv.ConnectApp(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ObjectEncoding != CodecAMF3 || sp.ObjectEncoding() != CodecAMF3 {
+		t.Fatalf("objectEncoding=%v, protocol=%v", req.ObjectEncoding, sp.ObjectEncoding())
+	}
+
+	go func() {
+		if err := srv.ReponseConnectApp(req, "", nil); err != nil {
+			t.Error(err)
+		}
+		if err := srv.CallOnBWDone(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the responses are the AMF3 command, 1byte 0 then the AMF0 command.
+	for _, name := range []string{AMF0_COMMAND_RESULT, AMF0_COMMAND_ON_BW_DONE} {
+		msg, err := client.RecvMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Header.MessageType != RTMP_MSG_AMF3CommandMessage {
+			t.Fatalf("%v message type %v, expect %v", name, msg.Header.MessageType, RTMP_MSG_AMF3CommandMessage)
+		}
+		if len(msg.Payload) == 0 || msg.Payload[0] != 0 {
+			t.Fatalf("%v payload %x, expect 0 prefix", name, msg.Payload)
+		}
+
+		pkt, err := client.DecodeMessage(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != AMF0_COMMAND_RESULT {
+			continue
+		}
+		res, ok := pkt.(*CallResPacket)
+		if !ok {
+			t.Fatalf("decode %T, expect _result", pkt)
+		}
+		info, ok := res.Response.Object()
+		if !ok {
+			t.Fatal("no info object")
+		}
+		if v, ok := info.GetPropertyNumber("objectEncoding"); !ok || v != CodecAMF3 {
+			t.Errorf("info objectEncoding=%v, expect %v", v, CodecAMF3)
+		}
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	if err := srv.StartPlay(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the second Close is a no-op.
+	if err := srv.Close(); err != nil {
+		t.Errorf("second close got %v", err)
+	}
+
+	// the started stream is teardown by StreamEOF once.
+	var eof []uint32
+	for {
+		msg, err := client.RecvMessage()
+		if err != nil {
+			break
+		}
+		if pkt, err := client.DecodeMessage(msg); err == nil {
+			if pkt, ok := pkt.(*UserControlPacket); ok && pkt.EventType == PCUCStreamEOF {
+				eof = append(eof, pkt.EventData)
+			}
+		}
+	}
+	if len(eof) != 1 || eof[0] != 1 {
+		t.Errorf("StreamEOF of streams %v, want [1]", eof)
+	}
+}
+
+func TestSendStreamIsRecorded(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	if err := srv.SendStreamIsRecorded(5); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := client.RecvMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.MessageType != RTMP_MSG_UserControlMessage || msg.Header.StreamId != 0 {
+		t.Errorf("type=%v, stream_id=%v", msg.Header.MessageType, msg.Header.StreamId)
+	}
+	// event type 4, then the stream id, both big-endian.
+	if want := []byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x05}; !bytes.Equal(msg.Payload, want) {
+		t.Errorf("payload %x, want %x", msg.Payload, want)
+	}
+}
+
+func TestBandwidthCheck(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	// the client call _checkbw, response each _onbwcheck by _result.
+	rounds := make(chan int, 1)
+	done := make(chan *CallPacket, 1)
+	go func() {
+		defer close(done)
+
+		if err := client.SendPacket(NewCheckBandwidthPacket(), 0); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var nb_rounds int
+		for {
+			msg, err := client.RecvMessage()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			pkt, err := client.DecodeMessage(msg)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			call, ok := pkt.(*CallPacket)
+			if !ok {
+				continue
+			}
+			if call.CommandName == AMF0_COMMAND_ON_BW_CHECK_DONE {
+				rounds <- nb_rounds
+				done <- call
+				return
+			}
+			if call.CommandName != AMF0_COMMAND_ON_BW_CHECK {
+				t.Errorf("unexpected command %v", call.CommandName)
+				return
+			}
+			nb_rounds++
+
+			res := NewCallResPacket()
+			res.TransactionId = call.TransactionId
+			if err := client.SendPacket(res, 0); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	var check *CheckBandwidthPacket
+	if _, err := sp.ExpectPacket(&check); err != nil {
+		t.Fatal(err)
+	}
+	if check.CommandName != AMF0_COMMAND_CHECK_BW {
+		t.Errorf("command is %v", check.CommandName)
+	}
+
+	kbps, _, err := srv.BandwidthCheck(3, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kbps <= 0 {
+		t.Errorf("kbps is %v", kbps)
+	}
+
+	call := <-done
+	if call == nil {
+		t.Fatal("no _onbwdone")
+	}
+	// the first round without payload for latency, and 3 rounds of payload.
+	if n := <-rounds; n != 4 {
+		t.Errorf("rounds is %v, expect 4", n)
+	}
+	if len(call.Arguments) != 4 {
+		t.Fatalf("_onbwdone arguments is %v, expect 4", len(call.Arguments))
+	}
+	if v, ok := call.Arguments[1].Number(); !ok || v != 3 {
+		t.Errorf("deltaDown is %v, expect 3KB", v)
+	}
+}
+
+func TestSendStreamEvents(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	for _, c := range []struct {
+		name string
+		send func(stream_id uint32) (error)
+		payload []byte
+	}{
+		// event type then the stream id, both big-endian.
+		{"StreamEOF", srv.SendStreamEOF, []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x07}},
+		{"StreamDry", srv.SendStreamDry, []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x07}},
+		{"StreamIsRecorded", srv.SendStreamIsRecorded, []byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x07}},
+	} {
+		if err := c.send(7); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := client.RecvMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Header.MessageType != RTMP_MSG_UserControlMessage || msg.Header.StreamId != 0 {
+			t.Errorf("%v type=%v, stream_id=%v", c.name, msg.Header.MessageType, msg.Header.StreamId)
+		}
+		if !bytes.Equal(msg.Payload, c.payload) {
+			t.Errorf("%v payload %x, want %x", c.name, msg.Payload, c.payload)
+		}
+	}
+}
+
+func TestSendSampleAccess(t *testing.T) {
+	client, sp := loopback(t)
+	srv := new_server(sp)
+
+	if err := srv.SendSampleAccess(7, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// |RtmpSampleAccess(video, audio), the data message over the stream.
+	msg, err := client.RecvMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.MessageType != 18 || msg.Header.StreamId != 7 {
+		t.Errorf("type=%v, stream_id=%v, expect data message 18", msg.Header.MessageType, msg.Header.StreamId)
+	}
+	if expect := "\x02\x00\x11|RtmpSampleAccess\x01\x00\x01\x01"; string(msg.Payload) != expect {
+		t.Errorf("payload %q, expect %q", msg.Payload, expect)
+	}
+}
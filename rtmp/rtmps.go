@@ -0,0 +1,58 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+)
+
+/**
+* the RTMPS, the RTMP over TLS, required by many CDNs to ingest over 443.
+* the protocol read/write the tls conn transparently, for example:
+* 		listener, err := rtmp.ListenRTMPS(":443", config)
+* 		conn, err := listener.Accept()
+* 		server, err := rtmp.NewServer(conn)
+*/
+
+// listen at addr for RTMPS, use DefaultRTMPSPort when port not specified.
+func ListenRTMPS(addr string, config *tls.Config) (net.Listener, error) {
+	return tls.Listen("tcp", rtmps_addr(addr), config)
+}
+
+/**
+* dial the RTMPS server at addr, use DefaultRTMPSPort when port not specified.
+* @param config the tls config, nil to use the default config,
+* 		where the ServerName is set to the host of addr.
+*/
+func DialRTMPS(addr string, config *tls.Config) (net.Conn, error) {
+	return tls.Dial("tcp", rtmps_addr(addr), config)
+}
+
+// append the default RTMPS port to addr when not specified.
+func rtmps_addr(addr string) (string) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, fmt.Sprintf("%v", DefaultRTMPSPort))
+	}
+	return addr
+}
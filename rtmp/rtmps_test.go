@@ -0,0 +1,101 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// the self-signed certificate for localhost.
+func self_signed_cert(t testing.TB) (tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName:"localhost"},
+		DNSNames: []string{"localhost"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate:[][]byte{der}, PrivateKey:key}
+}
+
+func TestRTMPSOverPipe(t *testing.T) {
+	cert := self_signed_cert(t)
+	pool := x509.NewCertPool()
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+	pool.AddCert(leaf)
+
+	c, s := net.Pipe()
+	tc := tls.Client(c, &tls.Config{ServerName:"localhost", RootCAs:pool})
+	ts := tls.Server(s, &tls.Config{Certificates:[]tls.Certificate{cert}})
+	client, _ := NewProtocol(tc)
+	server, _ := NewProtocol(ts)
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+		client.Close()
+		server.Close()
+	})
+
+	// the tls handshake is done in the first read/write of rtmp handshake.
+	client_err := make(chan error, 1)
+	go func() {
+		if err := client.SimpleHandshake2Server(); err != nil {
+			client_err <- err
+			return
+		}
+		client_err <- client.SendPacket(NewCreateStreamPacket(), 0)
+	}()
+
+	if err := server.SimpleHandshake2Client(); err != nil {
+		c.Close()
+		t.Fatal(err)
+	}
+	var pkt *CreateStreamPacket
+	if _, err := server.ExpectPacket(&pkt); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-client_err; err != nil {
+		t.Fatal(err)
+	}
+	if !tc.ConnectionState().HandshakeComplete {
+		t.Error("the tls handshake is not complete")
+	}
+}
@@ -22,32 +22,183 @@
 package rtmp
 
 import (
+	"io"
 	"net"
 	"fmt"
+	"time"
+	"sync/atomic"
 )
 
-// socket to read or write data.
+// the max bytes to coalesce the small buffers to write, for the conn without writev.
+const RTMP_COALESCE_BUFFER_SIZE = 64 * 1024
+
+/**
+* socket to read or write data.
+* the conn can be any net.Conn, for example, the *net.TCPConn,
+* the *tls.Conn for RTMPS, or the net.Pipe for test,
+* the tcp specified options must use type assertion to *net.TCPConn.
+*/
 type Socket struct {
-	conn *net.TCPConn
+	conn net.Conn
+	// the bytes read/write, get in other goroutine, use atomic.
 	recv_bytes uint64
 	send_bytes uint64
+	// the timeout of each read/write, in time.Duration,
+	// <=0 to never timeout. set in other goroutine, use atomic.
+	recv_timeout int64
+	send_timeout int64
+	// whether the read/write is aborted, set in other goroutine, use atomic.
+	read_aborted int32
+	write_aborted int32
+	// the buffers to writev, reuse it to avoid alloc for each writev.
+	writev_bufs net.Buffers
+	// the buffer to coalesce the small buffers, for the conn without writev.
+	coalesce_buffer []byte
 }
-func NewSocket(conn *net.TCPConn) (*Socket) {
+func NewSocket(conn net.Conn) (*Socket) {
 	r := &Socket{}
 	r.conn = conn
 	return r
 }
 
+// close the underlayer connection.
+func (r *Socket) Close() (err error) {
+	return r.conn.Close()
+}
+
+// the address of peer, for example, 192.168.1.10:50410
+func (r *Socket) RemoteAddr() (string) {
+	if addr := r.conn.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
 func (r *Socket) RecvBytes() (uint64) {
-	return r.recv_bytes
+	return atomic.LoadUint64(&r.recv_bytes)
 }
 
 func (r *Socket) SendBytes() (uint64) {
-	return r.send_bytes
+	return atomic.LoadUint64(&r.send_bytes)
+}
+
+/**
+* set the timeout of each read/write, <=0 to never timeout,
+* when timeout, the read/write return the ERROR_SOCKET_TIMEOUT error,
+* the deadline is applied to the blocked read/write immediately.
+* @see IsTimeout
+*/
+func (r *Socket) SetRecvTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&r.recv_timeout, int64(timeout))
+	r.conn.SetReadDeadline(socket_deadline(timeout))
+}
+func (r *Socket) SetSendTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&r.send_timeout, int64(timeout))
+	r.conn.SetWriteDeadline(socket_deadline(timeout))
+}
+
+// the deadline of timeout, zero time to never timeout.
+func socket_deadline(timeout time.Duration) (t time.Time) {
+	if timeout > 0 {
+		t = time.Now().Add(timeout)
+	}
+	return
+}
+
+/**
+* abort the blocked read/write immediately, by set the deadline to now,
+* the read/write return the ERROR_SOCKET_TIMEOUT error, and the later
+* read/write fail immediately, the socket is broken.
+*/
+func (r *Socket) AbortRead() (err error) {
+	atomic.StoreInt32(&r.read_aborted, 1)
+	return r.conn.SetReadDeadline(time.Now())
+}
+func (r *Socket) AbortWrite() (err error) {
+	atomic.StoreInt32(&r.write_aborted, 1)
+	return r.conn.SetWriteDeadline(time.Now())
+}
+
+// check the aborted after set the deadline, never lost the abort.
+func socket_aborted(aborted *int32, desc string) (err error) {
+	if atomic.LoadInt32(aborted) != 0 {
+		return Error{code:ERROR_SOCKET_TIMEOUT, desc:fmt.Sprintf("%v aborted", desc)}
+	}
+	return
+}
+
+/**
+* set the kernel buffer size of conn, the SO_RCVBUF and SO_SNDBUF,
+* only for the conn which supports it, for example, the *net.TCPConn,
+* the *tls.Conn use the underlayer conn, others are ignored.
+*/
+func (r *Socket) SetRecvBuffer(size int) (err error) {
+	if conn, ok := socket_buffer_conn(r.conn); ok {
+		return conn.SetReadBuffer(size)
+	}
+	return
+}
+func (r *Socket) SetSendBuffer(size int) (err error) {
+	if conn, ok := socket_buffer_conn(r.conn); ok {
+		return conn.SetWriteBuffer(size)
+	}
+	return
+}
+
+// the conn which can set the kernel buffer, for example, the *net.TCPConn.
+type socket_buffer_setter interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// get the conn to set buffer, unwrap the *tls.Conn to the underlayer conn.
+func socket_buffer_conn(conn net.Conn) (v socket_buffer_setter, ok bool) {
+	if v, ok = conn.(socket_buffer_setter); ok {
+		return
+	}
+	if c, ok := conn.(interface { NetConn() net.Conn }); ok {
+		return socket_buffer_conn(c.NetConn())
+	}
+	return
+}
+
+// whether the err is the timeout error of socket.
+func IsTimeout(err error) (bool) {
+	if err, ok := err.(Error); ok {
+		return err.code == ERROR_SOCKET_TIMEOUT
+	}
+	return false
+}
+
+/**
+* wrap the error of conn to the Error of code, for errors.Is to match
+* the ErrTimeout or ErrIO, the io.EOF is the peer closed.
+*/
+func socket_error(err error, code int, desc string) (error) {
+	if _, ok := err.(Error); ok {
+		return err
+	}
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return Error{code:ERROR_SOCKET_TIMEOUT, desc:fmt.Sprintf("%v timeout, %v", desc, err)}
+	}
+	if err == io.EOF {
+		return Error{code:ERROR_SOCKET_CLOSED, desc:fmt.Sprintf("%v peer closed, %v", desc, err)}
+	}
+	return Error{code:code, desc:fmt.Sprintf("%v failed, %v", desc, err)}
 }
 
 func (r *Socket) Read(b []byte) (n int, err error) {
+	if timeout := time.Duration(atomic.LoadInt64(&r.recv_timeout)); timeout > 0 {
+		if err = r.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return
+		}
+	}
+	if err = socket_aborted(&r.read_aborted, "read"); err != nil {
+		return
+	}
+
 	if n, err = r.conn.Read(b); err != nil {
+		err = socket_error(err, ERROR_SOCKET_READ, "read")
 		return
 	}
 
@@ -66,16 +217,26 @@ func (r *Socket) Read(b []byte) (n int, err error) {
 	}
 
 	if n > 0 {
-		r.recv_bytes += uint64(n)
+		atomic.AddUint64(&r.recv_bytes, uint64(n))
 	}
 
 	return
 }
 
 func (r *Socket) Write(b []byte) (n int, err error) {
+	if timeout := time.Duration(atomic.LoadInt64(&r.send_timeout)); timeout > 0 {
+		if err = r.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return
+		}
+	}
+	if err = socket_aborted(&r.write_aborted, "write"); err != nil {
+		return
+	}
+
 	for n < len(b) {
 		var nb_written int
 		if nb_written, err = r.conn.Write(b[n:]); err != nil {
+			err = socket_error(err, ERROR_SOCKET_WRITE, "write")
 			return
 		}
 
@@ -93,7 +254,7 @@ func (r *Socket) Write(b []byte) (n int, err error) {
 			return
 		}
 
-		r.send_bytes += uint64(nb_written)
+		atomic.AddUint64(&r.send_bytes, uint64(nb_written))
 		n += nb_written
 
 		if n < len(b) {
@@ -104,3 +265,78 @@ func (r *Socket) Write(b []byte) (n int, err error) {
 
 	return
 }
+
+/**
+* write the buffers in one call, use the writev when the conn supports it,
+* for example, the *net.TCPConn, or coalesce the small buffers to write,
+* for example, the *tls.Conn which write a record for each write,
+* the timeout is for all buffers.
+* @remark the bufs is consumed, the slices in it is changed.
+* @remark not goroutine safe, only call it in one goroutine.
+*/
+func (r *Socket) Writev(bufs [][]byte) (n int64, err error) {
+	if timeout := time.Duration(atomic.LoadInt64(&r.send_timeout)); timeout > 0 {
+		if err = r.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return
+		}
+	}
+	if err = socket_aborted(&r.write_aborted, "writev"); err != nil {
+		return
+	}
+
+	switch r.conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		r.writev_bufs = net.Buffers(bufs)
+		n, err = r.writev_bufs.WriteTo(r.conn)
+		r.writev_bufs = nil
+	default:
+		n, err = r.write_coalesced(bufs)
+	}
+	if n > 0 {
+		atomic.AddUint64(&r.send_bytes, uint64(n))
+	}
+	if err != nil {
+		err = socket_error(err, ERROR_SOCKET_WRITE, "writev")
+	}
+	return
+}
+
+/**
+* write the buffers for the conn without writev, the small buffers is
+* copied to the coalesce buffer to write in one call, at most
+* RTMP_COALESCE_BUFFER_SIZE, the large buffer is written without copy.
+*/
+func (r *Socket) write_coalesced(bufs [][]byte) (n int64, err error) {
+	if r.coalesce_buffer == nil {
+		r.coalesce_buffer = make([]byte, 0, RTMP_COALESCE_BUFFER_SIZE)
+	}
+
+	var nn int
+	b := r.coalesce_buffer[:0]
+	for _, buf := range bufs {
+		// flush the coalesced bytes when full.
+		if len(b) > 0 && len(b) + len(buf) > cap(b) {
+			nn, err = r.conn.Write(b)
+			if n += int64(nn); err != nil {
+				return
+			}
+			b = b[:0]
+		}
+
+		if len(buf) < cap(b) {
+			b = append(b, buf...)
+			continue
+		}
+
+		nn, err = r.conn.Write(buf)
+		if n += int64(nn); err != nil {
+			return
+		}
+	}
+
+	if len(b) > 0 {
+		nn, err = r.conn.Write(b)
+		n += int64(nn)
+	}
+	return
+}
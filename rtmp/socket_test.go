@@ -0,0 +1,134 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestWritevCoalesce(t *testing.T) {
+	// the 256KB message at 4KB chunk size, 64 headers and 64 payloads.
+	var bufs [][]byte
+	var want []byte
+	for i := 0; i < 64; i++ {
+		header, payload := []byte{0xc6}, bytes.Repeat([]byte{byte(i)}, 4096)
+		bufs = append(bufs, header, payload)
+		want = append(append(want, header...), payload...)
+	}
+
+	conn := new_mock_conn(nil)
+	s := NewSocket(conn)
+	n, err := s.Writev(bufs)
+	if err != nil || n != int64(len(want)) {
+		t.Fatalf("writev %v bytes, err=%v, want %v bytes", n, err, len(want))
+	}
+	if !bytes.Equal(conn.w.Bytes(), want) {
+		t.Error("the written bytes corrupt")
+	}
+	if max := len(want) / (RTMP_COALESCE_BUFFER_SIZE - 4096) + 1; conn.writes > max {
+		t.Errorf("%v writes, want at most %v", conn.writes, max)
+	}
+
+	// the large buffer is written without copy.
+	conn = new_mock_conn(nil)
+	s = NewSocket(conn)
+	large := make([]byte, RTMP_COALESCE_BUFFER_SIZE)
+	if _, err = s.Writev([][]byte{{0x06}, large, {0xc6}}); err != nil {
+		t.Fatal(err)
+	}
+	if conn.writes != 3 || conn.w.Len() != len(large) + 2 {
+		t.Errorf("%v writes of %v bytes, want 3 writes", conn.writes, conn.w.Len())
+	}
+}
+
+func BenchmarkWritevSyscalls(b *testing.B) {
+	// the 256KB message at 4KB chunk size, 64 headers and 64 payloads.
+	var template, bufs [][]byte
+	for i := 0; i < 64; i++ {
+		template = append(template, []byte{0xc6}, make([]byte, 4096))
+	}
+	// the bufs is consumed by write, copy from the template.
+	new_bufs := func() ([][]byte) {
+		bufs = append(bufs[:0], template...)
+		return bufs
+	}
+
+	// write the buffers one by one, without writev.
+	b.Run("Sequential", func(b *testing.B) {
+		conn := new_mock_conn(nil)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			conn.w.Reset()
+			v := net.Buffers(new_bufs())
+			if _, err := v.WriteTo(conn); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(conn.writes) / float64(b.N), "writes/op")
+	})
+
+	// the conn without writev, for example, the *tls.Conn.
+	b.Run("Coalesced", func(b *testing.B) {
+		conn := new_mock_conn(nil)
+		s := NewSocket(conn)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			conn.w.Reset()
+			if _, err := s.Writev(new_bufs()); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(conn.writes) / float64(b.N), "writes/op")
+	})
+
+	// the *net.TCPConn use one writev syscall.
+	b.Run("TCPWritev", func(b *testing.B) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer ln.Close()
+		go func() {
+			if conn, err := ln.Accept(); err == nil {
+				io.Copy(io.Discard, conn)
+			}
+		}()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer conn.Close()
+
+		s := NewSocket(conn)
+		b.ReportAllocs()
+		b.SetBytes(64 * 4097)
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Writev(new_bufs()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
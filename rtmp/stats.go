@@ -0,0 +1,110 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rtmp
+
+import (
+	"sync"
+)
+
+/**
+* the statistic of connection, for dashboard and detect stalls.
+* get the snapshot by Protocol.Stats(), which is safe in any goroutine.
+*/
+type Stats struct {
+	// the total bytes read/write from/to the connection.
+	RecvBytes uint64
+	SendBytes uint64
+	// the messages received/sent, key is the message type,
+	// for example, RTMP_MSG_VideoMessage.
+	RecvMessages map[byte]uint64
+	SendMessages map[byte]uint64
+	// the current input/output chunk size.
+	InChunkSize uint32
+	OutChunkSize uint32
+	// the bytes acked to peer, @see AckWindowSize.
+	AckedSize uint64
+}
+
+// the statistic updated by protocol goroutines.
+type protocol_stats struct {
+	lock *sync.Mutex
+	stats Stats
+}
+func new_protocol_stats() (*protocol_stats) {
+	r := &protocol_stats{}
+	r.lock = &sync.Mutex{}
+	r.stats.RecvMessages = map[byte]uint64{}
+	r.stats.SendMessages = map[byte]uint64{}
+	return r
+}
+
+func (r *protocol_stats) on_recv_message(message_type byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stats.RecvMessages[message_type]++
+}
+
+func (r *protocol_stats) on_send_message(message_type byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stats.SendMessages[message_type]++
+}
+
+func (r *protocol_stats) on_in_chunk_size(chunk_size uint32) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stats.InChunkSize = chunk_size
+}
+
+func (r *protocol_stats) on_out_chunk_size(chunk_size uint32) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stats.OutChunkSize = chunk_size
+}
+
+func (r *protocol_stats) on_acked(acked_size uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stats.AckedSize = acked_size
+}
+
+// copy the stats, the maps is copied.
+func (r *protocol_stats) snapshot() (v Stats) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	v = r.stats
+	v.RecvMessages = map[byte]uint64{}
+	for k, n := range r.stats.RecvMessages {
+		v.RecvMessages[k] = n
+	}
+	v.SendMessages = map[byte]uint64{}
+	for k, n := range r.stats.SendMessages {
+		v.SendMessages[k] = n
+	}
+	return
+}